@@ -28,6 +28,12 @@ func AutoMigrate() {
 	err := DB.AutoMigrate(
 		&models.User{},
 		&models.StackDraft{},
+		&models.StackRevision{},
+		&models.StackVariable{},
+		&models.StackDraftFile{},
+		&models.ExecSession{},
+		&models.ExecRecordingChunk{},
+		&models.ServiceMetric{},
 	)
 	if err != nil {
 		log.Fatal("Database migration failed:", err)
@@ -42,7 +48,7 @@ func InitDBForTesting() *gorm.DB {
 		panic("failed to connect to database")
 	}
 
-	err = DB.AutoMigrate(&models.User{})
+	err = DB.AutoMigrate(&models.User{}, &models.StackDraft{}, &models.StackRevision{}, &models.StackVariable{}, &models.StackDraftFile{}, &models.ExecSession{}, &models.ExecRecordingChunk{}, &models.ServiceMetric{})
 	if err != nil {
 		panic(fmt.Sprintf("failed to migrate database: %v", err))
 	}
@@ -0,0 +1,881 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/go-units"
+	"github.com/dockrelix/dockrelix-backend/models/parser"
+)
+
+func AddStackToName(name, stackName string) string {
+	return stackName + "_" + name
+}
+
+func stackLabels(stackName string, labels map[string]string) map[string]string {
+	result := map[string]string{}
+	for key, value := range labels {
+		result[key] = value
+	}
+	result["com.docker.stack.namespace"] = stackName
+	return result
+}
+
+func parseDuration(value string) (*time.Duration, error) {
+	if value == "" {
+		return nil, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return nil, err
+	}
+	return &duration, nil
+}
+
+func durationString(duration *time.Duration) string {
+	if duration == nil {
+		return ""
+	}
+	return duration.String()
+}
+
+func formatCPUs(nanoCPUs int64) string {
+	if nanoCPUs == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(float64(nanoCPUs)/1e9, 'f', -1, 64)
+}
+
+func formatMemory(bytes int64) string {
+	if bytes == 0 {
+		return ""
+	}
+	if bytes%(1024*1024) == 0 {
+		return strconv.FormatInt(bytes/(1024*1024), 10) + "M"
+	}
+	return strconv.FormatInt(bytes, 10)
+}
+
+func parseMemory(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return units.RAMInBytes(value)
+}
+
+func parseCPUs(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	cpus, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if cpus < 0 {
+		return 0, fmt.Errorf("invalid CPU value %q", value)
+	}
+	return int64(cpus * 1e9), nil
+}
+
+func parsePortRange(value string) (uint32, uint32, error) {
+	start, end, isRange := strings.Cut(value, "-")
+	first, err := strconv.ParseUint(start, 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port %q", value)
+	}
+	if !isRange {
+		return uint32(first), uint32(first), nil
+	}
+	last, err := strconv.ParseUint(end, 10, 16)
+	if err != nil || last < first {
+		return 0, 0, fmt.Errorf("invalid port range %q", value)
+	}
+	return uint32(first), uint32(last), nil
+}
+
+func formatPort(published, target uint32, protocol string) string {
+	return fmt.Sprintf("%d:%d/%s", published, target, protocol)
+}
+
+// ParsePort converts a short syntax port ("8080:80/udp", "9000-9001:9000-9001") into swarm port configs.
+func ParsePort(value string) ([]swarm.PortConfig, error) {
+	spec, protocol, hasProtocol := strings.Cut(value, "/")
+	if !hasProtocol {
+		protocol = "tcp"
+	}
+	if protocol != "tcp" && protocol != "udp" && protocol != "sctp" {
+		return nil, fmt.Errorf("invalid protocol %q in port %q", protocol, value)
+	}
+
+	parts := strings.Split(spec, ":")
+	var published, target string
+	switch len(parts) {
+	case 1:
+		target = parts[0]
+	case 2:
+		published, target = parts[0], parts[1]
+	default:
+		return nil, fmt.Errorf("invalid port %q: host IPs are not supported in swarm mode", value)
+	}
+
+	targetStart, targetEnd, err := parsePortRange(target)
+	if err != nil {
+		return nil, err
+	}
+
+	var publishedStart, publishedEnd uint32
+	if published != "" {
+		publishedStart, publishedEnd, err = parsePortRange(published)
+		if err != nil {
+			return nil, err
+		}
+		if publishedEnd-publishedStart != targetEnd-targetStart {
+			return nil, fmt.Errorf("invalid port %q: published and target ranges differ in size", value)
+		}
+	}
+
+	var ports []swarm.PortConfig
+	for i := uint32(0); i <= targetEnd-targetStart; i++ {
+		port := swarm.PortConfig{
+			Protocol:    swarm.PortConfigProtocol(protocol),
+			TargetPort:  targetStart + i,
+			PublishMode: swarm.PortConfigPublishModeIngress,
+		}
+		if published != "" {
+			port.PublishedPort = publishedStart + i
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+func isBindSource(source string) bool {
+	return strings.HasPrefix(source, "/") || strings.HasPrefix(source, ".") || strings.HasPrefix(source, "~")
+}
+
+// ParseVolume converts a short syntax volume ("data:/var/lib/data:ro") into a swarm mount.
+func ParseVolume(value, stackName string, volumes map[string]parser.Volume) (mount.Mount, error) {
+	parts := strings.Split(value, ":")
+	var result mount.Mount
+	switch len(parts) {
+	case 1:
+		result = mount.Mount{Type: mount.TypeVolume, Target: parts[0]}
+	case 2, 3:
+		result = mount.Mount{Source: parts[0], Target: parts[1]}
+		if len(parts) == 3 {
+			switch parts[2] {
+			case "ro":
+				result.ReadOnly = true
+			case "rw":
+			default:
+				return mount.Mount{}, fmt.Errorf("invalid volume mode %q in %q", parts[2], value)
+			}
+		}
+	default:
+		return mount.Mount{}, fmt.Errorf("invalid volume %q", value)
+	}
+
+	if !strings.HasPrefix(result.Target, "/") {
+		return mount.Mount{}, fmt.Errorf("invalid volume %q: target must be an absolute path", value)
+	}
+
+	if result.Source == "" {
+		return result, nil
+	}
+
+	if isBindSource(result.Source) {
+		result.Type = mount.TypeBind
+		return result, nil
+	}
+
+	result.Type = mount.TypeVolume
+	return namedVolume(result, stackName, volumes)
+}
+
+// namedVolume points a volume mount at the stack volume it names, taking labels and driver from its definition.
+func namedVolume(result mount.Mount, stackName string, volumes map[string]parser.Volume) (mount.Mount, error) {
+	vol, ok := volumes[result.Source]
+	if !ok {
+		return mount.Mount{}, fmt.Errorf("volume %q is not defined", result.Source)
+	}
+	result.Source = resourceName(result.Source, vol.Name, stackName, vol.External)
+	if vol.External {
+		return result, nil
+	}
+
+	if result.VolumeOptions == nil {
+		result.VolumeOptions = &mount.VolumeOptions{}
+	}
+	result.VolumeOptions.Labels = stackLabels(stackName, vol.Labels)
+	if vol.Driver != "" || len(vol.DriverOpts) > 0 {
+		result.VolumeOptions.DriverConfig = &mount.Driver{Name: vol.Driver, Options: vol.DriverOpts}
+	}
+	return result, nil
+}
+
+// ConvertPort converts a port in either syntax into swarm port configs.
+func ConvertPort(port parser.ServicePort) ([]swarm.PortConfig, error) {
+	if port.Short != "" {
+		return ParsePort(port.Short)
+	}
+
+	if port.Target == 0 || port.Target > 65535 {
+		return nil, fmt.Errorf("invalid target port %d", port.Target)
+	}
+
+	protocol := port.Protocol
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	if protocol != "tcp" && protocol != "udp" && protocol != "sctp" {
+		return nil, fmt.Errorf("invalid protocol %q", protocol)
+	}
+
+	result := swarm.PortConfig{
+		Protocol:    swarm.PortConfigProtocol(protocol),
+		TargetPort:  port.Target,
+		PublishMode: swarm.PortConfigPublishModeIngress,
+	}
+
+	switch port.Mode {
+	case "", "ingress":
+	case "host":
+		result.PublishMode = swarm.PortConfigPublishModeHost
+	default:
+		return nil, fmt.Errorf("invalid port mode %q", port.Mode)
+	}
+
+	if port.Published != "" {
+		start, end, err := parsePortRange(port.Published)
+		if err != nil {
+			return nil, err
+		}
+		if start != end {
+			return nil, fmt.Errorf("invalid published port %q: ranges need the short syntax", port.Published)
+		}
+		result.PublishedPort = start
+	}
+	return []swarm.PortConfig{result}, nil
+}
+
+// formatPortConfig uses the short syntax unless the port is published in host mode.
+func formatPortConfig(port swarm.PortConfig) parser.ServicePort {
+	if port.PublishMode != swarm.PortConfigPublishModeHost {
+		return parser.ServicePort{Short: formatPort(port.PublishedPort, port.TargetPort, string(port.Protocol))}
+	}
+
+	result := parser.ServicePort{
+		Target:   port.TargetPort,
+		Protocol: string(port.Protocol),
+		Mode:     string(port.PublishMode),
+	}
+	if port.PublishedPort != 0 {
+		result.Published = strconv.FormatUint(uint64(port.PublishedPort), 10)
+	}
+	return result
+}
+
+// tmpfsOptions parses the size and the octal mode of a tmpfs mount, both are optional.
+func tmpfsOptions(size, mode string) (*mount.TmpfsOptions, error) {
+	options := &mount.TmpfsOptions{}
+	if size != "" {
+		bytes, err := units.RAMInBytes(size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tmpfs size %q", size)
+		}
+		options.SizeBytes = bytes
+	}
+	if mode != "" {
+		bits, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tmpfs mode %q", mode)
+		}
+		options.Mode = os.FileMode(bits)
+	}
+	return options, nil
+}
+
+// ConvertTmpfs converts the short tmpfs syntax, a target optionally followed by mount options such as
+// /run:size=64m,mode=1777. Options other than size and mode are passed on to the mount as they are.
+func ConvertTmpfs(tmpfs string) (mount.Mount, error) {
+	target, opts, _ := strings.Cut(tmpfs, ":")
+	result := mount.Mount{Type: mount.TypeTmpfs, Target: target}
+	if opts == "" {
+		return result, nil
+	}
+
+	var size, mode string
+	var extra [][]string
+	for _, option := range strings.Split(opts, ",") {
+		key, value, hasValue := strings.Cut(option, "=")
+		switch {
+		case key == "size":
+			size = value
+		case key == "mode":
+			mode = value
+		case hasValue:
+			extra = append(extra, []string{key, value})
+		case key != "":
+			extra = append(extra, []string{key})
+		}
+	}
+
+	options, err := tmpfsOptions(size, mode)
+	if err != nil {
+		return mount.Mount{}, err
+	}
+	options.Options = extra
+	result.TmpfsOptions = options
+	return result, nil
+}
+
+// ConvertVolume converts a volume in either syntax into a swarm mount.
+func ConvertVolume(vol parser.ServiceVolume, stackName string, volumes map[string]parser.Volume) (mount.Mount, error) {
+	if vol.Short != "" {
+		return ParseVolume(vol.Short, stackName, volumes)
+	}
+
+	result := mount.Mount{
+		Type:     mount.Type(vol.Type),
+		Source:   vol.Source,
+		Target:   vol.Target,
+		ReadOnly: vol.ReadOnly,
+	}
+	if vol.Target == "" {
+		return mount.Mount{}, fmt.Errorf("volume target is required")
+	}
+	if result.Type != mount.TypeNamedPipe && !strings.HasPrefix(vol.Target, "/") {
+		return mount.Mount{}, fmt.Errorf("invalid volume target %q: must be an absolute path", vol.Target)
+	}
+
+	switch result.Type {
+	case mount.TypeBind:
+		if vol.Source == "" {
+			return mount.Mount{}, fmt.Errorf("bind mount of %q needs a source", vol.Target)
+		}
+		if vol.Bind != nil && vol.Bind.Propagation != "" {
+			result.BindOptions = &mount.BindOptions{Propagation: mount.Propagation(vol.Bind.Propagation)}
+		}
+	case mount.TypeVolume:
+		if vol.Volume != nil && (vol.Volume.NoCopy || vol.Volume.Subpath != "") {
+			result.VolumeOptions = &mount.VolumeOptions{NoCopy: vol.Volume.NoCopy, Subpath: vol.Volume.Subpath}
+		}
+		if vol.Source != "" {
+			return namedVolume(result, stackName, volumes)
+		}
+	case mount.TypeTmpfs:
+		if vol.Source != "" {
+			return mount.Mount{}, fmt.Errorf("tmpfs mount of %q cannot have a source", vol.Target)
+		}
+		if vol.Tmpfs != nil {
+			options, err := tmpfsOptions(vol.Tmpfs.Size, vol.Tmpfs.Mode)
+			if err != nil {
+				return mount.Mount{}, err
+			}
+			result.TmpfsOptions = options
+		}
+	case mount.TypeNamedPipe:
+	default:
+		return mount.Mount{}, fmt.Errorf("invalid volume type %q", vol.Type)
+	}
+	return result, nil
+}
+
+// formatTmpfs writes a tmpfs mount in the short syntax when the long one cannot hold its options or has nothing to add.
+func formatTmpfs(m mount.Mount) (string, bool) {
+	if m.Type != mount.TypeTmpfs || m.ReadOnly {
+		return "", false
+	}
+	options := m.TmpfsOptions
+	if options == nil || options.SizeBytes == 0 && options.Mode == 0 && len(options.Options) == 0 {
+		return m.Target, true
+	}
+	if len(options.Options) == 0 {
+		return "", false
+	}
+
+	var opts []string
+	if options.SizeBytes != 0 {
+		opts = append(opts, "size="+strconv.FormatInt(options.SizeBytes, 10))
+	}
+	if options.Mode != 0 {
+		opts = append(opts, "mode="+strconv.FormatUint(uint64(options.Mode), 8))
+	}
+	for _, option := range options.Options {
+		opts = append(opts, strings.Join(option, "="))
+	}
+	return m.Target + ":" + strings.Join(opts, ","), true
+}
+
+// formatMount uses the short syntax unless the mount has options only the long syntax can hold.
+func formatMount(m mount.Mount, stackName string) parser.ServiceVolume {
+	source := RemoveStackFromName(m.Source, stackName)
+
+	short := false
+	switch m.Type {
+	case mount.TypeBind:
+		short = m.BindOptions == nil || m.BindOptions.Propagation == ""
+	case mount.TypeVolume:
+		short = m.VolumeOptions == nil || (!m.VolumeOptions.NoCopy && m.VolumeOptions.Subpath == "")
+	}
+	if short {
+		value := m.Target
+		if source != "" {
+			value = source + ":" + value
+		}
+		if m.ReadOnly {
+			value += ":ro"
+		}
+		return parser.ServiceVolume{Short: value}
+	}
+
+	result := parser.ServiceVolume{
+		Type:     string(m.Type),
+		Source:   source,
+		Target:   m.Target,
+		ReadOnly: m.ReadOnly,
+	}
+	if m.BindOptions != nil && m.BindOptions.Propagation != "" {
+		result.Bind = &parser.ServiceVolumeBind{Propagation: string(m.BindOptions.Propagation)}
+	}
+	if m.VolumeOptions != nil && (m.VolumeOptions.NoCopy || m.VolumeOptions.Subpath != "") {
+		result.Volume = &parser.ServiceVolumeVolume{NoCopy: m.VolumeOptions.NoCopy, Subpath: m.VolumeOptions.Subpath}
+	}
+	if m.TmpfsOptions != nil && (m.TmpfsOptions.SizeBytes != 0 || m.TmpfsOptions.Mode != 0) {
+		result.Tmpfs = &parser.ServiceVolumeTmpfs{}
+		if m.TmpfsOptions.SizeBytes != 0 {
+			result.Tmpfs.Size = strconv.FormatInt(m.TmpfsOptions.SizeBytes, 10)
+		}
+		if m.TmpfsOptions.Mode != 0 {
+			result.Tmpfs.Mode = strconv.FormatUint(uint64(m.TmpfsOptions.Mode), 8)
+		}
+	}
+	return result
+}
+
+func convertUpdateConfig(config *parser.UpdateConfig) (*swarm.UpdateConfig, error) {
+	if config == nil {
+		return nil, nil
+	}
+	result := &swarm.UpdateConfig{
+		Parallelism:     uint64(config.Parallelism),
+		FailureAction:   config.FailureAction,
+		MaxFailureRatio: config.MaxFailureRatio,
+		Order:           config.Order,
+	}
+	delay, err := parseDuration(config.Delay)
+	if err != nil {
+		return nil, err
+	}
+	if delay != nil {
+		result.Delay = *delay
+	}
+	monitor, err := parseDuration(config.Monitor)
+	if err != nil {
+		return nil, err
+	}
+	if monitor != nil {
+		result.Monitor = *monitor
+	}
+	return result, nil
+}
+
+func convertRestartPolicy(policy *parser.RestartPolicy) (*swarm.RestartPolicy, error) {
+	if policy == nil {
+		return nil, nil
+	}
+	result := &swarm.RestartPolicy{
+		Condition: swarm.RestartPolicyCondition(policy.Condition),
+	}
+	var err error
+	if result.Delay, err = parseDuration(policy.Delay); err != nil {
+		return nil, err
+	}
+	if result.Window, err = parseDuration(policy.Window); err != nil {
+		return nil, err
+	}
+	if policy.MaxAttempts > 0 {
+		maxAttempts := uint64(policy.MaxAttempts)
+		result.MaxAttempts = &maxAttempts
+	}
+	return result, nil
+}
+
+func convertResources(resources *parser.Resources) (*swarm.ResourceRequirements, error) {
+	if resources == nil {
+		return nil, nil
+	}
+	result := &swarm.ResourceRequirements{}
+	if limits := resources.Limits; limits != nil {
+		cpus, err := parseCPUs(limits.CPUs)
+		if err != nil {
+			return nil, err
+		}
+		memory, err := parseMemory(limits.Memory)
+		if err != nil {
+			return nil, err
+		}
+		result.Limits = &swarm.Limit{NanoCPUs: cpus, MemoryBytes: memory}
+	}
+	if reservations := resources.Reservations; reservations != nil {
+		cpus, err := parseCPUs(reservations.CPUs)
+		if err != nil {
+			return nil, err
+		}
+		memory, err := parseMemory(reservations.Memory)
+		if err != nil {
+			return nil, err
+		}
+		result.Reservations = &swarm.Resources{NanoCPUs: cpus, MemoryBytes: memory}
+	}
+	return result, nil
+}
+
+func convertHealthcheck(hc *parser.Healthcheck) (*container.HealthConfig, error) {
+	if hc == nil {
+		return nil, nil
+	}
+	result := &container.HealthConfig{
+		Test:    hc.Test,
+		Retries: hc.Retries,
+	}
+	for _, field := range []struct {
+		value  string
+		target *time.Duration
+	}{
+		{hc.Interval, &result.Interval},
+		{hc.Timeout, &result.Timeout},
+		{hc.StartPeriod, &result.StartPeriod},
+	} {
+		duration, err := parseDuration(field.value)
+		if err != nil {
+			return nil, err
+		}
+		if duration != nil {
+			*field.target = *duration
+		}
+	}
+	return result, nil
+}
+
+// convertExtraHosts turns compose "host:ip" or "host=ip" entries into the "ip host" form of /etc/hosts.
+func convertExtraHosts(hosts []string) []string {
+	var result []string
+	for _, host := range hosts {
+		name, ip, ok := strings.Cut(host, "=")
+		if !ok {
+			name, ip, _ = strings.Cut(host, ":")
+		}
+		result = append(result, ip+" "+name)
+	}
+	return result
+}
+
+func formatExtraHosts(hosts []string) []string {
+	var result []string
+	for _, host := range hosts {
+		fields := strings.Fields(host)
+		for _, name := range fields[min(1, len(fields)):] {
+			result = append(result, name+":"+fields[0])
+		}
+	}
+	return result
+}
+
+func convertUlimits(ulimits map[string]parser.Ulimit) []*container.Ulimit {
+	names := make([]string, 0, len(ulimits))
+	for name := range ulimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var result []*container.Ulimit
+	for _, name := range names {
+		limit := ulimits[name]
+		if limit.Single != 0 {
+			result = append(result, &container.Ulimit{Name: name, Soft: int64(limit.Single), Hard: int64(limit.Single)})
+			continue
+		}
+		result = append(result, &container.Ulimit{Name: name, Soft: int64(limit.Soft), Hard: int64(limit.Hard)})
+	}
+	return result
+}
+
+func formatUlimits(ulimits []*container.Ulimit) map[string]parser.Ulimit {
+	if len(ulimits) == 0 {
+		return nil
+	}
+	result := map[string]parser.Ulimit{}
+	for _, limit := range ulimits {
+		if limit.Soft == limit.Hard {
+			result[limit.Name] = parser.Ulimit{Single: int(limit.Soft)}
+			continue
+		}
+		result[limit.Name] = parser.Ulimit{Soft: int(limit.Soft), Hard: int(limit.Hard)}
+	}
+	return result
+}
+
+func convertDNS(srv parser.Service) *swarm.DNSConfig {
+	if len(srv.DNS) == 0 && len(srv.DNSSearch) == 0 && len(srv.DNSOpt) == 0 {
+		return nil
+	}
+	return &swarm.DNSConfig{
+		Nameservers: srv.DNS,
+		Search:      srv.DNSSearch,
+		Options:     srv.DNSOpt,
+	}
+}
+
+// userLabels drops the labels added on deployment so only the ones from the compose file are left.
+func userLabels(labels map[string]string) parser.StringMap {
+	result := parser.StringMap{}
+	for key, value := range labels {
+		if key == "com.docker.stack.namespace" || key == "com.docker.stack.image" {
+			continue
+		}
+		result[key] = value
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+func convertIPAM(ipam *parser.IPAM) *network.IPAM {
+	if ipam == nil {
+		return nil
+	}
+	result := &network.IPAM{
+		Driver:  ipam.Driver,
+		Options: ipam.Options,
+	}
+	for _, pool := range ipam.Config {
+		result.Config = append(result.Config, network.IPAMConfig{
+			Subnet:     pool.Subnet,
+			IPRange:    pool.IPRange,
+			Gateway:    pool.Gateway,
+			AuxAddress: pool.AuxAddresses,
+		})
+	}
+	return result
+}
+
+// formatIPAM leaves out the IPAM of networks that use the default driver without any settings.
+func formatIPAM(ipam network.IPAM) *parser.IPAM {
+	driver := ipam.Driver
+	if driver == "default" {
+		driver = ""
+	}
+	if driver == "" && len(ipam.Config) == 0 && len(ipam.Options) == 0 {
+		return nil
+	}
+
+	result := &parser.IPAM{
+		Driver:  driver,
+		Options: ipam.Options,
+	}
+	for _, pool := range ipam.Config {
+		result.Config = append(result.Config, parser.IPAMPool{
+			Subnet:       pool.Subnet,
+			IPRange:      pool.IPRange,
+			Gateway:      pool.Gateway,
+			AuxAddresses: pool.AuxAddress,
+		})
+	}
+	return result
+}
+
+func resourceName(key, name, stackName string, external bool) string {
+	if name != "" {
+		return name
+	}
+	if external {
+		return key
+	}
+	return AddStackToName(key, stackName)
+}
+
+// ConvertService builds the swarm service spec `docker stack deploy` would create for a compose service.
+// Secret and config references are resolved by name only, their IDs have to be filled in by the caller.
+func ConvertService(stackName, name string, srv parser.Service, config parser.ComposeConfig) (swarm.ServiceSpec, error) {
+	labels := stackLabels(stackName, srv.Deploy.Labels)
+	labels["com.docker.stack.image"] = srv.Image
+
+	spec := swarm.ServiceSpec{
+		Annotations: swarm.Annotations{
+			Name:   AddStackToName(name, stackName),
+			Labels: labels,
+		},
+		TaskTemplate: swarm.TaskSpec{
+			ContainerSpec: &swarm.ContainerSpec{
+				Image:          srv.Image,
+				Labels:         stackLabels(stackName, srv.Labels),
+				Command:        srv.Entrypoint,
+				Args:           srv.Command,
+				Hostname:       srv.Hostname,
+				Env:            srv.Environment,
+				Dir:            srv.WorkingDir,
+				User:           srv.User,
+				Init:           srv.Init,
+				StopSignal:     srv.StopSignal,
+				ReadOnly:       srv.ReadOnly,
+				Hosts:          convertExtraHosts(srv.ExtraHosts),
+				DNSConfig:      convertDNS(srv),
+				Isolation:      container.Isolation(srv.Isolation),
+				Sysctls:        srv.Sysctls,
+				CapabilityAdd:  srv.CapAdd,
+				CapabilityDrop: srv.CapDrop,
+				Ulimits:        convertUlimits(srv.Ulimits),
+			},
+		},
+		EndpointSpec: &swarm.EndpointSpec{
+			Mode: swarm.ResolutionMode(srv.Deploy.EndpointMode),
+		},
+	}
+
+	if srv.Logging != nil {
+		spec.TaskTemplate.LogDriver = &swarm.Driver{
+			Name:    srv.Logging.Driver,
+			Options: srv.Logging.Options,
+		}
+	}
+
+	replicas := uint64(1)
+	if srv.Deploy.Replicas > 0 {
+		replicas = uint64(srv.Deploy.Replicas)
+	}
+	switch srv.Deploy.Mode {
+	case "", "replicated":
+		spec.Mode.Replicated = &swarm.ReplicatedService{Replicas: &replicas}
+	case "global":
+		spec.Mode.Global = &swarm.GlobalService{}
+	case "replicated-job":
+		spec.Mode.ReplicatedJob = &swarm.ReplicatedJob{MaxConcurrent: &replicas, TotalCompletions: &replicas}
+	case "global-job":
+		spec.Mode.GlobalJob = &swarm.GlobalJob{}
+	default:
+		return swarm.ServiceSpec{}, fmt.Errorf("service %q: unknown deploy mode %q", name, srv.Deploy.Mode)
+	}
+
+	for _, port := range srv.Ports {
+		ports, err := ConvertPort(port)
+		if err != nil {
+			return swarm.ServiceSpec{}, fmt.Errorf("service %q: %w", name, err)
+		}
+		spec.EndpointSpec.Ports = append(spec.EndpointSpec.Ports, ports...)
+	}
+
+	networks := srv.Networks
+	if len(networks) == 0 {
+		networks = parser.ServiceNetworks{{Name: "default"}}
+	}
+	for _, nw := range networks {
+		net, ok := config.Networks[nw.Name]
+		if !ok && nw.Name != "default" {
+			return swarm.ServiceSpec{}, fmt.Errorf("service %q: network %q is not defined", name, nw.Name)
+		}
+		spec.TaskTemplate.Networks = append(spec.TaskTemplate.Networks, swarm.NetworkAttachmentConfig{
+			Target:  resourceName(nw.Name, net.Name, stackName, net.External),
+			Aliases: append([]string{name}, nw.Aliases...),
+		})
+	}
+
+	for _, vol := range srv.Volumes {
+		m, err := ConvertVolume(vol, stackName, config.Volumes)
+		if err != nil {
+			return swarm.ServiceSpec{}, fmt.Errorf("service %q: %w", name, err)
+		}
+		spec.TaskTemplate.ContainerSpec.Mounts = append(spec.TaskTemplate.ContainerSpec.Mounts, m)
+	}
+
+	for _, tmpfs := range srv.Tmpfs {
+		m, err := ConvertTmpfs(tmpfs)
+		if err != nil {
+			return swarm.ServiceSpec{}, fmt.Errorf("service %q: %w", name, err)
+		}
+		spec.TaskTemplate.ContainerSpec.Mounts = append(spec.TaskTemplate.ContainerSpec.Mounts, m)
+	}
+
+	for _, ref := range srv.Secrets {
+		secret, ok := config.Secrets[ref.Source]
+		if !ok {
+			return swarm.ServiceSpec{}, fmt.Errorf("service %q: secret %q is not defined", name, ref.Source)
+		}
+		target := ref.Target
+		if target == "" {
+			target = ref.Source
+		}
+		spec.TaskTemplate.ContainerSpec.Secrets = append(spec.TaskTemplate.ContainerSpec.Secrets, &swarm.SecretReference{
+			SecretName: resourceName(ref.Source, secret.Name, stackName, secret.External),
+			File: &swarm.SecretReferenceFileTarget{
+				Name: target,
+				UID:  "0",
+				GID:  "0",
+				Mode: 0444,
+			},
+		})
+	}
+
+	for _, ref := range srv.Configs {
+		cfg, ok := config.Configs[ref.Source]
+		if !ok {
+			return swarm.ServiceSpec{}, fmt.Errorf("service %q: config %q is not defined", name, ref.Source)
+		}
+		target := ref.Target
+		if target == "" {
+			target = "/" + ref.Source
+		}
+		spec.TaskTemplate.ContainerSpec.Configs = append(spec.TaskTemplate.ContainerSpec.Configs, &swarm.ConfigReference{
+			ConfigName: resourceName(ref.Source, cfg.Name, stackName, cfg.External),
+			File: &swarm.ConfigReferenceFileTarget{
+				Name: target,
+				UID:  "0",
+				GID:  "0",
+				Mode: 0444,
+			},
+		})
+	}
+
+	var err error
+	if spec.TaskTemplate.ContainerSpec.StopGracePeriod, err = parseDuration(srv.StopGracePeriod); err != nil {
+		return swarm.ServiceSpec{}, fmt.Errorf("service %q: stop_grace_period: %w", name, err)
+	}
+	if spec.TaskTemplate.ContainerSpec.Healthcheck, err = convertHealthcheck(srv.Healthcheck); err != nil {
+		return swarm.ServiceSpec{}, fmt.Errorf("service %q: healthcheck: %w", name, err)
+	}
+	if spec.UpdateConfig, err = convertUpdateConfig(srv.Deploy.UpdateConfig); err != nil {
+		return swarm.ServiceSpec{}, fmt.Errorf("service %q: update_config: %w", name, err)
+	}
+	if spec.RollbackConfig, err = convertUpdateConfig(srv.Deploy.RollbackConfig); err != nil {
+		return swarm.ServiceSpec{}, fmt.Errorf("service %q: rollback_config: %w", name, err)
+	}
+	if spec.TaskTemplate.RestartPolicy, err = convertRestartPolicy(srv.Deploy.RestartPolicy); err != nil {
+		return swarm.ServiceSpec{}, fmt.Errorf("service %q: restart_policy: %w", name, err)
+	}
+	if spec.TaskTemplate.Resources, err = convertResources(srv.Deploy.Resources); err != nil {
+		return swarm.ServiceSpec{}, fmt.Errorf("service %q: resources: %w", name, err)
+	}
+
+	if placement := srv.Deploy.Placement; placement != nil {
+		spec.TaskTemplate.Placement = &swarm.Placement{
+			Constraints: placement.Constraints,
+			MaxReplicas: placement.MaxReplicasPerNode,
+		}
+		for _, pref := range placement.Preferences {
+			spec.TaskTemplate.Placement.Preferences = append(spec.TaskTemplate.Placement.Preferences, swarm.PlacementPreference{
+				Spread: &swarm.SpreadOver{SpreadDescriptor: pref.Spread},
+			})
+		}
+	}
+
+	return spec, nil
+}
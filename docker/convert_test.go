@@ -0,0 +1,476 @@
+package docker_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/dockrelix/dockrelix-backend/docker"
+	"github.com/dockrelix/dockrelix-backend/models/parser"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParsePort(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		count     int
+		published uint32
+		target    uint32
+		protocol  string
+		wantErr   bool
+	}{
+		{"Target only", "80", 1, 0, 80, "tcp", false},
+		{"Published and target", "8080:80", 1, 8080, 80, "tcp", false},
+		{"UDP", "53:53/udp", 1, 53, 53, "udp", false},
+		{"Range", "9000-9002:9000-9002", 3, 9000, 9000, "tcp", false},
+		{"Host IP", "127.0.0.1:8080:80", 0, 0, 0, "", true},
+		{"Invalid protocol", "80/http", 0, 0, 0, "", true},
+		{"Mismatched range", "9000-9001:9000", 0, 0, 0, "", true},
+		{"Not a number", "web", 0, 0, 0, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ports, err := docker.ParsePort(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error for %s", tt.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if len(ports) != tt.count {
+				t.Fatalf("expected %d ports, got %d", tt.count, len(ports))
+			}
+			if ports[0].PublishedPort != tt.published || ports[0].TargetPort != tt.target || string(ports[0].Protocol) != tt.protocol {
+				t.Errorf("unexpected port %+v", ports[0])
+			}
+		})
+	}
+}
+
+func TestConvertService(t *testing.T) {
+	config := parser.ComposeConfig{
+		Services: map[string]parser.Service{
+			"web": {
+				Image:    "nginx:latest",
+				Ports:    []parser.ServicePort{{Short: "8080:80"}},
+				Networks: parser.ServiceNetworks{{Name: "frontend"}},
+				Volumes:  []parser.ServiceVolume{{Short: "data:/data:ro"}, {Short: "/etc/localtime:/etc/localtime"}},
+				Secrets:  []parser.SecretRef{{Source: "token"}},
+				Deploy: parser.DeployConfig{
+					Replicas: 3,
+					Resources: &parser.Resources{
+						Limits: &parser.ResourceSpec{CPUs: "0.5", Memory: "512M"},
+					},
+				},
+			},
+		},
+		Networks: map[string]parser.Network{"frontend": {}},
+		Volumes:  map[string]parser.Volume{"data": {}},
+		Secrets:  map[string]parser.Secret{"token": {External: true}},
+	}
+
+	spec, err := docker.ConvertService("test_stack", "web", config.Services["web"], config)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if spec.Name != "test_stack_web" {
+		t.Errorf("expected name 'test_stack_web', got %s", spec.Name)
+	}
+
+	if spec.Labels["com.docker.stack.namespace"] != "test_stack" {
+		t.Errorf("expected namespace label to be set")
+	}
+
+	if spec.Mode.Replicated == nil || *spec.Mode.Replicated.Replicas != 3 {
+		t.Errorf("expected 3 replicas")
+	}
+
+	if len(spec.TaskTemplate.Networks) != 1 || spec.TaskTemplate.Networks[0].Target != "test_stack_frontend" {
+		t.Errorf("expected network test_stack_frontend, got %+v", spec.TaskTemplate.Networks)
+	}
+
+	mounts := spec.TaskTemplate.ContainerSpec.Mounts
+	if len(mounts) != 2 {
+		t.Fatalf("expected 2 mounts, got %d", len(mounts))
+	}
+	if mounts[0].Type != mount.TypeVolume || mounts[0].Source != "test_stack_data" || !mounts[0].ReadOnly {
+		t.Errorf("unexpected volume mount %+v", mounts[0])
+	}
+	if mounts[1].Type != mount.TypeBind {
+		t.Errorf("expected bind mount, got %s", mounts[1].Type)
+	}
+
+	if spec.TaskTemplate.ContainerSpec.Secrets[0].SecretName != "token" {
+		t.Errorf("expected external secret to keep its name, got %s", spec.TaskTemplate.ContainerSpec.Secrets[0].SecretName)
+	}
+
+	if spec.TaskTemplate.Resources.Limits.NanoCPUs != 5e8 || spec.TaskTemplate.Resources.Limits.MemoryBytes != 512*1024*1024 {
+		t.Errorf("unexpected limits %+v", spec.TaskTemplate.Resources.Limits)
+	}
+}
+
+func TestConvertServiceUndefinedNetwork(t *testing.T) {
+	config := parser.ComposeConfig{
+		Services: map[string]parser.Service{
+			"web": {Image: "nginx:latest", Networks: parser.ServiceNetworks{{Name: "missing"}}},
+		},
+	}
+
+	if _, err := docker.ConvertService("test_stack", "web", config.Services["web"], config); err == nil {
+		t.Errorf("expected error for undefined network")
+	}
+}
+
+func TestConvertServiceSpecForms(t *testing.T) {
+	data := `services:
+  web:
+    image: nginx
+    environment:
+      MODE: prod
+      DEBUG:
+      WORKERS: 4
+    secrets: [token]
+    configs: [site]
+    networks:
+      frontend:
+        aliases: [www]
+      backend:
+    volumes:
+      - data:/data
+networks:
+  frontend: {}
+  backend:
+    external:
+      name: shared_backend
+volumes:
+  data:
+    external:
+      name: shared_data
+configs:
+  site:
+    file: ./site.conf
+secrets:
+  token:
+    external: true
+`
+
+	config, err := docker.RenderCompose(data, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if backend := config.Networks["backend"]; !backend.External || backend.Name != "shared_backend" {
+		t.Errorf("expected the legacy external form to name the network, got %+v", backend)
+	}
+
+	spec, err := docker.ConvertService("test_stack", "web", config.Services["web"], config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	container := spec.TaskTemplate.ContainerSpec
+	if !reflect.DeepEqual(container.Env, []string{"MODE=prod", "DEBUG", "WORKERS=4"}) {
+		t.Errorf("expected the environment mapping in document order, got %v", container.Env)
+	}
+	if len(container.Secrets) != 1 || container.Secrets[0].SecretName != "token" || container.Secrets[0].File.Name != "token" {
+		t.Errorf("expected the short secret syntax to mount token, got %+v", container.Secrets)
+	}
+	if len(container.Configs) != 1 || container.Configs[0].ConfigName != "test_stack_site" || container.Configs[0].File.Name != "/site" {
+		t.Errorf("expected the short config syntax to mount site, got %+v", container.Configs)
+	}
+	if len(container.Mounts) != 1 || container.Mounts[0].Source != "shared_data" {
+		t.Errorf("expected the external volume name, got %+v", container.Mounts)
+	}
+
+	networks := spec.TaskTemplate.Networks
+	if len(networks) != 2 || networks[0].Target != "test_stack_frontend" || !reflect.DeepEqual(networks[0].Aliases, []string{"web", "www"}) ||
+		networks[1].Target != "shared_backend" || !reflect.DeepEqual(networks[1].Aliases, []string{"web"}) {
+		t.Errorf("expected the networks mapping with its aliases, got %+v", networks)
+	}
+}
+
+func TestConvertServiceTmpfsOptions(t *testing.T) {
+	config := parser.ComposeConfig{
+		Services: map[string]parser.Service{
+			"web": {Image: "nginx:latest", Tmpfs: []string{"/run:size=64m,mode=1777", "/cache:size=1g,noexec,uid=1000"}},
+		},
+	}
+
+	spec, err := docker.ConvertService("test_stack", "web", config.Services["web"], config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mounts := spec.TaskTemplate.ContainerSpec.Mounts
+	if len(mounts) != 2 || mounts[0].Target != "/run" || mounts[1].Target != "/cache" {
+		t.Fatalf("expected two tmpfs mounts, got %+v", mounts)
+	}
+	if tmpfs := mounts[0].TmpfsOptions; tmpfs == nil || tmpfs.SizeBytes != 64*1024*1024 || tmpfs.Mode != 01777 {
+		t.Errorf("expected size and mode, got %+v", tmpfs)
+	}
+	if tmpfs := mounts[1].TmpfsOptions; tmpfs == nil || tmpfs.SizeBytes != 1024*1024*1024 || !reflect.DeepEqual(tmpfs.Options, [][]string{{"noexec"}, {"uid", "1000"}}) {
+		t.Errorf("expected size and extra options, got %+v", tmpfs)
+	}
+
+	spec.Name = "web"
+	out, err := docker.GenerateStackConfig([]swarm.Service{{Spec: spec}}, nil, nil, nil, nil, "test_stack")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var exported parser.ComposeConfig
+	if err := yaml.Unmarshal(out, &exported); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	web := exported.Services["web"]
+	if !reflect.DeepEqual(web.Tmpfs, parser.StringList{"/cache:size=1073741824,noexec,uid=1000"}) {
+		t.Errorf("expected tmpfs with extra options in the short syntax, got %v", web.Tmpfs)
+	}
+	if len(web.Volumes) != 1 || web.Volumes[0].Tmpfs == nil || web.Volumes[0].Tmpfs.Mode != "1777" {
+		t.Errorf("expected sized tmpfs in the long syntax, got %+v", web.Volumes)
+	}
+
+	config.Services["web"] = parser.Service{Image: "nginx:latest", Tmpfs: []string{"/run:size=lots"}}
+	if _, err := docker.ConvertService("test_stack", "web", config.Services["web"], config); err == nil {
+		t.Errorf("expected error for invalid tmpfs size")
+	}
+}
+
+func TestConvertServiceContainerFieldsRoundTrip(t *testing.T) {
+	data := `services:
+  web:
+    image: nginx:latest
+    entrypoint: /docker-entrypoint.sh
+    command: nginx -g 'daemon off;'
+    user: "101"
+    working_dir: /srv
+    hostname: web
+    labels:
+      - tier=frontend
+    stop_signal: SIGQUIT
+    stop_grace_period: 30s
+    init: true
+    read_only: true
+    cap_add: [NET_ADMIN]
+    cap_drop: [ALL]
+    sysctls:
+      net.core.somaxconn: "1024"
+    ulimits:
+      nproc: 65535
+      nofile:
+        soft: 20000
+        hard: 40000
+    dns: 1.1.1.1
+    extra_hosts:
+      - db:10.0.0.5
+    tmpfs: /run
+    deploy:
+      labels:
+        team: web
+`
+
+	config, err := docker.RenderCompose(data, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	spec, err := docker.ConvertService("test_stack", "web", config.Services["web"], config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	container := spec.TaskTemplate.ContainerSpec
+	if !reflect.DeepEqual(container.Args, []string{"nginx", "-g", "daemon off;"}) {
+		t.Errorf("expected command to be split into args, got %v", container.Args)
+	}
+	if !reflect.DeepEqual(container.Hosts, []string{"10.0.0.5 db"}) {
+		t.Errorf("expected hosts entry, got %v", container.Hosts)
+	}
+	if spec.Labels["team"] != "web" || container.Labels["tier"] != "frontend" {
+		t.Errorf("expected service and container labels, got %v and %v", spec.Labels, container.Labels)
+	}
+
+	spec.Name = "web"
+	out, err := docker.GenerateStackConfig([]swarm.Service{{Spec: spec}}, nil, nil, nil, nil, "test_stack")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var exported parser.ComposeConfig
+	if err := yaml.Unmarshal(out, &exported); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected, actual := config.Services["web"], exported.Services["web"]
+	expected.Networks = nil
+	actual.Networks = nil
+	expected.Deploy.Mode, expected.Deploy.Replicas = "replicated", 1
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected export to match the compose file\nexpected: %+v\nactual:   %+v", expected, actual)
+	}
+}
+
+func TestConvertLongSyntaxRoundTrip(t *testing.T) {
+	data := `services:
+  web:
+    image: nginx:latest
+    ports:
+      - "8080:80"
+      - target: 443
+        published: 8443
+        mode: host
+    volumes:
+      - data:/data:ro
+      - type: volume
+        source: cache
+        target: /cache
+        volume:
+          nocopy: true
+      - type: bind
+        source: /var/run
+        target: /host/run
+        bind:
+          propagation: rshared
+      - type: tmpfs
+        target: /scratch
+        tmpfs:
+          size: 64m
+          mode: "1777"
+volumes:
+  data:
+    driver: local
+    driver_opts:
+      type: nfs
+  cache: {}
+`
+
+	config, err := docker.RenderCompose(data, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	spec, err := docker.ConvertService("test_stack", "web", config.Services["web"], config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ports := spec.EndpointSpec.Ports
+	if len(ports) != 2 || ports[1].PublishMode != swarm.PortConfigPublishModeHost || ports[1].PublishedPort != 8443 {
+		t.Errorf("expected a host mode port, got %+v", ports)
+	}
+
+	mounts := spec.TaskTemplate.ContainerSpec.Mounts
+	if len(mounts) != 4 {
+		t.Fatalf("expected 4 mounts, got %d", len(mounts))
+	}
+	if driver := mounts[0].VolumeOptions.DriverConfig; driver == nil || driver.Options["type"] != "nfs" {
+		t.Errorf("expected driver options on the data volume, got %+v", driver)
+	}
+	if !mounts[1].VolumeOptions.NoCopy || mounts[1].Source != "test_stack_cache" {
+		t.Errorf("expected a nocopy stack volume, got %+v", mounts[1])
+	}
+	if mounts[2].BindOptions == nil || mounts[2].BindOptions.Propagation != mount.PropagationRShared {
+		t.Errorf("expected bind propagation, got %+v", mounts[2].BindOptions)
+	}
+	if tmpfs := mounts[3].TmpfsOptions; tmpfs == nil || tmpfs.SizeBytes != 64*1024*1024 || tmpfs.Mode != 01777 {
+		t.Errorf("expected tmpfs options, got %+v", tmpfs)
+	}
+
+	spec.Name = "web"
+	service := swarm.Service{Spec: spec, Endpoint: swarm.Endpoint{Ports: ports}}
+	out, err := docker.GenerateStackConfig([]swarm.Service{service}, nil, nil, nil, nil, "test_stack")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var exported parser.ComposeConfig
+	if err := yaml.Unmarshal(out, &exported); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	web := exported.Services["web"]
+	expectedPorts := []parser.ServicePort{
+		{Short: "8080:80/tcp"},
+		{Target: 443, Published: "8443", Protocol: "tcp", Mode: "host"},
+	}
+	if !reflect.DeepEqual(web.Ports, expectedPorts) {
+		t.Errorf("expected ports %+v, got %+v", expectedPorts, web.Ports)
+	}
+
+	expectedVolumes := []parser.ServiceVolume{
+		{Short: "data:/data:ro"},
+		{Type: "volume", Source: "cache", Target: "/cache", Volume: &parser.ServiceVolumeVolume{NoCopy: true}},
+		{Type: "bind", Source: "/var/run", Target: "/host/run", Bind: &parser.ServiceVolumeBind{Propagation: "rshared"}},
+		{Type: "tmpfs", Target: "/scratch", Tmpfs: &parser.ServiceVolumeTmpfs{Size: "67108864", Mode: "1777"}},
+	}
+	if !reflect.DeepEqual(web.Volumes, expectedVolumes) {
+		t.Errorf("expected volumes %+v, got %+v", expectedVolumes, web.Volumes)
+	}
+}
+
+func TestConvertDeployOptionsRoundTrip(t *testing.T) {
+	data := `services:
+  migrate:
+    image: app:latest
+    logging:
+      driver: json-file
+      options:
+        max-size: 10m
+    deploy:
+      mode: replicated-job
+      replicas: 2
+      endpoint_mode: dnsrr
+      placement:
+        max_replicas_per_node: 1
+      update_config:
+        parallelism: 1
+        delay: 10s
+        failure_action: rollback
+        monitor: 30s
+        max_failure_ratio: 0.2
+        order: start-first
+      rollback_config:
+        parallelism: 2
+        delay: 5s
+        failure_action: pause
+        monitor: 15s
+        max_failure_ratio: 0.5
+        order: stop-first
+`
+
+	config, err := docker.RenderCompose(data, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	spec, err := docker.ConvertService("test_stack", "migrate", config.Services["migrate"], config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if job := spec.Mode.ReplicatedJob; job == nil || *job.TotalCompletions != 2 {
+		t.Errorf("expected a replicated job with 2 completions, got %+v", spec.Mode)
+	}
+
+	spec.Name = "migrate"
+	out, err := docker.GenerateStackConfig([]swarm.Service{{Spec: spec}}, nil, nil, nil, nil, "test_stack")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var exported parser.ComposeConfig
+	if err := yaml.Unmarshal(out, &exported); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected, actual := config.Services["migrate"], exported.Services["migrate"]
+	actual.Networks = nil
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected export to match the compose file\nexpected: %+v\nactual:   %+v", expected, actual)
+	}
+}
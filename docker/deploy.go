@@ -0,0 +1,210 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/dockrelix/dockrelix-backend/models/parser"
+)
+
+// DeployClient adds the calls needed to create and update stack resources
+type DeployClient interface {
+	DockerClient
+	NetworkCreate(ctx context.Context, name string, options network.CreateOptions) (network.CreateResponse, error)
+	ServiceCreate(ctx context.Context, service swarm.ServiceSpec, options types.ServiceCreateOptions) (swarm.ServiceCreateResponse, error)
+	ServiceUpdate(ctx context.Context, serviceID string, version swarm.Version, service swarm.ServiceSpec, options types.ServiceUpdateOptions) (swarm.ServiceUpdateResponse, error)
+	SecretCreate(ctx context.Context, secret swarm.SecretSpec) (types.SecretCreateResponse, error)
+	ConfigCreate(ctx context.Context, config swarm.ConfigSpec) (types.ConfigCreateResponse, error)
+}
+
+func deployNetworks(cli DeployClient, stackName string, config parser.ComposeConfig) error {
+	existing, err := cli.NetworkList(context.Background(), network.ListOptions{
+		Filters: stackFilter(stackName),
+	})
+	if err != nil {
+		return err
+	}
+
+	existingNames := map[string]bool{}
+	for _, net := range existing {
+		existingNames[net.Name] = true
+	}
+
+	networks := map[string]parser.Network{}
+	for name, net := range config.Networks {
+		networks[name] = net
+	}
+	for _, srv := range config.Services {
+		if len(srv.Networks) == 0 {
+			if _, ok := networks["default"]; !ok {
+				networks["default"] = parser.Network{}
+			}
+		}
+	}
+
+	for name, net := range networks {
+		if net.External {
+			continue
+		}
+
+		fullName := resourceName(name, net.Name, stackName, false)
+		if existingNames[fullName] {
+			continue
+		}
+
+		driver := net.Driver
+		if driver == "" {
+			driver = "overlay"
+		}
+
+		options := network.CreateOptions{
+			Driver:     driver,
+			Scope:      "swarm",
+			IPAM:       convertIPAM(net.IPAM),
+			Options:    net.DriverOpts,
+			Labels:     stackLabels(stackName, net.Labels),
+			Attachable: net.Attachable,
+			Internal:   net.Internal,
+		}
+		if net.EnableIPv6 {
+			options.EnableIPv6 = &net.EnableIPv6
+		}
+
+		_, err := cli.NetworkCreate(context.Background(), fullName, options)
+		if err != nil {
+			return fmt.Errorf("network %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func templateDriver(name string) *swarm.Driver {
+	if name == "" {
+		return nil
+	}
+	return &swarm.Driver{Name: name}
+}
+
+// createSecretsAndConfigs creates the secrets and configs of the stack that do not exist yet from the files stored
+// with the draft, or the inline content of a config, and returns the IDs of all of them by name.
+func createSecretsAndConfigs(cli DeployClient, stackName string, config parser.ComposeConfig, files map[string][]byte) (map[string]string, map[string]string, error) {
+	secrets, err := cli.SecretList(context.Background(), types.SecretListOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	secretIDs := map[string]string{}
+	for _, secret := range secrets {
+		secretIDs[secret.Spec.Name] = secret.ID
+	}
+
+	configs, err := cli.ConfigList(context.Background(), types.ConfigListOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	configIDs := map[string]string{}
+	for _, cfg := range configs {
+		configIDs[cfg.Spec.Name] = cfg.ID
+	}
+
+	for name, secret := range config.Secrets {
+		fullName := resourceName(name, secret.Name, stackName, secret.External)
+		if _, ok := secretIDs[fullName]; ok {
+			continue
+		}
+		data, ok := files[path.Clean(secret.File)]
+		if secret.External || secret.File == "" || !ok {
+			return nil, nil, fmt.Errorf("secret %q does not exist and its file contents are not available on the server", name)
+		}
+
+		response, err := cli.SecretCreate(context.Background(), swarm.SecretSpec{
+			Annotations: swarm.Annotations{Name: fullName, Labels: stackLabels(stackName, secret.Labels)},
+			Data:        data,
+			Templating:  templateDriver(secret.TemplateDriver),
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("secret %q: %w", name, err)
+		}
+		secretIDs[fullName] = response.ID
+	}
+
+	for name, cfg := range config.Configs {
+		fullName := resourceName(name, cfg.Name, stackName, cfg.External)
+		if _, ok := configIDs[fullName]; ok {
+			continue
+		}
+		data, ok := []byte(cfg.Content), cfg.Content != ""
+		if cfg.File != "" {
+			data, ok = files[path.Clean(cfg.File)]
+		}
+		if cfg.External || !ok {
+			return nil, nil, fmt.Errorf("config %q does not exist and its file contents are not available on the server", name)
+		}
+
+		response, err := cli.ConfigCreate(context.Background(), swarm.ConfigSpec{
+			Annotations: swarm.Annotations{Name: fullName, Labels: stackLabels(stackName, cfg.Labels)},
+			Data:        data,
+			Templating:  templateDriver(cfg.TemplateDriver),
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("config %q: %w", name, err)
+		}
+		configIDs[fullName] = response.ID
+	}
+
+	return secretIDs, configIDs, nil
+}
+
+// DeployStack creates or updates the swarm resources of a compose file, the same way `docker stack deploy` does.
+// Files holds the contents of the files secrets and configs refer to, by the path used in the compose file.
+func DeployStack(cli DeployClient, stackName string, config parser.ComposeConfig, files map[string][]byte) error {
+	if err := deployNetworks(cli, stackName, config); err != nil {
+		return err
+	}
+
+	secretIDs, configIDs, err := createSecretsAndConfigs(cli, stackName, config, files)
+	if err != nil {
+		return err
+	}
+
+	existing, err := cli.ServiceList(context.Background(), types.ServiceListOptions{
+		Filters: stackFilter(stackName),
+	})
+	if err != nil {
+		return err
+	}
+
+	existingServices := map[string]swarm.Service{}
+	for _, srv := range existing {
+		existingServices[srv.Spec.Name] = srv
+	}
+
+	for name, srv := range config.Services {
+		spec, err := ConvertService(stackName, name, srv, config)
+		if err != nil {
+			return err
+		}
+
+		for _, ref := range spec.TaskTemplate.ContainerSpec.Secrets {
+			ref.SecretID = secretIDs[ref.SecretName]
+		}
+		for _, ref := range spec.TaskTemplate.ContainerSpec.Configs {
+			ref.ConfigID = configIDs[ref.ConfigName]
+		}
+
+		if current, ok := existingServices[spec.Name]; ok {
+			_, err = cli.ServiceUpdate(context.Background(), current.ID, current.Version, spec, types.ServiceUpdateOptions{})
+		} else {
+			_, err = cli.ServiceCreate(context.Background(), spec, types.ServiceCreateOptions{})
+		}
+		if err != nil {
+			return fmt.Errorf("service %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
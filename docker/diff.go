@@ -0,0 +1,376 @@
+package docker
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/dockrelix/dockrelix-backend/models"
+	"github.com/dockrelix/dockrelix-backend/models/parser"
+)
+
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+)
+
+func yamlFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+// isInlineField reports fields such as the x- extensions, which collect keys of their parent and are never deployed.
+func isInlineField(field reflect.StructField) bool {
+	_, options, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	return options == "inline"
+}
+
+func isEmptyValue(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return value.IsNil()
+	}
+	return value.IsZero()
+}
+
+func diffValues(path string, from, to reflect.Value, changes *[]models.FieldChange) {
+	if isEmptyValue(from) && isEmptyValue(to) {
+		return
+	}
+
+	if from.Kind() == reflect.Ptr && !from.IsNil() && !to.IsNil() {
+		diffValues(path, from.Elem(), to.Elem(), changes)
+		return
+	}
+
+	if from.Kind() == reflect.Struct {
+		for i := 0; i < from.NumField(); i++ {
+			field := from.Type().Field(i)
+			if !field.IsExported() || isInlineField(field) {
+				continue
+			}
+			name := yamlFieldName(field)
+			if path != "" {
+				name = path + "." + name
+			}
+			diffValues(name, from.Field(i), to.Field(i), changes)
+		}
+		return
+	}
+
+	if reflect.DeepEqual(from.Interface(), to.Interface()) {
+		return
+	}
+
+	change := models.FieldChange{Field: path}
+	if !isEmptyValue(from) {
+		change.From = from.Interface()
+	}
+	if !isEmptyValue(to) {
+		change.To = to.Interface()
+	}
+	*changes = append(*changes, change)
+}
+
+func diffResource[T any](resourceType string, from, to map[string]T) []models.ResourceDiff {
+	names := map[string]bool{}
+	for name := range from {
+		names[name] = true
+	}
+	for name := range to {
+		names[name] = true
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var result []models.ResourceDiff
+	for _, name := range sorted {
+		current, inFrom := from[name]
+		desired, inTo := to[name]
+		switch {
+		case !inFrom:
+			result = append(result, models.ResourceDiff{Type: resourceType, Name: name, Action: DiffAdded})
+		case !inTo:
+			result = append(result, models.ResourceDiff{Type: resourceType, Name: name, Action: DiffRemoved})
+		default:
+			var changes []models.FieldChange
+			diffValues("", reflect.ValueOf(current), reflect.ValueOf(desired), &changes)
+			if len(changes) > 0 {
+				result = append(result, models.ResourceDiff{Type: resourceType, Name: name, Action: DiffChanged, Changes: changes})
+			}
+		}
+	}
+	return result
+}
+
+func normalizeDuration(value string) string {
+	duration, err := parseDuration(value)
+	if err != nil || duration == nil {
+		return value
+	}
+	if *duration == 0 {
+		return ""
+	}
+	return duration.String()
+}
+
+func normalizeResourceSpec(spec *parser.ResourceSpec) {
+	if spec == nil {
+		return
+	}
+	if cpus, err := parseCPUs(spec.CPUs); err == nil {
+		spec.CPUs = formatCPUs(cpus)
+	}
+	if memory, err := parseMemory(spec.Memory); err == nil {
+		spec.Memory = formatMemory(memory)
+	}
+}
+
+func normalizeUpdateConfig(config *parser.UpdateConfig) *parser.UpdateConfig {
+	if config == nil {
+		return nil
+	}
+	normalized := *config
+	normalized.Delay = normalizeDuration(normalized.Delay)
+	normalized.Monitor = normalizeDuration(normalized.Monitor)
+	return &normalized
+}
+
+func normalizeService(srv parser.Service) parser.Service {
+	if srv.Deploy.Mode == "" {
+		srv.Deploy.Mode = "replicated"
+	}
+	if (srv.Deploy.Mode == "replicated" || srv.Deploy.Mode == "replicated-job") && srv.Deploy.Replicas == 0 {
+		srv.Deploy.Replicas = 1
+	}
+	if srv.Deploy.EndpointMode == "vip" {
+		srv.Deploy.EndpointMode = ""
+	}
+
+	if len(srv.Networks) == 0 {
+		srv.Networks = parser.ServiceNetworks{{Name: "default"}}
+	}
+	srv.Networks = append(parser.ServiceNetworks{}, srv.Networks...)
+	sort.Slice(srv.Networks, func(i, j int) bool { return srv.Networks[i].Name < srv.Networks[j].Name })
+	srv.Environment = sortedCopy(srv.Environment)
+	srv.Volumes = normalizeVolumes(srv.Volumes)
+	srv.Tmpfs = sortedCopy(srv.Tmpfs)
+	srv.CapAdd = sortedCopy(srv.CapAdd)
+	srv.CapDrop = sortedCopy(srv.CapDrop)
+	srv.ExtraHosts = sortedCopy(srv.ExtraHosts)
+
+	var ports []parser.ServicePort
+	for _, port := range srv.Ports {
+		configs, err := ConvertPort(port)
+		if err != nil {
+			ports = append(ports, port)
+			continue
+		}
+		for _, cfg := range configs {
+			ports = append(ports, formatPortConfig(cfg))
+		}
+	}
+	sort.SliceStable(ports, func(i, j int) bool {
+		return portKey(ports[i]) < portKey(ports[j])
+	})
+	srv.Ports = ports
+
+	var secrets []parser.SecretRef
+	for _, ref := range srv.Secrets {
+		if ref.Target == "" {
+			ref.Target = ref.Source
+		}
+		secrets = append(secrets, ref)
+	}
+	srv.Secrets = secrets
+
+	var configs []parser.ConfigRef
+	for _, ref := range srv.Configs {
+		if ref.Target == "" {
+			ref.Target = "/" + ref.Source
+		}
+		configs = append(configs, ref)
+	}
+	srv.Configs = configs
+
+	if srv.Healthcheck != nil {
+		hc := *srv.Healthcheck
+		hc.Interval = normalizeDuration(hc.Interval)
+		hc.Timeout = normalizeDuration(hc.Timeout)
+		hc.StartPeriod = normalizeDuration(hc.StartPeriod)
+		srv.Healthcheck = &hc
+	}
+
+	srv.Deploy.UpdateConfig = normalizeUpdateConfig(srv.Deploy.UpdateConfig)
+	srv.Deploy.RollbackConfig = normalizeUpdateConfig(srv.Deploy.RollbackConfig)
+
+	if srv.Deploy.RestartPolicy != nil {
+		policy := *srv.Deploy.RestartPolicy
+		policy.Delay = normalizeDuration(policy.Delay)
+		policy.Window = normalizeDuration(policy.Window)
+		srv.Deploy.RestartPolicy = &policy
+	}
+
+	if srv.Deploy.Resources != nil {
+		resources := *srv.Deploy.Resources
+		if resources.Limits != nil {
+			limits := *resources.Limits
+			normalizeResourceSpec(&limits)
+			resources.Limits = &limits
+		}
+		if resources.Reservations != nil {
+			reservations := *resources.Reservations
+			normalizeResourceSpec(&reservations)
+			resources.Reservations = &reservations
+		}
+		srv.Deploy.Resources = &resources
+	}
+
+	return srv
+}
+
+func portKey(port parser.ServicePort) string {
+	if port.Short != "" {
+		return port.Short
+	}
+	return fmt.Sprintf("%s:%d/%s", port.Published, port.Target, port.Protocol)
+}
+
+// normalizeVolumes writes long syntax volumes the short syntax can hold in that syntax and sorts them by target.
+func normalizeVolumes(volumes []parser.ServiceVolume) []parser.ServiceVolume {
+	if len(volumes) == 0 {
+		return nil
+	}
+
+	result := make([]parser.ServiceVolume, 0, len(volumes))
+	for _, vol := range volumes {
+		if vol.Short == "" {
+			// Marking the source external keeps its name as written.
+			m, err := ConvertVolume(vol, "", map[string]parser.Volume{vol.Source: {External: true}})
+			if err == nil {
+				vol = formatMount(m, "")
+			}
+		}
+		result = append(result, vol)
+	}
+
+	target := func(vol parser.ServiceVolume) string {
+		if vol.Short == "" {
+			return vol.Target
+		}
+		parts := strings.Split(vol.Short, ":")
+		return parts[min(1, len(parts)-1)]
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return target(result[i]) < target(result[j])
+	})
+	return result
+}
+
+func sortedCopy(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	result := append([]string{}, values...)
+	sort.Strings(result)
+	return result
+}
+
+// normalizeConfig makes a draft and an exported stack comparable: defaults that `docker stack deploy`
+// applies are made explicit, and external resources, which the stack does not own, are left out.
+func normalizeConfig(config parser.ComposeConfig) parser.ComposeConfig {
+	normalized := parser.ComposeConfig{
+		Services: map[string]parser.Service{},
+		Networks: map[string]parser.Network{},
+		Volumes:  map[string]parser.Volume{},
+		Configs:  map[string]parser.Config{},
+		Secrets:  map[string]parser.Secret{},
+	}
+
+	for name, srv := range config.Services {
+		normalized.Services[name] = normalizeService(srv)
+		if len(srv.Networks) == 0 {
+			if _, ok := config.Networks["default"]; !ok {
+				normalized.Networks["default"] = parser.Network{Driver: "overlay"}
+			}
+		}
+	}
+
+	for name, net := range config.Networks {
+		if net.External {
+			continue
+		}
+		if net.Driver == "" {
+			net.Driver = "overlay"
+		}
+		normalized.Networks[name] = net
+	}
+
+	for name, vol := range config.Volumes {
+		if vol.External {
+			continue
+		}
+		if vol.Driver == "" {
+			vol.Driver = "local"
+		}
+		normalized.Volumes[name] = vol
+	}
+
+	for name, secret := range config.Secrets {
+		if !secret.External {
+			normalized.Secrets[name] = parser.Secret{Name: secret.Name}
+		}
+	}
+
+	for name, cfg := range config.Configs {
+		if !cfg.External {
+			normalized.Configs[name] = parser.Config{Name: cfg.Name}
+		}
+	}
+
+	return normalized
+}
+
+// DiffStack compares the compose file about to be deployed with the one exported from the running stack.
+func DiffStack(stackName string, desired, current parser.ComposeConfig) models.StackDiff {
+	desired, current = normalizeConfig(desired), normalizeConfig(current)
+
+	// Swarm assigns a subnet to every network that does not ask for one, that is not a change.
+	for name, net := range desired.Networks {
+		if running, ok := current.Networks[name]; ok && net.IPAM == nil {
+			running.IPAM = nil
+			current.Networks[name] = running
+		}
+	}
+
+	return diffConfigs(stackName, desired, current)
+}
+
+// DiffDrafts compares two compose files that were both written by hand, such as two revisions.
+func DiffDrafts(stackName string, desired, current parser.ComposeConfig) models.StackDiff {
+	return diffConfigs(stackName, normalizeConfig(desired), normalizeConfig(current))
+}
+
+func diffConfigs(stackName string, desired, current parser.ComposeConfig) models.StackDiff {
+	diff := models.StackDiff{
+		Name:      stackName,
+		Resources: []models.ResourceDiff{},
+	}
+	diff.Resources = append(diff.Resources, diffResource("service", current.Services, desired.Services)...)
+	diff.Resources = append(diff.Resources, diffResource("network", current.Networks, desired.Networks)...)
+	diff.Resources = append(diff.Resources, diffResource("volume", current.Volumes, desired.Volumes)...)
+	diff.Resources = append(diff.Resources, diffResource("secret", current.Secrets, desired.Secrets)...)
+	diff.Resources = append(diff.Resources, diffResource("config", current.Configs, desired.Configs)...)
+	return diff
+}
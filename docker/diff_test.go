@@ -0,0 +1,87 @@
+package docker_test
+
+import (
+	"testing"
+
+	"github.com/dockrelix/dockrelix-backend/docker"
+	"github.com/dockrelix/dockrelix-backend/models"
+	"github.com/dockrelix/dockrelix-backend/models/parser"
+)
+
+func findResourceDiff(diff models.StackDiff, resourceType, name string) *models.ResourceDiff {
+	for i, resource := range diff.Resources {
+		if resource.Type == resourceType && resource.Name == name {
+			return &diff.Resources[i]
+		}
+	}
+	return nil
+}
+
+func TestDiffStack(t *testing.T) {
+	current := parser.ComposeConfig{
+		Services: map[string]parser.Service{
+			"web": {
+				Image:    "nginx:1.25",
+				Ports:    []parser.ServicePort{{Short: "8080:80/tcp"}},
+				Networks: parser.ServiceNetworks{{Name: "default"}},
+				Deploy: parser.DeployConfig{
+					Mode:     "replicated",
+					Replicas: 1,
+					Resources: &parser.Resources{
+						Limits: &parser.ResourceSpec{CPUs: "0.5", Memory: "512M"},
+					},
+				},
+			},
+			"worker": {Image: "worker:latest", Deploy: parser.DeployConfig{Mode: "replicated", Replicas: 1}},
+		},
+		Networks: map[string]parser.Network{
+			"default": {Driver: "overlay"},
+		},
+	}
+
+	desired := parser.ComposeConfig{
+		Services: map[string]parser.Service{
+			"web": {
+				Image: "nginx:1.27",
+				Ports: []parser.ServicePort{{Short: "8080:80"}},
+				Deploy: parser.DeployConfig{
+					Replicas: 3,
+					Resources: &parser.Resources{
+						Limits: &parser.ResourceSpec{CPUs: "0.50", Memory: "536870912"},
+					},
+				},
+			},
+			"cache": {Image: "redis:7"},
+		},
+	}
+
+	diff := docker.DiffStack("test_stack", desired, current)
+
+	web := findResourceDiff(diff, "service", "web")
+	if web == nil || web.Action != docker.DiffChanged {
+		t.Fatalf("expected web to be changed, got %+v", web)
+	}
+
+	fields := map[string]bool{}
+	for _, change := range web.Changes {
+		fields[change.Field] = true
+	}
+	if !fields["image"] || !fields["deploy.replicas"] {
+		t.Errorf("expected image and replicas changes, got %+v", web.Changes)
+	}
+	if len(web.Changes) != 2 {
+		t.Errorf("expected only 2 changes, got %+v", web.Changes)
+	}
+
+	if cache := findResourceDiff(diff, "service", "cache"); cache == nil || cache.Action != docker.DiffAdded {
+		t.Errorf("expected cache to be added, got %+v", cache)
+	}
+
+	if worker := findResourceDiff(diff, "service", "worker"); worker == nil || worker.Action != docker.DiffRemoved {
+		t.Errorf("expected worker to be removed, got %+v", worker)
+	}
+
+	if network := findResourceDiff(diff, "network", "default"); network != nil {
+		t.Errorf("expected default network to be unchanged, got %+v", network)
+	}
+}
@@ -0,0 +1,164 @@
+package docker
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/dockrelix/dockrelix-backend/database"
+	"github.com/dockrelix/dockrelix-backend/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrDraftNotFound        = errors.New("draft not found")
+	ErrDraftExists          = errors.New("draft with this name already exists")
+	ErrDraftVersionConflict = errors.New("draft was modified since it was last read")
+
+	// errDraftNotMatched rolls back a guarded write that matched no draft.
+	errDraftNotMatched = errors.New("no draft matched")
+)
+
+func isUniqueViolation(err error) bool {
+	return strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// SaveDraft creates a draft along with its first revision, which takes the author and message of revision unless
+// it is nil.
+func SaveDraft(stackDraft models.StackDraft, revision *models.StackRevision) (models.StackDraft, error) {
+	draft := models.StackDraft{Name: stackDraft.Name, Data: stackDraft.Data, Version: 1}
+	err := saveWithRevision(draftRevision(revision, draft), func(tx *gorm.DB) error {
+		return tx.Create(&draft).Error
+	})
+	if err != nil {
+		if isUniqueViolation(err) {
+			return models.StackDraft{}, ErrDraftExists
+		}
+		return models.StackDraft{}, err
+	}
+
+	return draft, nil
+}
+
+func GetDrafts() []models.StackDraft {
+	var drafts []models.StackDraft
+	database.DB.Find(&drafts)
+	return drafts
+}
+
+func GetDraft(name string) (models.StackDraft, error) {
+	var draft models.StackDraft
+	err := database.DB.Where("name = ?", name).First(&draft).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return draft, ErrDraftNotFound
+	}
+	return draft, err
+}
+
+// draftConflict tells apart a missing draft from one whose version moved on when a guarded write matched nothing.
+func draftConflict(name string) error {
+	if _, err := GetDraft(name); err != nil {
+		return err
+	}
+	return ErrDraftVersionConflict
+}
+
+// UpdateDraft applies the non-empty fields of changes to the draft, as long as it is still at the given version, and
+// records the result as a revision unless revision is nil.
+func UpdateDraft(name string, version uint, changes models.StackDraft, revision *models.StackRevision) (models.StackDraft, error) {
+	updates := map[string]interface{}{
+		"version": gorm.Expr("version + 1"),
+	}
+	if changes.Name != "" {
+		updates["name"] = changes.Name
+	}
+	if changes.Data != "" {
+		updates["data"] = changes.Data
+	}
+
+	// The version checked update, the renames of everything stored under the draft name, its history included, and
+	// the revision of the result either all happen or none.
+	var updated models.StackDraft
+	err := saveWithRevision(revision, func(tx *gorm.DB) error {
+		result := tx.Model(&models.StackDraft{}).
+			Where("name = ? AND version = ?", name, version).
+			Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errDraftNotMatched
+		}
+
+		if changes.Name != "" && changes.Name != name {
+			for _, model := range []interface{}{&models.StackVariable{}, &models.StackDraftFile{}} {
+				err := tx.Model(model).
+					Where("draft_name = ?", name).
+					Update("draft_name", changes.Name).Error
+				if err != nil {
+					return err
+				}
+			}
+			if err := renameRevisions(tx, name, changes.Name); err != nil {
+				return err
+			}
+		}
+
+		newName := name
+		if changes.Name != "" {
+			newName = changes.Name
+		}
+		if err := tx.Where("name = ?", newName).First(&updated).Error; err != nil {
+			return err
+		}
+		draftRevision(revision, updated)
+		return nil
+	})
+	if errors.Is(err, errDraftNotMatched) {
+		return models.StackDraft{}, draftConflict(name)
+	}
+	if err != nil {
+		if isUniqueViolation(err) {
+			return models.StackDraft{}, ErrDraftExists
+		}
+		return models.StackDraft{}, err
+	}
+	return updated, nil
+}
+
+// DeleteDraft removes the draft for good so its name can be reused, provided it is still at version.
+func DeleteDraft(name string, version uint) error {
+	matched := true
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Where("name = ? AND version = ?", name, version).Delete(&models.StackDraft{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			matched = false
+			return result.Error
+		}
+
+		if err := tx.Unscoped().Where("draft_name = ?", name).Delete(&models.StackVariable{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("draft_name = ?", name).Delete(&models.StackDraftFile{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	if !matched {
+		return draftConflict(name)
+	}
+	return nil
+}
+
+// GetDraftFiles returns the files stored with a draft by the path the compose file refers to them with.
+func GetDraftFiles(name string) (map[string][]byte, error) {
+	var files []models.StackDraftFile
+	if err := database.DB.Where("draft_name = ?", name).Find(&files).Error; err != nil {
+		return nil, err
+	}
+
+	result := map[string][]byte{}
+	for _, file := range files {
+		result[file.Path] = file.Content
+	}
+	return result, nil
+}
@@ -0,0 +1,322 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/dockrelix/dockrelix-backend/models"
+)
+
+// eventHistory is the number of events kept to resume from, eventBuffer the number of events a subscriber may fall
+// behind before it is dropped.
+const (
+	eventHistory = 1000
+	eventBuffer  = 256
+)
+
+// EventTypes lists the types of events the feed carries. Swarm has no task events of its own, task events are made
+// from the events of task containers and so only cover the node the daemon runs on.
+var EventTypes = []string{"service", "task", "node", "network", "secret", "config"}
+
+// taskActions are the container events that tell how a task is doing, the rest such as exec or attach are left out.
+var taskActions = []string{"create", "start", "die", "oom", "kill", "stop", "destroy"}
+
+// taskAttributes are the container attributes kept on task events, the other labels of the container are left out.
+var taskAttributes = []string{"image", "exitCode", "signal", "com.docker.swarm.task.id", "com.docker.swarm.task.name", "com.docker.swarm.service.id", "com.docker.swarm.service.name", "com.docker.swarm.node.id"}
+
+// EventsClient adds the call needed to follow the events of the daemon
+type EventsClient interface {
+	DockerClient
+	Events(ctx context.Context, options events.ListOptions) (<-chan events.Message, <-chan error)
+}
+
+// EventFilter selects events by type, stack and action, an empty list lets every value through.
+type EventFilter struct {
+	Types   []string
+	Stacks  []string
+	Actions []string
+}
+
+func (f EventFilter) Match(event models.Event) bool {
+	return (len(f.Types) == 0 || slices.Contains(f.Types, event.Type)) &&
+		(len(f.Stacks) == 0 || slices.Contains(f.Stacks, event.Stack)) &&
+		(len(f.Actions) == 0 || slices.Contains(f.Actions, event.Action))
+}
+
+type eventSubscriber struct {
+	filter EventFilter
+	events chan models.Event
+}
+
+// EventBroker follows the events of the daemon over a single connection and fans them out to its subscribers.
+// Each event gets a cursor made of the start of the broker and a sequence number, so a cursor from before a restart
+// is told apart from one the broker can resume from.
+type EventBroker struct {
+	cli   EventsClient
+	epoch string
+
+	mu          sync.Mutex
+	seq         uint64
+	history     []models.Event
+	subscribers map[*eventSubscriber]struct{}
+	// stacks maps the IDs of services, networks, secrets and configs to their stack, as their events carry no labels
+	stacks map[string]string
+}
+
+func NewEventBroker(cli EventsClient) *EventBroker {
+	return &EventBroker{
+		cli:         cli,
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		subscribers: map[*eventSubscriber]struct{}{},
+		stacks:      map[string]string{},
+	}
+}
+
+// EventSubscription holds the events a subscriber missed since its cursor and the channel of new ones. Reset is set
+// when the events since the cursor are no longer known, the subscriber has to reload its state in that case.
+// Events is closed when the subscriber falls too far behind, it can resume from the last cursor it got.
+type EventSubscription struct {
+	Replay []models.Event
+	Events <-chan models.Event
+	Reset  bool
+
+	broker     *EventBroker
+	subscriber *eventSubscriber
+}
+
+func (s *EventSubscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.unsubscribe(s.subscriber)
+}
+
+func (b *EventBroker) unsubscribe(subscriber *eventSubscriber) {
+	if _, ok := b.subscribers[subscriber]; ok {
+		delete(b.subscribers, subscriber)
+		close(subscriber.events)
+	}
+}
+
+// Subscribe subscribes to the events matching filter, starting right after cursor when it is set.
+func (b *EventBroker) Subscribe(cursor string, filter EventFilter) *EventSubscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subscriber := &eventSubscriber{filter: filter, events: make(chan models.Event, eventBuffer)}
+	b.subscribers[subscriber] = struct{}{}
+	subscription := &EventSubscription{Events: subscriber.events, broker: b, subscriber: subscriber}
+
+	if cursor == "" {
+		return subscription
+	}
+
+	epoch, value, _ := strings.Cut(cursor, "-")
+	seq, err := strconv.ParseUint(value, 10, 64)
+	oldest := b.seq - uint64(len(b.history))
+	if epoch != b.epoch || err != nil || seq < oldest || seq > b.seq {
+		subscription.Reset = true
+		return subscription
+	}
+
+	for _, event := range b.history[len(b.history)-int(b.seq-seq):] {
+		if filter.Match(event) {
+			subscription.Replay = append(subscription.Replay, event)
+		}
+	}
+	return subscription
+}
+
+func (b *EventBroker) publish(event models.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	event.Cursor = fmt.Sprintf("%s-%d", b.epoch, b.seq)
+	b.history = append(b.history, event)
+	if len(b.history) > eventHistory {
+		b.history = slices.Clone(b.history[len(b.history)-eventHistory:])
+	}
+
+	for subscriber := range b.subscribers {
+		if !subscriber.filter.Match(event) {
+			continue
+		}
+		select {
+		case subscriber.events <- event:
+		default:
+			b.unsubscribe(subscriber)
+		}
+	}
+}
+
+// loadStacks maps the resources that already exist to their stack.
+func (b *EventBroker) loadStacks(ctx context.Context, args filters.Args) error {
+	stacks := map[string]string{}
+
+	services, err := b.cli.ServiceList(ctx, types.ServiceListOptions{Filters: args})
+	if err != nil {
+		return err
+	}
+	for _, srv := range services {
+		stacks[srv.ID] = srv.Spec.Labels["com.docker.stack.namespace"]
+	}
+
+	networks, err := b.cli.NetworkList(ctx, network.ListOptions{Filters: args})
+	if err != nil {
+		return err
+	}
+	for _, net := range networks {
+		stacks[net.ID] = net.Labels["com.docker.stack.namespace"]
+	}
+
+	secrets, err := b.cli.SecretList(ctx, types.SecretListOptions{Filters: args})
+	if err != nil {
+		return err
+	}
+	for _, secret := range secrets {
+		stacks[secret.ID] = secret.Spec.Labels["com.docker.stack.namespace"]
+	}
+
+	configs, err := b.cli.ConfigList(ctx, types.ConfigListOptions{Filters: args})
+	if err != nil {
+		return err
+	}
+	for _, config := range configs {
+		stacks[config.ID] = config.Spec.Labels["com.docker.stack.namespace"]
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for id, stack := range stacks {
+		b.stacks[id] = stack
+	}
+	return nil
+}
+
+// stackOf returns the stack of the resource of an event, looking resources up the first time they are seen.
+func (b *EventBroker) stackOf(ctx context.Context, message events.Message) string {
+	if message.Type == events.NodeEventType {
+		return ""
+	}
+
+	b.mu.Lock()
+	stack, ok := b.stacks[message.Actor.ID]
+	b.mu.Unlock()
+	if !ok && message.Action != events.ActionRemove {
+		if err := b.loadStacks(ctx, filters.NewArgs(filters.Arg("id", message.Actor.ID))); err != nil {
+			log.Printf("Error looking up %s %s: %v", message.Type, message.Actor.ID, err)
+		}
+		b.mu.Lock()
+		stack = b.stacks[message.Actor.ID]
+		b.mu.Unlock()
+	}
+
+	if message.Action == events.ActionRemove {
+		b.mu.Lock()
+		delete(b.stacks, message.Actor.ID)
+		b.mu.Unlock()
+	}
+	return stack
+}
+
+// event turns a message of the daemon into an event of the feed, it reports false for messages the feed leaves out.
+func (b *EventBroker) event(ctx context.Context, message events.Message) (models.Event, bool) {
+	attributes := message.Actor.Attributes
+	event := models.Event{
+		Type:   string(message.Type),
+		Action: string(message.Action),
+		ID:     message.Actor.ID,
+		Name:   attributes["name"],
+		Time:   time.Unix(0, message.TimeNano),
+	}
+
+	switch message.Type {
+	case events.ContainerEventType:
+		taskID := attributes["com.docker.swarm.task.id"]
+		if taskID == "" || !slices.Contains(taskActions, event.Action) {
+			return event, false
+		}
+		event.Type, event.ID, event.Name = "task", taskID, attributes["com.docker.swarm.task.name"]
+		event.Stack = attributes["com.docker.stack.namespace"]
+		event.Service = RemoveStackFromName(attributes["com.docker.swarm.service.name"], event.Stack)
+		event.NodeID = attributes["com.docker.swarm.node.id"]
+		event.Attributes = map[string]string{}
+		for _, key := range taskAttributes {
+			if value, ok := attributes[key]; ok {
+				event.Attributes[key] = value
+			}
+		}
+		return event, true
+	case events.NetworkEventType:
+		if message.Scope != "swarm" {
+			return event, false
+		}
+	}
+
+	event.Stack = b.stackOf(ctx, message)
+	if message.Type == events.ServiceEventType && event.Stack != "" {
+		event.Service = RemoveStackFromName(event.Name, event.Stack)
+	}
+	event.Attributes = attributes
+	return event, true
+}
+
+// Run follows the events of the daemon until ctx is done, reconnecting with a growing delay when the connection is
+// lost and asking for the events since the last one seen, so none are missed in between.
+func (b *EventBroker) Run(ctx context.Context) {
+	if err := b.loadStacks(ctx, filters.NewArgs()); err != nil {
+		log.Printf("Error loading stack resources: %v", err)
+	}
+
+	args := filters.NewArgs()
+	for _, kind := range []events.Type{events.ServiceEventType, events.NodeEventType, events.NetworkEventType, events.SecretEventType, events.ConfigEventType, events.ContainerEventType} {
+		args.Add("type", string(kind))
+	}
+
+	var since int64
+	delay := time.Second
+	for {
+		options := events.ListOptions{Filters: args}
+		if since != 0 {
+			options.Since = fmt.Sprintf("%d.%09d", since/int64(time.Second), since%int64(time.Second))
+		}
+
+		messages, errs := b.cli.Events(ctx, options)
+	follow:
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message := <-messages:
+				delay = time.Second
+				if message.TimeNano <= since {
+					continue
+				}
+				since = message.TimeNano
+				if event, ok := b.event(ctx, message); ok {
+					b.publish(event)
+				}
+			case err := <-errs:
+				log.Printf("Error following docker events, reconnecting in %s: %v", delay, err)
+				break follow
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, 30*time.Second)
+	}
+}
@@ -0,0 +1,128 @@
+package docker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/dockrelix/dockrelix-backend/docker"
+	"github.com/dockrelix/dockrelix-backend/models"
+)
+
+type MockEventsClient struct {
+	MockClient
+	messages chan events.Message
+}
+
+func (m *MockEventsClient) Events(ctx context.Context, options events.ListOptions) (<-chan events.Message, <-chan error) {
+	return m.messages, make(chan error)
+}
+
+func newMockEventsClient() *MockEventsClient {
+	return &MockEventsClient{
+		MockClient: MockClient{
+			ServiceListFunc: func(ctx context.Context, options types.ServiceListOptions) ([]swarm.Service, error) {
+				if !options.Filters.ExactMatch("id", "service_id") {
+					return nil, nil
+				}
+				return []swarm.Service{{
+					ID:   "service_id",
+					Spec: swarm.ServiceSpec{Annotations: swarm.Annotations{Name: "test_stack_web", Labels: map[string]string{"com.docker.stack.namespace": "test_stack"}}},
+				}}, nil
+			},
+			NetworkListFunc: func(ctx context.Context, options network.ListOptions) ([]network.Summary, error) {
+				return nil, nil
+			},
+			SecretListFunc: func(ctx context.Context, options types.SecretListOptions) ([]swarm.Secret, error) {
+				return nil, nil
+			},
+			ConfigListFunc: func(ctx context.Context, options types.ConfigListOptions) ([]swarm.Config, error) {
+				return nil, nil
+			},
+		},
+		messages: make(chan events.Message),
+	}
+}
+
+func receiveEvent(t *testing.T, events <-chan models.Event) models.Event {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("expected an event")
+		return models.Event{}
+	}
+}
+
+func TestEventBroker(t *testing.T) {
+	mockClient := newMockEventsClient()
+	broker := docker.NewEventBroker(mockClient)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go broker.Run(ctx)
+
+	all := broker.Subscribe("", docker.EventFilter{})
+	defer all.Close()
+	tasks := broker.Subscribe("", docker.EventFilter{Types: []string{"task"}})
+	defer tasks.Close()
+
+	now := time.Now().UnixNano()
+	mockClient.messages <- events.Message{
+		Type: events.ServiceEventType, Action: events.ActionCreate, TimeNano: now,
+		Actor: events.Actor{ID: "service_id", Attributes: map[string]string{"name": "test_stack_web"}},
+	}
+	mockClient.messages <- events.Message{
+		Type: events.NetworkEventType, Action: events.ActionConnect, Scope: "local", TimeNano: now + 1,
+		Actor: events.Actor{ID: "bridge"},
+	}
+	mockClient.messages <- events.Message{
+		Type: events.ContainerEventType, Action: events.ActionExecStart, TimeNano: now + 2,
+		Actor: events.Actor{ID: "container_id", Attributes: map[string]string{"com.docker.swarm.task.id": "task_id"}},
+	}
+	mockClient.messages <- events.Message{
+		Type: events.ContainerEventType, Action: events.ActionDie, TimeNano: now + 3,
+		Actor: events.Actor{ID: "container_id", Attributes: map[string]string{
+			"com.docker.swarm.task.id":      "task_id",
+			"com.docker.swarm.task.name":    "test_stack_web.1.task_id",
+			"com.docker.swarm.service.name": "test_stack_web",
+			"com.docker.swarm.node.id":      "node_id",
+			"com.docker.stack.namespace":    "test_stack",
+			"exitCode":                      "137",
+			"maintainer":                    "someone",
+		}},
+	}
+
+	service := receiveEvent(t, all.Events)
+	if service.Type != "service" || service.Action != "create" || service.Stack != "test_stack" || service.Service != "web" {
+		t.Errorf("unexpected service event %+v", service)
+	}
+
+	task := receiveEvent(t, all.Events)
+	if task.Type != "task" || task.ID != "task_id" || task.Stack != "test_stack" || task.Service != "web" || task.NodeID != "node_id" {
+		t.Errorf("unexpected task event %+v", task)
+	}
+	if task.Attributes["exitCode"] != "137" || task.Attributes["maintainer"] != "" {
+		t.Errorf("expected only the task attributes to be kept, got %v", task.Attributes)
+	}
+
+	if filtered := receiveEvent(t, tasks.Events); filtered.Cursor != task.Cursor {
+		t.Errorf("expected the task subscriber to get only the task event, got %+v", filtered)
+	}
+
+	resumed := broker.Subscribe(service.Cursor, docker.EventFilter{})
+	defer resumed.Close()
+	if resumed.Reset || len(resumed.Replay) != 1 || resumed.Replay[0].Cursor != task.Cursor {
+		t.Errorf("expected the task event to be replayed, got %+v", resumed)
+	}
+
+	stale := broker.Subscribe("0-1", docker.EventFilter{})
+	defer stale.Close()
+	if !stale.Reset || len(stale.Replay) != 0 {
+		t.Errorf("expected a cursor of another broker to reset, got %+v", stale)
+	}
+}
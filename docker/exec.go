@@ -0,0 +1,396 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/errdefs"
+	"github.com/dockrelix/dockrelix-backend/database"
+	"github.com/dockrelix/dockrelix-backend/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrCommandNotAllowed   = errors.New("command not allowed")
+	ErrTaskNotRunning      = errors.New("task not running")
+	ErrTaskNotLocal        = errors.New("task runs on another node")
+	ErrExecSessionNotFound = errors.New("exec session not found")
+)
+
+// maxRecordingSize bounds the recording of a session, later events are dropped and the session marked truncated.
+// recordingFlushInterval and recordingFlushSize bound what is held in memory before it is stored.
+const (
+	maxRecordingSize       = 16 << 20
+	recordingFlushInterval = time.Second
+	recordingFlushSize     = 64 << 10
+)
+
+// defaultExecCommands are allowed when EXEC_ALLOWED_COMMANDS does not list the allowed commands itself.
+var defaultExecCommands = []string{"sh", "bash", "ash", "/bin/sh", "/bin/bash", "/bin/ash"}
+
+// ExecClient adds the calls needed to find the container of a task and exec into it
+type ExecClient interface {
+	serviceInspector
+	TaskList(ctx context.Context, options types.TaskListOptions) ([]swarm.Task, error)
+	TaskInspectWithRaw(ctx context.Context, taskID string) (swarm.Task, []byte, error)
+	Info(ctx context.Context) (system.Info, error)
+	ContainerExecCreate(ctx context.Context, containerID string, options container.ExecOptions) (container.ExecCreateResponse, error)
+	ContainerExecAttach(ctx context.Context, execID string, config container.ExecAttachOptions) (types.HijackedResponse, error)
+	ContainerExecResize(ctx context.Context, execID string, options container.ResizeOptions) error
+	ContainerExecInspect(ctx context.Context, execID string) (container.ExecInspect, error)
+}
+
+// ExecAllowed tells whether a command line is on the allowlist, a comma separated list of whole command lines read
+// from EXEC_ALLOWED_COMMANDS.
+func ExecAllowed(cmd []string) bool {
+	allowed := defaultExecCommands
+	if value := os.Getenv("EXEC_ALLOWED_COMMANDS"); value != "" {
+		allowed = nil
+		for _, command := range strings.Split(value, ",") {
+			if command = strings.TrimSpace(command); command != "" {
+				allowed = append(allowed, command)
+			}
+		}
+	}
+	return len(cmd) > 0 && slices.Contains(allowed, strings.Join(cmd, " "))
+}
+
+// resolveExecTask finds the running task to exec into, the given one or else the first running one of the service.
+// The daemon only execs into containers on its own node, so tasks on other nodes are refused.
+func resolveExecTask(cli ExecClient, stackName, service, taskID string) (swarm.Task, error) {
+	srv, err := getStackService(cli, stackName, service)
+	if err != nil {
+		return swarm.Task{}, err
+	}
+	info, err := cli.Info(context.Background())
+	if err != nil {
+		return swarm.Task{}, err
+	}
+
+	var tasks []swarm.Task
+	if taskID != "" {
+		task, _, err := cli.TaskInspectWithRaw(context.Background(), taskID)
+		if errdefs.IsNotFound(err) || err == nil && task.ServiceID != srv.ID {
+			return swarm.Task{}, fmt.Errorf("%w: %s is not a task of %s", ErrTaskNotFound, taskID, service)
+		}
+		if err != nil {
+			return swarm.Task{}, err
+		}
+		tasks = []swarm.Task{task}
+	} else {
+		tasks, err = cli.TaskList(context.Background(), types.TaskListOptions{
+			Filters: filters.NewArgs(filters.Arg("service", srv.ID), filters.Arg("desired-state", "running")),
+		})
+		if err != nil {
+			return swarm.Task{}, err
+		}
+		slices.SortFunc(tasks, func(a, b swarm.Task) int { return a.Slot - b.Slot })
+	}
+
+	running := false
+	for _, task := range tasks {
+		if task.Status.State != swarm.TaskStateRunning || task.Status.ContainerStatus == nil {
+			continue
+		}
+		if task.NodeID == info.Swarm.NodeID {
+			return task, nil
+		}
+		running = true
+	}
+	if running {
+		return swarm.Task{}, fmt.Errorf("%w: exec is only possible into tasks on node %s", ErrTaskNotLocal, info.Name)
+	}
+	return swarm.Task{}, fmt.Errorf("%w: %s has no running task", ErrTaskNotRunning, service)
+}
+
+// castRecorder writes a session in the asciicast v2 format: a header line followed by an [elapsed, kind, data] line
+// for each output, input and resize event. Events are buffered and handed to save every recordingFlushInterval,
+// whether or not new events arrive, or sooner once recordingFlushSize bytes are waiting.
+type castRecorder struct {
+	mu        sync.Mutex
+	start     time.Time
+	save      func([]byte) error
+	buffer    bytes.Buffer
+	size      int
+	pending   map[string][]byte
+	truncated bool
+	closed    bool
+	done      chan struct{}
+}
+
+func newCastRecorder(width, height uint, command string, save func([]byte) error) *castRecorder {
+	r := &castRecorder{start: time.Now(), save: save, pending: map[string][]byte{}, done: make(chan struct{})}
+	header, _ := json.Marshal(map[string]any{
+		"version":   2,
+		"width":     width,
+		"height":    height,
+		"timestamp": r.start.Unix(),
+		"command":   command,
+	})
+	r.buffer.Write(append(header, '\n'))
+	r.size = r.buffer.Len()
+	r.flush()
+	go r.flushPeriodically()
+	return r
+}
+
+// flushPeriodically saves the buffer every recordingFlushInterval until the recorder is closed.
+func (r *castRecorder) flushPeriodically() {
+	ticker := time.NewTicker(recordingFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.mu.Lock()
+			if !r.closed {
+				r.flush()
+			}
+			r.mu.Unlock()
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// flush saves the buffered events, they are kept for the next flush when saving fails. The caller holds r.mu.
+func (r *castRecorder) flush() {
+	if r.buffer.Len() == 0 {
+		return
+	}
+	if err := r.save(r.buffer.Bytes()); err != nil {
+		log.Printf("Error saving exec recording: %v", err)
+		return
+	}
+	r.buffer.Reset()
+}
+
+// record adds an event, a multi-byte character split across reads is held back until it is complete.
+func (r *castRecorder) record(kind string, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data = append(r.pending[kind], data...)
+	r.pending[kind] = nil
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				data, r.pending[kind] = data[:i], slices.Clone(data[i:])
+			}
+			break
+		}
+	}
+	if len(data) == 0 || r.truncated || r.closed {
+		return
+	}
+
+	event, _ := json.Marshal([]any{time.Since(r.start).Seconds(), kind, string(data)})
+	if r.size+len(event)+1 > maxRecordingSize {
+		r.truncated = true
+		return
+	}
+	r.buffer.Write(append(event, '\n'))
+	r.size += len(event) + 1
+	if r.buffer.Len() >= recordingFlushSize {
+		r.flush()
+	}
+}
+
+// close saves what is left of the recording and tells whether it was truncated, later events are dropped.
+func (r *castRecorder) close() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.closed {
+		r.closed = true
+		close(r.done)
+		r.flush()
+	}
+	return r.truncated
+}
+
+// ExecStream is an interactive exec with a TTY in a task container, recorded from start to end.
+type ExecStream struct {
+	cli      ExecClient
+	execID   string
+	conn     types.HijackedResponse
+	recorder *castRecorder
+	session  models.ExecSession
+	close    sync.Once
+}
+
+// StartExec execs an allowed command with a TTY in the container of a running task of a service and attaches to it.
+// The session is stored before anything runs and its recording as it goes, so both are on record even if the
+// session never ends cleanly.
+func StartExec(cli ExecClient, stackName, service, taskID string, cmd []string, username string, width, height uint) (*ExecStream, error) {
+	if !ExecAllowed(cmd) {
+		return nil, fmt.Errorf("%w: %q", ErrCommandNotAllowed, strings.Join(cmd, " "))
+	}
+
+	task, err := resolveExecTask(cli, stackName, service, taskID)
+	if err != nil {
+		return nil, err
+	}
+	containerID := task.Status.ContainerStatus.ContainerID
+	size := &[2]uint{height, width}
+
+	created, err := cli.ContainerExecCreate(context.Background(), containerID, container.ExecOptions{
+		Tty:          true,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		ConsoleSize:  size,
+		Cmd:          cmd,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stream := &ExecStream{
+		cli:    cli,
+		execID: created.ID,
+		session: models.ExecSession{
+			StackName:   stackName,
+			Service:     service,
+			TaskID:      task.ID,
+			NodeID:      task.NodeID,
+			ContainerID: containerID,
+			Command:     strings.Join(cmd, " "),
+			Username:    username,
+			StartedAt:   time.Now(),
+		},
+	}
+	if err := database.DB.Create(&stream.session).Error; err != nil {
+		return nil, err
+	}
+	sessionID := stream.session.ID
+	stream.recorder = newCastRecorder(width, height, stream.session.Command, func(data []byte) error {
+		return database.DB.Create(&models.ExecRecordingChunk{SessionID: sessionID, Data: slices.Clone(data)}).Error
+	})
+
+	stream.conn, err = cli.ContainerExecAttach(context.Background(), created.ID, container.ExecAttachOptions{Tty: true, ConsoleSize: size})
+	if err != nil {
+		stream.Close()
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (s *ExecStream) Session() models.ExecSession {
+	return s.session
+}
+
+// Input writes to the stdin of the command.
+func (s *ExecStream) Input(data []byte) error {
+	s.recorder.record("i", data)
+	_, err := s.conn.Conn.Write(data)
+	return err
+}
+
+// Resize resizes the TTY of the command.
+func (s *ExecStream) Resize(width, height uint) error {
+	s.recorder.record("r", []byte(fmt.Sprintf("%dx%d", width, height)))
+	return s.cli.ContainerExecResize(context.Background(), s.execID, container.ResizeOptions{Width: width, Height: height})
+}
+
+// Output hands what the command writes to its TTY to emit until the command exits or emit returns an error.
+func (s *ExecStream) Output(emit func([]byte) error) error {
+	buffer := make([]byte, 32<<10)
+	for {
+		n, err := s.conn.Reader.Read(buffer)
+		if n > 0 {
+			s.recorder.record("o", buffer[:n])
+			if err := emit(slices.Clone(buffer[:n])); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Close detaches from the command and stores the rest of the recording along with the exit code, once the command
+// has exited.
+func (s *ExecStream) Close() models.ExecSession {
+	s.close.Do(func() {
+		if s.conn.Conn != nil {
+			s.conn.Close()
+		}
+
+		// The exec is reported running for a moment after its output ended.
+		for attempt := 0; attempt < 10; attempt++ {
+			inspect, err := s.cli.ContainerExecInspect(context.Background(), s.execID)
+			if err != nil {
+				break
+			}
+			if !inspect.Running {
+				s.session.ExitCode = &inspect.ExitCode
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		now := time.Now()
+		s.session.EndedAt = &now
+		s.session.Truncated = s.recorder.close()
+		if err := database.DB.Save(&s.session).Error; err != nil {
+			log.Printf("Error saving exec session %d: %v", s.session.ID, err)
+		}
+	})
+	return s.session
+}
+
+// ListExecSessions lists the recorded sessions of a stack, or of all stacks, newest first.
+func ListExecSessions(stackName string) ([]models.ExecSession, error) {
+	query := database.DB.Order("id desc")
+	if stackName != "" {
+		query = query.Where("stack_name = ?", stackName)
+	}
+	var sessions []models.ExecSession
+	err := query.Find(&sessions).Error
+	return sessions, err
+}
+
+func GetExecSession(id uint) (models.ExecSession, error) {
+	var session models.ExecSession
+	err := database.DB.First(&session, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return session, ErrExecSessionNotFound
+	}
+	return session, err
+}
+
+// GetExecRecording joins the stored chunks of the recording of a session, which is still growing while the session runs.
+func GetExecRecording(id uint) ([]byte, error) {
+	if _, err := GetExecSession(id); err != nil {
+		return nil, err
+	}
+
+	var chunks []models.ExecRecordingChunk
+	if err := database.DB.Where("session_id = ?", id).Order("id").Find(&chunks).Error; err != nil {
+		return nil, err
+	}
+	var recording bytes.Buffer
+	for _, chunk := range chunks {
+		recording.Write(chunk.Data)
+	}
+	return recording.Bytes(), nil
+}
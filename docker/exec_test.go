@@ -0,0 +1,171 @@
+package docker_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/api/types/system"
+	"github.com/dockrelix/dockrelix-backend/database"
+	"github.com/dockrelix/dockrelix-backend/docker"
+)
+
+type MockExecClient struct {
+	MockLogClient
+	nodeID string
+	conn   net.Conn
+	exec   container.ExecOptions
+	resize container.ResizeOptions
+}
+
+func (m *MockExecClient) Info(ctx context.Context) (system.Info, error) {
+	return system.Info{Name: "manager-1", Swarm: swarm.Info{NodeID: m.nodeID}}, nil
+}
+
+func (m *MockExecClient) ContainerExecCreate(ctx context.Context, containerID string, options container.ExecOptions) (container.ExecCreateResponse, error) {
+	m.exec = options
+	return container.ExecCreateResponse{ID: "exec_" + containerID}, nil
+}
+
+func (m *MockExecClient) ContainerExecAttach(ctx context.Context, execID string, config container.ExecAttachOptions) (types.HijackedResponse, error) {
+	server, client := net.Pipe()
+	m.conn = server
+	return types.NewHijackedResponse(client, ""), nil
+}
+
+func (m *MockExecClient) ContainerExecResize(ctx context.Context, execID string, options container.ResizeOptions) error {
+	m.resize = options
+	return nil
+}
+
+func (m *MockExecClient) ContainerExecInspect(ctx context.Context, execID string) (container.ExecInspect, error) {
+	return container.ExecInspect{ExecID: execID, ExitCode: 3}, nil
+}
+
+func newMockExecClient(nodeID string) *MockExecClient {
+	mockClient := &MockExecClient{MockLogClient: *newMockLogClient(), nodeID: nodeID}
+	mockClient.tasks = []swarm.Task{{
+		ID: "task_1", ServiceID: "service_id", Slot: 1, NodeID: "node_1",
+		Status: swarm.TaskStatus{State: swarm.TaskStateRunning, ContainerStatus: &swarm.ContainerStatus{ContainerID: "container_1"}},
+	}}
+	return mockClient
+}
+
+func TestExecAllowed(t *testing.T) {
+	if !docker.ExecAllowed([]string{"sh"}) || docker.ExecAllowed([]string{"rm", "-rf", "/"}) || docker.ExecAllowed(nil) {
+		t.Error("expected only the default shells to be allowed")
+	}
+
+	t.Setenv("EXEC_ALLOWED_COMMANDS", "ps aux, env")
+	if !docker.ExecAllowed([]string{"ps", "aux"}) || !docker.ExecAllowed([]string{"env"}) || docker.ExecAllowed([]string{"sh"}) {
+		t.Error("expected the configured commands to replace the defaults")
+	}
+}
+
+func TestStartExec(t *testing.T) {
+	database.InitDBForTesting()
+	mockClient := newMockExecClient("node_1")
+
+	if _, err := docker.StartExec(mockClient, "test_stack", "web", "", []string{"rm", "-rf", "/"}, "admin", 80, 24); !errors.Is(err, docker.ErrCommandNotAllowed) {
+		t.Errorf("expected ErrCommandNotAllowed, got %v", err)
+	}
+	if _, err := docker.StartExec(newMockExecClient("node_2"), "test_stack", "web", "", []string{"sh"}, "admin", 80, 24); !errors.Is(err, docker.ErrTaskNotLocal) {
+		t.Errorf("expected ErrTaskNotLocal, got %v", err)
+	}
+
+	exec, err := docker.StartExec(mockClient, "test_stack", "web", "", []string{"sh"}, "admin", 80, 24)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !mockClient.exec.Tty || mockClient.exec.ConsoleSize[1] != 80 {
+		t.Errorf("expected an exec with a TTY of 80 columns, got %+v", mockClient.exec)
+	}
+	if recording, err := docker.GetExecRecording(exec.Session().ID); err != nil || !strings.Contains(string(recording), `"width":80`) {
+		t.Errorf("expected the recording header to be stored once the session starts, got %q, %v", recording, err)
+	}
+
+	go func() {
+		reader := bufio.NewReader(mockClient.conn)
+		input, _ := reader.ReadString('\n')
+		// The euro sign is split across writes to check that the recording keeps it whole.
+		mockClient.conn.Write([]byte("you typed " + strings.TrimSpace(input) + " \xe2\x82"))
+		mockClient.conn.Write([]byte("\xac\r\n"))
+		mockClient.conn.Close()
+	}()
+
+	if err := exec.Resize(120, 40); err != nil || mockClient.resize.Width != 120 {
+		t.Errorf("expected a resize to 120 columns, got %+v, %v", mockClient.resize, err)
+	}
+	if err := exec.Input([]byte("ls\n")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var output strings.Builder
+	if err := exec.Output(func(data []byte) error {
+		output.Write(data)
+		return nil
+	}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if output.String() != "you typed ls €\r\n" {
+		t.Errorf("unexpected output %q", output.String())
+	}
+
+	session := exec.Close()
+	stored, err := docker.GetExecSession(session.ID)
+	if err != nil {
+		t.Fatalf("expected the session to be stored, got %v", err)
+	}
+
+	if stored.Username != "admin" || stored.ContainerID != "container_1" || stored.ExitCode == nil || *stored.ExitCode != 3 || stored.EndedAt == nil {
+		t.Errorf("unexpected session %+v", stored)
+	}
+
+	data, err := docker.GetExecRecording(session.ID)
+	if err != nil {
+		t.Fatalf("expected the recording to be stored, got %v", err)
+	}
+	recording := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(recording) != 5 || !strings.Contains(recording[0], `"width":80`) || !strings.HasSuffix(recording[1], `"r","120x40"]`) ||
+		!strings.HasSuffix(recording[2], `"i","ls\n"]`) || !strings.HasSuffix(recording[3], `"o","you typed ls "]`) ||
+		!strings.HasSuffix(recording[4], `"o","€\r\n"]`) {
+		t.Errorf("unexpected recording %q", recording)
+	}
+}
+
+func TestExecRecordingFlushedWhileIdle(t *testing.T) {
+	database.InitDBForTesting()
+	mockClient := newMockExecClient("node_1")
+
+	exec, err := docker.StartExec(mockClient, "test_stack", "web", "", []string{"sh"}, "admin", 80, 24)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer exec.Close()
+
+	go io.Copy(io.Discard, mockClient.conn)
+	if err := exec.Input([]byte("pwd\n")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// No further events arrive, the input has to be stored by the flush timer alone.
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		recording, err := docker.GetExecRecording(exec.Session().ID)
+		if err == nil && strings.Contains(string(recording), `"i","pwd\n"]`) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the input to be stored while the session is idle, got %q, %v", recording, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,119 @@
+package docker
+
+import (
+	"github.com/dockrelix/dockrelix-backend/models"
+	"gopkg.in/yaml.v3"
+)
+
+// maxExpandedNodes bounds the size of a document once its aliases are copied out, so a handful of
+// nested aliases cannot blow up into millions of nodes.
+const maxExpandedNodes = 100000
+
+type documentExpander struct {
+	active map[*yaml.Node]bool
+	nodes  int
+	errors []models.ValidationError
+}
+
+func (e *documentExpander) add(node *yaml.Node, message string) {
+	e.errors = append(e.errors, models.ValidationError{Line: node.Line, Column: node.Column, Message: message})
+}
+
+// mergeSources returns the mappings a << key pulls in, in order of precedence.
+func (e *documentExpander) mergeSources(node *yaml.Node) []*yaml.Node {
+	node = resolveAlias(node)
+	sources := []*yaml.Node{node}
+	if node.Kind == yaml.SequenceNode {
+		sources = node.Content
+	}
+
+	result := make([]*yaml.Node, 0, len(sources))
+	for _, source := range sources {
+		expanded := e.expand(source)
+		if expanded == nil {
+			continue
+		}
+		if expanded.Kind != yaml.MappingNode {
+			e.add(source, "merge key requires a mapping or a list of mappings")
+			continue
+		}
+		result = append(result, expanded)
+	}
+	return result
+}
+
+// expand returns a copy of node with every alias replaced by a copy of its anchor and every << merge key
+// folded into its mapping, keys set explicitly taking precedence over merged ones.
+func (e *documentExpander) expand(node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.AliasNode {
+		if e.active[node.Alias] {
+			e.add(node, "alias *"+node.Value+" refers to itself")
+			return nil
+		}
+		e.active[node.Alias] = true
+		defer delete(e.active, node.Alias)
+		return e.expand(node.Alias)
+	}
+
+	e.nodes++
+	if e.nodes > maxExpandedNodes {
+		if e.nodes == maxExpandedNodes+1 {
+			e.add(node, "document is too large once its aliases are expanded")
+		}
+		return nil
+	}
+
+	result := *node
+	result.Anchor = ""
+	result.Content = nil
+
+	if node.Kind != yaml.MappingNode {
+		for _, child := range node.Content {
+			if expanded := e.expand(child); expanded != nil {
+				result.Content = append(result.Content, expanded)
+			}
+		}
+		return &result
+	}
+
+	keys := map[string]bool{}
+	var merges []*yaml.Node
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.Value == "<<" && key.ShortTag() == "!!merge" {
+			merges = append(merges, value)
+			continue
+		}
+		expandedKey, expanded := e.expand(key), e.expand(value)
+		if expandedKey == nil || expanded == nil {
+			continue
+		}
+		keys[key.Value] = true
+		result.Content = append(result.Content, expandedKey, expanded)
+	}
+
+	for _, merge := range merges {
+		for _, source := range e.mergeSources(merge) {
+			for i := 0; i+1 < len(source.Content); i += 2 {
+				key := source.Content[i]
+				if keys[key.Value] {
+					continue
+				}
+				keys[key.Value] = true
+				result.Content = append(result.Content, key, source.Content[i+1])
+			}
+		}
+	}
+	return &result
+}
+
+// expandDocument resolves anchors, aliases and merge keys so the rest of the pipeline only sees plain
+// nodes, each alias getting its own copy so interpolation and merging never touch the anchor twice.
+func expandDocument(node *yaml.Node) (*yaml.Node, []models.ValidationError) {
+	e := &documentExpander{active: map[*yaml.Node]bool{}}
+	result := e.expand(node)
+	if len(e.errors) > 0 {
+		return nil, e.errors
+	}
+	return result, nil
+}
@@ -0,0 +1,64 @@
+package docker_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dockrelix/dockrelix-backend/docker"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRenderComposeAnchorsAndExtensions(t *testing.T) {
+	data := `x-common: &common
+  image: app:${TAG:-latest}
+  environment:
+    - HOME=$$HOME
+  deploy:
+    replicas: 2
+services:
+  api:
+    <<: *common
+    x-team: backend
+  worker:
+    <<: *common
+    deploy:
+      replicas: 1
+`
+
+	config, err := docker.RenderCompose(data, map[string]string{"TAG": "1.0"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	api, worker := config.Services["api"], config.Services["worker"]
+	if api.Image != "app:1.0" || worker.Image != "app:1.0" {
+		t.Errorf("expected merged images, got %q and %q", api.Image, worker.Image)
+	}
+	if api.Deploy.Replicas != 2 || worker.Deploy.Replicas != 1 {
+		t.Errorf("expected explicit keys to win over merged ones, got %d and %d", api.Deploy.Replicas, worker.Deploy.Replicas)
+	}
+	if api.Environment[0] != "HOME=$HOME" || worker.Environment[0] != "HOME=$HOME" {
+		t.Errorf("expected every alias to be interpolated once, got %v and %v", api.Environment, worker.Environment)
+	}
+	if api.Extensions["x-team"] != "backend" {
+		t.Errorf("expected service extension to be kept, got %v", api.Extensions)
+	}
+	if _, ok := config.Extensions["x-common"]; !ok {
+		t.Errorf("expected top level extension to be kept, got %v", config.Extensions)
+	}
+
+	out, err := yaml.Marshal(config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(out), "x-common:") || !strings.Contains(string(out), "x-team: backend") {
+		t.Errorf("expected extensions to survive a round trip, got:\n%s", out)
+	}
+}
+
+func TestValidateComposeRecursiveAlias(t *testing.T) {
+	errs := docker.ValidateCompose("x-loop: &loop\n  - *loop\nservices: {}\n")
+	if len(errs) != 1 || errs[0].Line != 2 {
+		t.Errorf("expected a single error on line 2, got %+v", errs)
+	}
+}
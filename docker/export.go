@@ -0,0 +1,129 @@
+package docker
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/dockrelix/dockrelix-backend/models/parser"
+	"gopkg.in/yaml.v3"
+)
+
+// ExportClient adds the calls needed to read the contents of configs
+type ExportClient interface {
+	DockerClient
+	ConfigInspectWithRaw(ctx context.Context, id string) (swarm.Config, []byte, error)
+}
+
+// bundleReadme explains the secrets of a bundle, which refers to them as external secrets because swarm never hands
+// their values back.
+const bundleReadme = `This bundle holds the compose file of stack %s along with the contents of its configs.
+
+Swarm does not hand back the values of secrets, so the compose file refers to them as external secrets. Create
+each of them on the swarm the bundle is deployed to first:
+%s`
+
+// ExportStack returns the compose file of a running stack as YAML.
+func ExportStack(cli DockerClient, stackName string) ([]byte, error) {
+	return generateStackFile(cli, stackName)
+}
+
+// ExportStackBundle writes a tar.gz archive holding the compose file of a running stack along with the contents of
+// its configs, referenced by file so the archive can be imported. Secrets are turned into external secrets under
+// their swarm name and listed in a README, since their values have to be created by hand before deploying.
+func ExportStackBundle(cli ExportClient, stackName string, w io.Writer) error {
+	data, err := generateStackFile(cli, stackName)
+	if err != nil {
+		return err
+	}
+
+	var config parser.ComposeConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return err
+	}
+
+	configs, err := cli.ConfigList(context.Background(), types.ConfigListOptions{
+		Filters: stackFilter(stackName),
+	})
+	if err != nil {
+		return err
+	}
+
+	files := map[string][]byte{}
+	for _, listed := range configs {
+		name := RemoveStackFromName(listed.Spec.Name, stackName)
+		cfg, ok := config.Configs[name]
+		if !ok || cfg.External {
+			continue
+		}
+
+		inspected, _, err := cli.ConfigInspectWithRaw(context.Background(), listed.ID)
+		if err != nil {
+			return fmt.Errorf("config %q: %w", name, err)
+		}
+
+		file := "configs/" + name
+		files[file] = inspected.Spec.Data
+		cfg.File, cfg.Content = "./"+file, ""
+		config.Configs[name] = cfg
+	}
+
+	var secrets strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(config.Secrets)) {
+		secret := config.Secrets[name]
+		fullName := resourceName(name, secret.Name, stackName, secret.External)
+		fmt.Fprintf(&secrets, "\n    printf '%%s' \"$VALUE\" | docker secret create %s -\n", fullName)
+		if !secret.External {
+			config.Secrets[name] = parser.Secret{External: true, Name: fullName}
+		}
+	}
+	if secrets.Len() > 0 {
+		files["README.txt"] = []byte(fmt.Sprintf(bundleReadme, stackName, secrets.String()))
+	}
+
+	compose, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	now := time.Now()
+
+	write := func(name string, content []byte, mode int64) error {
+		header := &tar.Header{
+			Name:     name,
+			Mode:     mode,
+			Size:     int64(len(content)),
+			ModTime:  now,
+			Typeflag: tar.TypeReg,
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := archive.Write(content)
+		return err
+	}
+
+	if err := write("docker-compose.yml", compose, 0644); err != nil {
+		return err
+	}
+	for _, file := range slices.Sorted(maps.Keys(files)) {
+		if err := write(file, files[file], 0644); err != nil {
+			return err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,105 @@
+package docker_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/dockrelix/dockrelix-backend/docker"
+)
+
+type MockExportClient struct {
+	MockClient
+	configs []swarm.Config
+}
+
+func (m *MockExportClient) ConfigInspectWithRaw(ctx context.Context, id string) (swarm.Config, []byte, error) {
+	for _, cfg := range m.configs {
+		if cfg.ID == id {
+			return cfg, cfg.Spec.Data, nil
+		}
+	}
+	return swarm.Config{}, nil, nil
+}
+
+func TestExportStackBundle(t *testing.T) {
+	configs := []swarm.Config{{
+		ID: "config_id",
+		Spec: swarm.ConfigSpec{
+			Annotations: swarm.Annotations{Name: "test_stack_nginx", Labels: testStackLabels},
+			Data:        []byte("worker_processes 1;"),
+		},
+	}}
+	mockClient := &MockExportClient{
+		MockClient: MockClient{
+			ServiceListFunc: func(ctx context.Context, options types.ServiceListOptions) ([]swarm.Service, error) {
+				return []swarm.Service{{
+					Spec: swarm.ServiceSpec{
+						Annotations: swarm.Annotations{Name: "test_stack_web", Labels: testStackLabels},
+						TaskTemplate: swarm.TaskSpec{
+							ContainerSpec: &swarm.ContainerSpec{
+								Image: "nginx:latest",
+								Configs: []*swarm.ConfigReference{
+									{ConfigName: "test_stack_nginx", File: &swarm.ConfigReferenceFileTarget{Name: "/etc/nginx/nginx.conf"}},
+								},
+								Secrets: []*swarm.SecretReference{
+									{SecretName: "test_stack_token", File: &swarm.SecretReferenceFileTarget{Name: "token"}},
+								},
+							},
+						},
+					},
+				}}, nil
+			},
+			NetworkListFunc: func(ctx context.Context, options network.ListOptions) ([]network.Summary, error) {
+				return nil, nil
+			},
+			VolumeListFunc: func(ctx context.Context, options volume.ListOptions) (volume.ListResponse, error) {
+				return volume.ListResponse{}, nil
+			},
+			SecretListFunc: func(ctx context.Context, options types.SecretListOptions) ([]swarm.Secret, error) {
+				return []swarm.Secret{{
+					Spec: swarm.SecretSpec{Annotations: swarm.Annotations{Name: "test_stack_token", Labels: testStackLabels}},
+				}}, nil
+			},
+			ConfigListFunc: func(ctx context.Context, options types.ConfigListOptions) ([]swarm.Config, error) {
+				return configs, nil
+			},
+		},
+		configs: configs,
+	}
+
+	var bundle bytes.Buffer
+	if err := docker.ExportStackBundle(mockClient, "test_stack", &bundle); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	archive, err := docker.ReadImport("test_stack.tar.gz", &bundle)
+	if err != nil {
+		t.Fatalf("expected a readable archive, got %v", err)
+	}
+	if string(archive.Files["configs/nginx"]) != "worker_processes 1;" {
+		t.Errorf("expected config contents in the bundle, got %q", archive.Files["configs/nginx"])
+	}
+	if _, ok := archive.Files["secrets/token"]; ok {
+		t.Error("expected no file for the secret")
+	}
+	if !strings.Contains(string(archive.Files["README.txt"]), "docker secret create test_stack_token -") {
+		t.Errorf("expected the README to list the secret, got %q", archive.Files["README.txt"])
+	}
+
+	config, err := docker.RenderCompose(string(archive.Files["docker-compose.yml"]), nil)
+	if err != nil {
+		t.Fatalf("expected a valid compose file, got %v", err)
+	}
+	if config.Configs["nginx"].File != "./configs/nginx" || config.Configs["nginx"].Content != "" {
+		t.Errorf("expected config to refer to its file, got %+v", config.Configs["nginx"])
+	}
+	if secret := config.Secrets["token"]; !secret.External || secret.Name != "test_stack_token" || secret.File != "" {
+		t.Errorf("expected secret to be external under its swarm name, got %+v", secret)
+	}
+}
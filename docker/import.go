@@ -0,0 +1,387 @@
+package docker
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dockrelix/dockrelix-backend/models"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+)
+
+// maxImportSize bounds both the upload and the files extracted from it.
+const maxImportSize = 10 << 20
+
+var ErrInvalidImport = errors.New("invalid import")
+
+// composeFileNames and composeOverrideNames are looked up in this order, the same as `docker compose` does.
+var (
+	composeFileNames     = []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"}
+	composeOverrideNames = []string{"compose.override.yaml", "compose.override.yml", "docker-compose.override.yaml", "docker-compose.override.yml"}
+)
+
+// ImportArchive is an uploaded compose file or archive read into memory, Skipped lists the entries that were left out.
+type ImportArchive struct {
+	Files   map[string][]byte
+	Skipped []models.ValidationError
+	size    int
+}
+
+func (a *ImportArchive) add(name string, r io.Reader) error {
+	name = path.Clean(strings.TrimPrefix(name, "./"))
+	if strings.HasPrefix(name, "__MACOSX/") {
+		return nil
+	}
+	if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+		a.Skipped = append(a.Skipped, models.ValidationError{Path: name, Message: "path points outside of the archive"})
+		return nil
+	}
+
+	content, err := io.ReadAll(io.LimitReader(r, int64(maxImportSize-a.size+1)))
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidImport, name, err)
+	}
+	a.size += len(content)
+	if a.size > maxImportSize {
+		return fmt.Errorf("%w: archive is larger than %d bytes once extracted", ErrInvalidImport, maxImportSize)
+	}
+	a.Files[name] = content
+	return nil
+}
+
+func (a *ImportArchive) skip(name string) {
+	a.Skipped = append(a.Skipped, models.ValidationError{Path: name, Message: "only regular files can be imported"})
+}
+
+func (a *ImportArchive) readTar(r io.Reader) error {
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidImport, err)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir, tar.TypeXGlobalHeader:
+		case tar.TypeReg:
+			if err := a.add(header.Name, reader); err != nil {
+				return err
+			}
+		default:
+			a.skip(header.Name)
+		}
+	}
+}
+
+func (a *ImportArchive) readZip(data []byte) error {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		if !file.Mode().IsRegular() {
+			a.skip(file.Name)
+			continue
+		}
+
+		content, err := file.Open()
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidImport, file.Name, err)
+		}
+		err = a.add(file.Name, content)
+		content.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadImport reads an uploaded compose file, or a tar, tar.gz or zip archive holding compose files along with
+// their .env file and the files their configs and secrets refer to. The kind of upload is told by its file name.
+func ReadImport(filename string, r io.Reader) (ImportArchive, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxImportSize+1))
+	if err != nil {
+		return ImportArchive{}, err
+	}
+	if len(data) > maxImportSize {
+		return ImportArchive{}, fmt.Errorf("%w: upload is larger than %d bytes", ErrInvalidImport, maxImportSize)
+	}
+
+	archive := ImportArchive{Files: map[string][]byte{}}
+	lower := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		err = archive.readZip(data)
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		var reader *gzip.Reader
+		if reader, err = gzip.NewReader(bytes.NewReader(data)); err != nil {
+			return ImportArchive{}, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+		}
+		err = archive.readTar(reader)
+	case strings.HasSuffix(lower, ".tar"):
+		err = archive.readTar(bytes.NewReader(data))
+	default:
+		err = archive.add(path.Base(filename), bytes.NewReader(data))
+	}
+	if err != nil {
+		return ImportArchive{}, err
+	}
+	return archive, nil
+}
+
+// findComposeFile picks the compose file closest to the root of the archive, a lone file is taken as is.
+func findComposeFile(files map[string][]byte) (string, bool) {
+	best, bestDepth, bestRank := "", 0, 0
+	for name := range files {
+		rank := slices.Index(composeFileNames, path.Base(name))
+		if rank < 0 {
+			continue
+		}
+		depth := strings.Count(name, "/")
+		if best == "" || depth < bestDepth || depth == bestDepth && (rank < bestRank || rank == bestRank && name < best) {
+			best, bestDepth, bestRank = name, depth, rank
+		}
+	}
+	if best != "" {
+		return best, true
+	}
+
+	if len(files) == 1 {
+		for name := range files {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// splitPath turns a validation error path such as services.web.ports[0] back into keys and indexes.
+func splitPath(p string) []string {
+	var segments []string
+	for _, part := range strings.Split(p, ".") {
+		key, rest, _ := strings.Cut(part, "[")
+		if key != "" {
+			segments = append(segments, key)
+		}
+		for rest != "" {
+			var index string
+			index, rest, _ = strings.Cut(rest, "]")
+			segments = append(segments, index)
+			rest = strings.TrimPrefix(rest, "[")
+		}
+	}
+	return segments
+}
+
+func removePath(node *yaml.Node, segments []string) bool {
+	node = resolveAlias(node)
+	if node == nil || len(segments) == 0 {
+		return false
+	}
+
+	key := segments[0]
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value != key {
+				continue
+			}
+			if len(segments) == 1 {
+				node.Content = append(node.Content[:i], node.Content[i+2:]...)
+				return true
+			}
+			return removePath(node.Content[i+1], segments[1:])
+		}
+	case yaml.SequenceNode:
+		index, err := strconv.Atoi(key)
+		if err == nil && index < len(node.Content) && len(segments) > 1 {
+			return removePath(node.Content[index], segments[1:])
+		}
+	}
+	return false
+}
+
+// stripUnknownKeys removes every key the parser does not understand or swarm ignores, such as build or
+// depends_on, from each document of a compose file and reports them.
+func stripUnknownKeys(data string) (string, []models.ValidationError, error) {
+	var unknown []models.ValidationError
+	errs, warnings := CheckCompose(data)
+	for _, err := range errs {
+		if strings.HasPrefix(err.Message, "unknown key ") {
+			err.Message = "unsupported key " + strings.TrimPrefix(err.Message, "unknown key ") + " was dropped"
+			unknown = append(unknown, err)
+		}
+	}
+	for _, warning := range warnings {
+		segments := splitPath(warning.Path)
+		warning.Message = fmt.Sprintf("unsupported key %q was dropped", segments[len(segments)-1])
+		unknown = append(unknown, warning)
+	}
+	sortByPosition(unknown)
+	if len(unknown) == 0 {
+		return data, nil, nil
+	}
+
+	var documents []*yaml.Node
+	decoder := yaml.NewDecoder(strings.NewReader(data))
+	for {
+		var document yaml.Node
+		err := decoder.Decode(&document)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		if len(document.Content) > 0 {
+			documents = append(documents, &document)
+		}
+	}
+
+	for _, err := range unknown {
+		for _, document := range documents {
+			removePath(document.Content[0], splitPath(err.Path))
+		}
+	}
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	for _, document := range documents {
+		if err := encoder.Encode(document); err != nil {
+			return "", nil, err
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return "", nil, err
+	}
+	return out.String(), unknown, nil
+}
+
+// ImportDraft creates a draft from an uploaded archive: the compose file and its override are merged, the .env
+// file next to them becomes the default variables and the files of configs and secrets are stored with the draft.
+// Anything that could not be carried over is listed in the report rather than failing the import. The draft is
+// recorded as a revision unless revision is nil.
+func ImportDraft(name string, archive ImportArchive, revision *models.StackRevision) (models.ImportReport, error) {
+	report := models.ImportReport{
+		Files:       []string{},
+		Variables:   []string{},
+		Unsupported: append([]models.ValidationError{}, archive.Skipped...),
+	}
+
+	composeFile, ok := findComposeFile(archive.Files)
+	if !ok {
+		return report, fmt.Errorf("%w: no compose file found, expected one of %s", ErrInvalidImport, strings.Join(composeFileNames, ", "))
+	}
+	dir := path.Dir(composeFile)
+
+	report.ComposeFiles = []string{composeFile}
+	data := string(archive.Files[composeFile])
+	for _, override := range composeOverrideNames {
+		if content, ok := archive.Files[path.Join(dir, override)]; ok {
+			report.ComposeFiles = append(report.ComposeFiles, path.Join(dir, override))
+			data = JoinDocuments([]string{data, string(content)})
+			break
+		}
+	}
+
+	variables := map[string]string{}
+	if env, ok := archive.Files[path.Join(dir, ".env")]; ok {
+		parsed, err := godotenv.Unmarshal(string(env))
+		if err != nil {
+			return report, fmt.Errorf("%w: invalid .env file: %v", ErrInvalidImport, err)
+		}
+		for key, value := range parsed {
+			if !IsVariableName(key) {
+				report.Unsupported = append(report.Unsupported, models.ValidationError{Path: ".env", Message: fmt.Sprintf("invalid variable name %q was dropped", key)})
+				continue
+			}
+			variables[key] = value
+			report.Variables = append(report.Variables, key)
+		}
+		sort.Strings(report.Variables)
+	}
+
+	data, unknown, err := stripUnknownKeys(data)
+	if err != nil {
+		return report, err
+	}
+	report.Unsupported = append(report.Unsupported, unknown...)
+
+	config, err := RenderCompose(data, variables)
+	if err != nil {
+		return report, err
+	}
+
+	files := map[string][]byte{}
+	collect := func(kind, resource, file string) {
+		field := kind + "s." + resource + ".file"
+		if path.IsAbs(file) {
+			report.Unsupported = append(report.Unsupported, models.ValidationError{Path: field, Message: fmt.Sprintf("absolute path %q cannot be imported, the %s has to exist before deploying", file, kind)})
+			return
+		}
+		content, ok := archive.Files[path.Join(dir, file)]
+		if !ok {
+			report.Unsupported = append(report.Unsupported, models.ValidationError{Path: field, Message: fmt.Sprintf("file %q is not part of the upload, the %s has to exist before deploying", file, kind)})
+			return
+		}
+		files[path.Clean(file)] = content
+	}
+
+	for _, resource := range slices.Sorted(maps.Keys(config.Configs)) {
+		if cfg := config.Configs[resource]; !cfg.External && cfg.File != "" {
+			collect("config", resource, cfg.File)
+		}
+	}
+	for _, resource := range slices.Sorted(maps.Keys(config.Secrets)) {
+		if secret := config.Secrets[resource]; !secret.External && secret.File != "" {
+			collect("secret", resource, secret.File)
+		}
+	}
+
+	draft := models.StackDraft{Name: name, Data: data, Version: 1}
+	err = saveWithRevision(draftRevision(revision, draft), func(tx *gorm.DB) error {
+		report.Files = []string{}
+		if err := tx.Create(&draft).Error; err != nil {
+			return err
+		}
+		if err := setVariables(tx, name, "", variables); err != nil {
+			return err
+		}
+		for _, file := range slices.Sorted(maps.Keys(files)) {
+			if err := tx.Create(&models.StackDraftFile{DraftName: name, Path: file, Content: files[file]}).Error; err != nil {
+				return err
+			}
+			report.Files = append(report.Files, file)
+		}
+		return nil
+	})
+	if err != nil {
+		if isUniqueViolation(err) {
+			return report, ErrDraftExists
+		}
+		return report, err
+	}
+
+	report.Draft = draft
+	return report, nil
+}
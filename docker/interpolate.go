@@ -0,0 +1,213 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dockrelix/dockrelix-backend/models"
+	"github.com/dockrelix/dockrelix-backend/models/parser"
+	"gopkg.in/yaml.v3"
+)
+
+// InvalidComposeError carries every problem found while rendering a compose file.
+type InvalidComposeError struct {
+	Errors []models.ValidationError
+}
+
+func (e *InvalidComposeError) Error() string {
+	if len(e.Errors) == 0 {
+		return "invalid compose file"
+	}
+	first := e.Errors[0]
+	if first.Line > 0 {
+		return fmt.Sprintf("invalid compose file: line %d: %s", first.Line, first.Message)
+	}
+	return "invalid compose file: " + first.Message
+}
+
+func isNameChar(c byte, first bool) bool {
+	if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+		return true
+	}
+	return !first && c >= '0' && c <= '9'
+}
+
+func IsVariableName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isNameChar(name[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// expandBraced resolves the inside of ${...}: a name optionally followed by one of the
+// :-, -, :?, ?, :+ or + modifiers, whose argument may itself contain variables.
+func expandBraced(expression string, variables map[string]string) (string, error) {
+	end := 0
+	for end < len(expression) && isNameChar(expression[end], end == 0) {
+		end++
+	}
+	name := expression[:end]
+	if name == "" {
+		return "", fmt.Errorf("invalid variable expression ${%s}", expression)
+	}
+
+	value, set := variables[name]
+	modifier := expression[end:]
+	if modifier == "" {
+		return value, nil
+	}
+
+	operator := modifier[:1]
+	checkEmpty := false
+	if operator == ":" && len(modifier) > 1 {
+		operator = modifier[1:2]
+		checkEmpty = true
+	}
+	argument := strings.TrimPrefix(strings.TrimPrefix(modifier, ":"), operator)
+	present := set && (!checkEmpty || value != "")
+
+	switch operator {
+	case "-":
+		if present {
+			return value, nil
+		}
+		return Interpolate(argument, variables)
+	case "?":
+		if present {
+			return value, nil
+		}
+		message, err := Interpolate(argument, variables)
+		if err != nil {
+			return "", err
+		}
+		if message == "" {
+			message = "is not set"
+		}
+		return "", fmt.Errorf("required variable %s %s", name, message)
+	case "+":
+		if !present {
+			return "", nil
+		}
+		return Interpolate(argument, variables)
+	}
+	return "", fmt.Errorf("invalid variable expression ${%s}", expression)
+}
+
+// Interpolate substitutes $VAR and ${VAR} references the way docker compose does, $$ is a literal $.
+// Variables that are not set resolve to an empty string.
+func Interpolate(value string, variables map[string]string) (string, error) {
+	if !strings.Contains(value, "$") {
+		return value, nil
+	}
+
+	var result strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '$' || i+1 == len(value) {
+			result.WriteByte(value[i])
+			continue
+		}
+
+		next := value[i+1]
+		switch {
+		case next == '$':
+			result.WriteByte('$')
+			i++
+		case next == '{':
+			depth := 1
+			end := i + 2
+			for ; end < len(value) && depth > 0; end++ {
+				switch value[end] {
+				case '{':
+					depth++
+				case '}':
+					depth--
+				}
+			}
+			if depth > 0 {
+				return "", fmt.Errorf("unterminated variable expression in %q", value)
+			}
+			expanded, err := expandBraced(value[i+2:end-1], variables)
+			if err != nil {
+				return "", err
+			}
+			result.WriteString(expanded)
+			i = end - 1
+		case isNameChar(next, true):
+			end := i + 1
+			for end < len(value) && isNameChar(value[end], end == i+1) {
+				end++
+			}
+			result.WriteString(variables[value[i+1:end]])
+			i = end - 1
+		default:
+			result.WriteByte('$')
+		}
+	}
+	return result.String(), nil
+}
+
+// interpolateNode rewrites every scalar value below node in place. Keys are left alone, as in compose.
+// Plain scalars lose their tag so "${REPLICAS}" can become an integer once substituted.
+func interpolateNode(node *yaml.Node, variables map[string]string, errs *[]models.ValidationError) {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			interpolateNode(child, variables, errs)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			interpolateNode(node.Content[i+1], variables, errs)
+		}
+	case yaml.ScalarNode:
+		if !strings.Contains(node.Value, "$") {
+			return
+		}
+		value, err := Interpolate(node.Value, variables)
+		if err != nil {
+			*errs = append(*errs, models.ValidationError{Line: node.Line, Column: node.Column, Message: err.Error()})
+			return
+		}
+		node.Value = value
+		if node.Style&(yaml.TaggedStyle|yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+			node.Tag = ""
+		}
+	}
+}
+
+// RenderCompose interpolates variables into a compose file, validates the result and parses it.
+func RenderCompose(data string, variables map[string]string) (parser.ComposeConfig, error) {
+	root, errs := parseDocument(data)
+	if errs != nil {
+		return parser.ComposeConfig{}, &InvalidComposeError{Errors: errs}
+	}
+
+	errs = []models.ValidationError{}
+	interpolateNode(root, variables, &errs)
+	if len(errs) > 0 {
+		return parser.ComposeConfig{}, &InvalidComposeError{Errors: errs}
+	}
+
+	if errs, _ := validateDocument(root); len(errs) > 0 {
+		return parser.ComposeConfig{}, &InvalidComposeError{Errors: errs}
+	}
+
+	var config parser.ComposeConfig
+	if err := root.Decode(&config); err != nil {
+		return parser.ComposeConfig{}, err
+	}
+	return config, nil
+}
+
+// RenderDraft renders a draft with its default variables overridden by those of the environment.
+func RenderDraft(draft models.StackDraft, environment string) (parser.ComposeConfig, error) {
+	variables, err := GetVariables(draft.Name, environment)
+	if err != nil {
+		return parser.ComposeConfig{}, err
+	}
+	return RenderCompose(draft.Data, variables)
+}
@@ -0,0 +1,83 @@
+package docker_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dockrelix/dockrelix-backend/docker"
+)
+
+func TestInterpolate(t *testing.T) {
+	variables := map[string]string{
+		"TAG":   "1.25",
+		"EMPTY": "",
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"nginx:${TAG}", "nginx:1.25"},
+		{"nginx:$TAG", "nginx:1.25"},
+		{"${MISSING}", ""},
+		{"${MISSING:-latest}", "latest"},
+		{"${EMPTY:-latest}", "latest"},
+		{"${EMPTY-latest}", ""},
+		{"${TAG:+set}", "set"},
+		{"${MISSING+set}", ""},
+		{"${MISSING:-${TAG}}", "1.25"},
+		{"$$TAG", "$TAG"},
+		{"cost: 5$", "cost: 5$"},
+	}
+
+	for _, test := range tests {
+		result, err := docker.Interpolate(test.input, variables)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.input, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.input, test.expected, result)
+		}
+	}
+
+	if _, err := docker.Interpolate("${TAG:?tag is required}", map[string]string{}); err == nil {
+		t.Error("expected an error for a missing required variable")
+	}
+	if _, err := docker.Interpolate("${TAG", variables); err == nil {
+		t.Error("expected an error for an unterminated expression")
+	}
+}
+
+func TestRenderCompose(t *testing.T) {
+	data := `services:
+  web:
+    image: "nginx:${TAG:-latest}"
+    environment:
+      - HOME=$$HOME
+    deploy:
+      replicas: ${REPLICAS}
+`
+
+	config, err := docker.RenderCompose(data, map[string]string{"REPLICAS": "3"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	web := config.Services["web"]
+	if web.Image != "nginx:latest" {
+		t.Errorf("expected image nginx:latest, got %s", web.Image)
+	}
+	if web.Deploy.Replicas != 3 {
+		t.Errorf("expected 3 replicas, got %d", web.Deploy.Replicas)
+	}
+	if len(web.Environment) != 1 || web.Environment[0] != "HOME=$HOME" {
+		t.Errorf("expected escaped environment, got %v", web.Environment)
+	}
+
+	_, err = docker.RenderCompose(data, map[string]string{"REPLICAS": "many"})
+	var invalid *docker.InvalidComposeError
+	if !errors.As(err, &invalid) || invalid.Errors[0].Line != 7 {
+		t.Errorf("expected a validation error on line 7, got %v", err)
+	}
+}
@@ -0,0 +1,923 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/dockrelix/dockrelix-backend/models"
+	"github.com/dockrelix/dockrelix-backend/models/parser"
+	"gopkg.in/yaml.v3"
+)
+
+// The manifest types below only hold the fields the converter fills in, in the layout of the Kubernetes API.
+
+type kubeMeta struct {
+	Name        string            `yaml:"name,omitempty"`
+	Namespace   string            `yaml:"namespace,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+type kubeObject struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   kubeMeta          `yaml:"metadata"`
+	Type       string            `yaml:"type,omitempty"`
+	Data       map[string]string `yaml:"data,omitempty"`
+	Spec       interface{}       `yaml:"spec,omitempty"`
+}
+
+type kubeSelector struct {
+	MatchLabels map[string]string `yaml:"matchLabels"`
+}
+
+type kubeRollingUpdate struct {
+	MaxSurge       *int `yaml:"maxSurge,omitempty"`
+	MaxUnavailable *int `yaml:"maxUnavailable,omitempty"`
+}
+
+type kubeStrategy struct {
+	Type          string             `yaml:"type"`
+	RollingUpdate *kubeRollingUpdate `yaml:"rollingUpdate,omitempty"`
+}
+
+type kubeWorkloadSpec struct {
+	Replicas       *int            `yaml:"replicas,omitempty"`
+	Completions    *int            `yaml:"completions,omitempty"`
+	Parallelism    *int            `yaml:"parallelism,omitempty"`
+	BackoffLimit   *int            `yaml:"backoffLimit,omitempty"`
+	Selector       *kubeSelector   `yaml:"selector,omitempty"`
+	Strategy       *kubeStrategy   `yaml:"strategy,omitempty"`
+	UpdateStrategy *kubeStrategy   `yaml:"updateStrategy,omitempty"`
+	Template       kubePodTemplate `yaml:"template"`
+}
+
+type kubePodTemplate struct {
+	Metadata kubeMeta    `yaml:"metadata"`
+	Spec     kubePodSpec `yaml:"spec"`
+}
+
+type kubeNameValue struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+type kubeHostAlias struct {
+	IP        string   `yaml:"ip"`
+	Hostnames []string `yaml:"hostnames"`
+}
+
+type kubeDNSConfig struct {
+	Nameservers []string        `yaml:"nameservers,omitempty"`
+	Searches    []string        `yaml:"searches,omitempty"`
+	Options     []kubeDNSOption `yaml:"options,omitempty"`
+}
+
+type kubeDNSOption struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value,omitempty"`
+}
+
+type kubeNodeSelectorRequirement struct {
+	Key      string   `yaml:"key"`
+	Operator string   `yaml:"operator"`
+	Values   []string `yaml:"values,omitempty"`
+}
+
+type kubeNodeSelectorTerm struct {
+	MatchExpressions []kubeNodeSelectorRequirement `yaml:"matchExpressions"`
+}
+
+type kubeNodeSelector struct {
+	NodeSelectorTerms []kubeNodeSelectorTerm `yaml:"nodeSelectorTerms"`
+}
+
+type kubeNodeAffinity struct {
+	Required kubeNodeSelector `yaml:"requiredDuringSchedulingIgnoredDuringExecution"`
+}
+
+type kubeAffinity struct {
+	NodeAffinity kubeNodeAffinity `yaml:"nodeAffinity"`
+}
+
+type kubeSpreadConstraint struct {
+	MaxSkew           int          `yaml:"maxSkew"`
+	TopologyKey       string       `yaml:"topologyKey"`
+	WhenUnsatisfiable string       `yaml:"whenUnsatisfiable"`
+	LabelSelector     kubeSelector `yaml:"labelSelector"`
+}
+
+type kubePodSecurity struct {
+	Sysctls []kubeNameValue `yaml:"sysctls,omitempty"`
+}
+
+type kubePodSpec struct {
+	Containers                    []kubeContainer        `yaml:"containers"`
+	Volumes                       []kubeVolume           `yaml:"volumes,omitempty"`
+	RestartPolicy                 string                 `yaml:"restartPolicy,omitempty"`
+	Hostname                      string                 `yaml:"hostname,omitempty"`
+	HostAliases                   []kubeHostAlias        `yaml:"hostAliases,omitempty"`
+	DNSPolicy                     string                 `yaml:"dnsPolicy,omitempty"`
+	DNSConfig                     *kubeDNSConfig         `yaml:"dnsConfig,omitempty"`
+	NodeSelector                  map[string]string      `yaml:"nodeSelector,omitempty"`
+	Affinity                      *kubeAffinity          `yaml:"affinity,omitempty"`
+	TopologySpreadConstraints     []kubeSpreadConstraint `yaml:"topologySpreadConstraints,omitempty"`
+	SecurityContext               *kubePodSecurity       `yaml:"securityContext,omitempty"`
+	TerminationGracePeriodSeconds *int                   `yaml:"terminationGracePeriodSeconds,omitempty"`
+}
+
+type kubeContainerPort struct {
+	ContainerPort uint32 `yaml:"containerPort"`
+	HostPort      uint32 `yaml:"hostPort,omitempty"`
+	Protocol      string `yaml:"protocol"`
+}
+
+type kubeResources struct {
+	Limits   map[string]string `yaml:"limits,omitempty"`
+	Requests map[string]string `yaml:"requests,omitempty"`
+}
+
+type kubeVolumeMount struct {
+	Name      string `yaml:"name"`
+	MountPath string `yaml:"mountPath"`
+	SubPath   string `yaml:"subPath,omitempty"`
+	ReadOnly  bool   `yaml:"readOnly,omitempty"`
+}
+
+type kubeExecAction struct {
+	Command []string `yaml:"command"`
+}
+
+type kubeProbe struct {
+	Exec                kubeExecAction `yaml:"exec"`
+	InitialDelaySeconds int            `yaml:"initialDelaySeconds,omitempty"`
+	PeriodSeconds       int            `yaml:"periodSeconds,omitempty"`
+	TimeoutSeconds      int            `yaml:"timeoutSeconds,omitempty"`
+	FailureThreshold    int            `yaml:"failureThreshold,omitempty"`
+}
+
+type kubeCapabilities struct {
+	Add  []string `yaml:"add,omitempty"`
+	Drop []string `yaml:"drop,omitempty"`
+}
+
+type kubeContainerSecurity struct {
+	RunAsUser              *int64            `yaml:"runAsUser,omitempty"`
+	RunAsGroup             *int64            `yaml:"runAsGroup,omitempty"`
+	ReadOnlyRootFilesystem bool              `yaml:"readOnlyRootFilesystem,omitempty"`
+	Capabilities           *kubeCapabilities `yaml:"capabilities,omitempty"`
+}
+
+type kubeContainer struct {
+	Name            string                 `yaml:"name"`
+	Image           string                 `yaml:"image"`
+	Command         []string               `yaml:"command,omitempty"`
+	Args            []string               `yaml:"args,omitempty"`
+	WorkingDir      string                 `yaml:"workingDir,omitempty"`
+	Env             []kubeNameValue        `yaml:"env,omitempty"`
+	Ports           []kubeContainerPort    `yaml:"ports,omitempty"`
+	Resources       *kubeResources         `yaml:"resources,omitempty"`
+	VolumeMounts    []kubeVolumeMount      `yaml:"volumeMounts,omitempty"`
+	LivenessProbe   *kubeProbe             `yaml:"livenessProbe,omitempty"`
+	SecurityContext *kubeContainerSecurity `yaml:"securityContext,omitempty"`
+}
+
+type kubeClaimSource struct {
+	ClaimName string `yaml:"claimName"`
+}
+
+type kubeHostPathSource struct {
+	Path string `yaml:"path"`
+}
+
+type kubeEmptyDirSource struct {
+	Medium    string `yaml:"medium,omitempty"`
+	SizeLimit string `yaml:"sizeLimit,omitempty"`
+}
+
+type kubeConfigMapSource struct {
+	Name string `yaml:"name"`
+}
+
+type kubeSecretSource struct {
+	SecretName string `yaml:"secretName"`
+}
+
+type kubeVolume struct {
+	Name                  string               `yaml:"name"`
+	PersistentVolumeClaim *kubeClaimSource     `yaml:"persistentVolumeClaim,omitempty"`
+	HostPath              *kubeHostPathSource  `yaml:"hostPath,omitempty"`
+	EmptyDir              *kubeEmptyDirSource  `yaml:"emptyDir,omitempty"`
+	ConfigMap             *kubeConfigMapSource `yaml:"configMap,omitempty"`
+	Secret                *kubeSecretSource    `yaml:"secret,omitempty"`
+}
+
+type kubeClaimSpec struct {
+	AccessModes []string      `yaml:"accessModes"`
+	Resources   kubeResources `yaml:"resources"`
+}
+
+type kubeServicePort struct {
+	Name       string `yaml:"name"`
+	Port       uint32 `yaml:"port"`
+	TargetPort uint32 `yaml:"targetPort"`
+	Protocol   string `yaml:"protocol"`
+}
+
+type kubeServiceSpec struct {
+	Type      string            `yaml:"type,omitempty"`
+	ClusterIP string            `yaml:"clusterIP,omitempty"`
+	Selector  map[string]string `yaml:"selector"`
+	Ports     []kubeServicePort `yaml:"ports,omitempty"`
+}
+
+type kubePolicyPeer struct {
+	PodSelector *kubeSelector `yaml:"podSelector,omitempty"`
+}
+
+type kubePolicyPort struct {
+	Protocol string `yaml:"protocol"`
+	Port     uint32 `yaml:"port"`
+}
+
+type kubePolicyRule struct {
+	From  []kubePolicyPeer `yaml:"from,omitempty"`
+	Ports []kubePolicyPort `yaml:"ports,omitempty"`
+}
+
+type kubePolicySpec struct {
+	PodSelector kubeSelector     `yaml:"podSelector"`
+	PolicyTypes []string         `yaml:"policyTypes"`
+	Ingress     []kubePolicyRule `yaml:"ingress"`
+}
+
+// defaultClaimSize is requested for every volume, compose has no notion of volume sizes.
+const defaultClaimSize = "1Gi"
+
+var invalidKubeName = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// kubeName turns a compose name into a valid Kubernetes object name.
+func kubeName(name string) string {
+	name = strings.Trim(invalidKubeName.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(name) > 63 {
+		name = strings.TrimRight(name[:63], "-")
+	}
+	return name
+}
+
+func kubeSeconds(value string) int {
+	duration, err := parseDuration(value)
+	if err != nil || duration == nil {
+		return 0
+	}
+	return int((*duration + 999999999) / 1000000000)
+}
+
+func kubeQuantities(spec *parser.ResourceSpec) map[string]string {
+	if spec == nil {
+		return nil
+	}
+	result := map[string]string{}
+	if cpus, err := parseCPUs(spec.CPUs); err == nil && cpus > 0 {
+		result["cpu"] = strconv.FormatInt(cpus/1000000, 10) + "m"
+	}
+	if memory, err := parseMemory(spec.Memory); err == nil && memory > 0 {
+		if memory%(1024*1024) == 0 {
+			result["memory"] = strconv.FormatInt(memory/(1024*1024), 10) + "Mi"
+		} else {
+			result["memory"] = strconv.FormatInt(memory, 10)
+		}
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+type kubernetesConverter struct {
+	config      parser.ComposeConfig
+	stackName   string
+	files       map[string][]byte
+	unsupported []models.ValidationError
+
+	configMaps []kubeObject
+	secrets    []kubeObject
+	claims     []kubeObject
+	policies   []kubeObject
+	workloads  []kubeObject
+	services   []kubeObject
+}
+
+func (k *kubernetesConverter) report(path, format string, args ...interface{}) {
+	k.unsupported = append(k.unsupported, models.ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (k *kubernetesConverter) meta(name string) kubeMeta {
+	return kubeMeta{
+		Name:      kubeName(name),
+		Namespace: kubeName(k.stackName),
+		Labels:    map[string]string{"app.kubernetes.io/part-of": kubeName(k.stackName)},
+	}
+}
+
+func (k *kubernetesConverter) podLabels(service string) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":    kubeName(service),
+		"app.kubernetes.io/part-of": kubeName(k.stackName),
+	}
+}
+
+func networkLabel(name string) string {
+	return "network.dockrelix.io/" + kubeName(name)
+}
+
+// fileContent returns the contents of a config or secret, from the files stored with the draft or inline.
+func (k *kubernetesConverter) fileContent(file, content string) ([]byte, bool) {
+	if file == "" {
+		return []byte(content), content != ""
+	}
+	data, ok := k.files[path.Clean(file)]
+	return data, ok
+}
+
+func (k *kubernetesConverter) convertConfigs() {
+	for _, name := range slices.Sorted(maps.Keys(k.config.Configs)) {
+		cfg := k.config.Configs[name]
+		at := "configs." + name
+		if cfg.External {
+			k.report(at, "external config has to exist as ConfigMap %q", kubeName(name))
+			continue
+		}
+		if cfg.TemplateDriver != "" {
+			k.report(at+".template_driver", "templated configs have no equivalent, the template is stored as is")
+		}
+
+		data, ok := k.fileContent(cfg.File, cfg.Content)
+		if !ok {
+			k.report(at, "contents are not available, the ConfigMap has to be filled in")
+		}
+		object := kubeObject{APIVersion: "v1", Kind: "ConfigMap", Metadata: k.meta(name), Data: map[string]string{name: string(data)}}
+		k.configMaps = append(k.configMaps, object)
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(k.config.Secrets)) {
+		secret := k.config.Secrets[name]
+		at := "secrets." + name
+		if secret.External {
+			k.report(at, "external secret has to exist as Secret %q", kubeName(name))
+			continue
+		}
+
+		data, ok := k.fileContent(secret.File, "")
+		if !ok {
+			k.report(at, "value is not available, the Secret has to be filled in")
+		}
+		object := kubeObject{
+			APIVersion: "v1",
+			Kind:       "Secret",
+			Metadata:   k.meta(name),
+			Type:       "Opaque",
+			Data:       map[string]string{name: base64.StdEncoding.EncodeToString(data)},
+		}
+		k.secrets = append(k.secrets, object)
+	}
+}
+
+func (k *kubernetesConverter) convertVolumes() {
+	for _, name := range slices.Sorted(maps.Keys(k.config.Volumes)) {
+		vol := k.config.Volumes[name]
+		at := "volumes." + name
+		if vol.External {
+			k.report(at, "external volume has to exist as PersistentVolumeClaim %q", kubeName(name))
+			continue
+		}
+		if vol.Driver != "" || len(vol.DriverOpts) > 0 {
+			k.report(at+".driver", "volume drivers have no equivalent, pick a storage class instead")
+		}
+
+		object := kubeObject{
+			APIVersion: "v1",
+			Kind:       "PersistentVolumeClaim",
+			Metadata:   k.meta(name),
+			Spec: kubeClaimSpec{
+				AccessModes: []string{"ReadWriteOnce"},
+				Resources:   kubeResources{Requests: map[string]string{"storage": defaultClaimSize}},
+			},
+		}
+		k.claims = append(k.claims, object)
+	}
+}
+
+// convertNetworks isolates the pods of each network with a NetworkPolicy that only lets in pods of the same network.
+func (k *kubernetesConverter) convertNetworks() {
+	networks := map[string]bool{}
+	for _, srv := range k.config.Services {
+		if len(srv.Networks) == 0 {
+			networks["default"] = true
+		}
+		for _, nw := range srv.Networks.Names() {
+			networks[nw] = true
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(networks)) {
+		net := k.config.Networks[name]
+		at := "networks." + name
+		if net.External {
+			k.report(at, "external networks have no equivalent, pods of other stacks are not let in")
+		}
+		if net.Driver != "" && net.Driver != "overlay" || len(net.DriverOpts) > 0 || net.IPAM != nil {
+			k.report(at, "network drivers and IPAM settings have no equivalent")
+		}
+
+		selector := kubeSelector{MatchLabels: map[string]string{networkLabel(name): "true"}}
+		k.policies = append(k.policies, kubeObject{
+			APIVersion: "networking.k8s.io/v1",
+			Kind:       "NetworkPolicy",
+			Metadata:   k.meta(name + "-network"),
+			Spec: kubePolicySpec{
+				PodSelector: selector,
+				PolicyTypes: []string{"Ingress"},
+				Ingress:     []kubePolicyRule{{From: []kubePolicyPeer{{PodSelector: &selector}}}},
+			},
+		})
+	}
+}
+
+// convertPlacement maps swarm constraints on node labels, hostname, role and platform to node selectors and
+// affinities, and spread preferences to topology spread constraints.
+func (k *kubernetesConverter) convertPlacement(name string, placement *parser.Placement, pod *kubePodSpec) {
+	if placement == nil {
+		return
+	}
+	at := "services." + name + ".deploy.placement"
+
+	for i, constraint := range placement.Constraints {
+		operator, separator := "In", "=="
+		if strings.Contains(constraint, "!=") {
+			operator, separator = "NotIn", "!="
+		}
+		field, value, ok := strings.Cut(constraint, separator)
+		field, value = strings.TrimSpace(field), strings.TrimSpace(value)
+
+		var key string
+		switch {
+		case !ok:
+		case strings.HasPrefix(field, "node.labels."):
+			key = strings.TrimPrefix(field, "node.labels.")
+		case field == "node.hostname":
+			key = "kubernetes.io/hostname"
+		case field == "node.platform.os":
+			key = "kubernetes.io/os"
+		case field == "node.platform.arch":
+			key = "kubernetes.io/arch"
+		case field == "node.role" && value == "manager":
+			key, value = "node-role.kubernetes.io/control-plane", ""
+			operator = map[string]string{"In": "Exists", "NotIn": "DoesNotExist"}[operator]
+		}
+		if key == "" {
+			k.report(at+".constraints["+strconv.Itoa(i)+"]", "constraint %q has no equivalent", constraint)
+			continue
+		}
+
+		if operator == "In" {
+			if pod.NodeSelector == nil {
+				pod.NodeSelector = map[string]string{}
+			}
+			pod.NodeSelector[key] = value
+			continue
+		}
+
+		if pod.Affinity == nil {
+			pod.Affinity = &kubeAffinity{}
+			pod.Affinity.NodeAffinity.Required.NodeSelectorTerms = []kubeNodeSelectorTerm{{}}
+		}
+		requirement := kubeNodeSelectorRequirement{Key: key, Operator: operator}
+		if value != "" {
+			requirement.Values = []string{value}
+		}
+		term := &pod.Affinity.NodeAffinity.Required.NodeSelectorTerms[0]
+		term.MatchExpressions = append(term.MatchExpressions, requirement)
+	}
+
+	for i, preference := range placement.Preferences {
+		if !strings.HasPrefix(preference.Spread, "node.labels.") {
+			k.report(at+".preferences["+strconv.Itoa(i)+"]", "spreading over %q has no equivalent", preference.Spread)
+			continue
+		}
+		pod.TopologySpreadConstraints = append(pod.TopologySpreadConstraints, kubeSpreadConstraint{
+			MaxSkew:           1,
+			TopologyKey:       strings.TrimPrefix(preference.Spread, "node.labels."),
+			WhenUnsatisfiable: "ScheduleAnyway",
+			LabelSelector:     kubeSelector{MatchLabels: k.podLabels(name)},
+		})
+	}
+
+	if placement.MaxReplicasPerNode > 0 {
+		k.report(at+".max_replicas_per_node", "max replicas per node has no equivalent")
+	}
+}
+
+func (k *kubernetesConverter) convertHealthcheck(name string, hc *parser.Healthcheck) *kubeProbe {
+	if hc == nil || len(hc.Test) == 0 || hc.Test[0] == "NONE" {
+		return nil
+	}
+
+	probe := &kubeProbe{
+		InitialDelaySeconds: kubeSeconds(hc.StartPeriod),
+		PeriodSeconds:       kubeSeconds(hc.Interval),
+		TimeoutSeconds:      kubeSeconds(hc.Timeout),
+		FailureThreshold:    hc.Retries,
+	}
+	switch hc.Test[0] {
+	case "CMD":
+		probe.Exec.Command = hc.Test[1:]
+	case "CMD-SHELL":
+		probe.Exec.Command = []string{"/bin/sh", "-c", strings.Join(hc.Test[1:], " ")}
+	default:
+		probe.Exec.Command = []string{"/bin/sh", "-c", strings.Join(hc.Test, " ")}
+	}
+	return probe
+}
+
+// convertUpdateConfig maps the update parallelism and order to a rolling update, start-first surging and
+// stop-first taking replicas down first.
+func (k *kubernetesConverter) convertUpdateConfig(name string, deploy parser.DeployConfig) *kubeStrategy {
+	at := "services." + name + ".deploy"
+	if deploy.RollbackConfig != nil {
+		k.report(at+".rollback_config", "rollback settings have no equivalent, use kubectl rollout undo")
+	}
+
+	config := deploy.UpdateConfig
+	if config == nil {
+		return nil
+	}
+	if config.Delay != "" || config.FailureAction != "" || config.Monitor != "" || config.MaxFailureRatio != 0 {
+		k.report(at+".update_config", "only parallelism and order have an equivalent")
+	}
+
+	parallelism, none := max(config.Parallelism, 1), 0
+	update := &kubeRollingUpdate{MaxSurge: &none, MaxUnavailable: &parallelism}
+	if config.Order == "start-first" {
+		update = &kubeRollingUpdate{MaxSurge: &parallelism, MaxUnavailable: &none}
+	}
+	return &kubeStrategy{Type: "RollingUpdate", RollingUpdate: update}
+}
+
+func (k *kubernetesConverter) convertContainer(name string, srv parser.Service, pod *kubePodSpec) kubeContainer {
+	at := func(key string) string { return "services." + name + "." + key }
+
+	container := kubeContainer{
+		Name:          kubeName(name),
+		Image:         srv.Image,
+		Command:       srv.Entrypoint,
+		Args:          srv.Command,
+		WorkingDir:    srv.WorkingDir,
+		LivenessProbe: k.convertHealthcheck(name, srv.Healthcheck),
+	}
+
+	for _, entry := range srv.Environment {
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			k.report(at("environment"), "%s takes its value from the host, which has no equivalent", key)
+			continue
+		}
+		container.Env = append(container.Env, kubeNameValue{Name: key, Value: value})
+	}
+
+	if resources := srv.Deploy.Resources; resources != nil {
+		limits, requests := kubeQuantities(resources.Limits), kubeQuantities(resources.Reservations)
+		if limits != nil || requests != nil {
+			container.Resources = &kubeResources{Limits: limits, Requests: requests}
+		}
+	}
+
+	security := &kubeContainerSecurity{ReadOnlyRootFilesystem: srv.ReadOnly}
+	if len(srv.CapAdd) > 0 || len(srv.CapDrop) > 0 {
+		security.Capabilities = &kubeCapabilities{Add: srv.CapAdd, Drop: srv.CapDrop}
+	}
+	if srv.User != "" {
+		user, group, hasGroup := strings.Cut(srv.User, ":")
+		uid, err := strconv.ParseInt(user, 10, 64)
+		gid, groupErr := strconv.ParseInt(group, 10, 64)
+		if err != nil || hasGroup && groupErr != nil {
+			k.report(at("user"), "user %q has to be numeric", srv.User)
+		} else {
+			security.RunAsUser = &uid
+			if hasGroup {
+				security.RunAsGroup = &gid
+			}
+		}
+	}
+	if *security != (kubeContainerSecurity{}) {
+		container.SecurityContext = security
+	}
+
+	seen := map[string]bool{}
+	for i, port := range srv.Ports {
+		configs, err := ConvertPort(port)
+		if err != nil {
+			k.report(at("ports["+strconv.Itoa(i)+"]"), "%s", err.Error())
+			continue
+		}
+		for _, cfg := range configs {
+			result := kubeContainerPort{ContainerPort: cfg.TargetPort, Protocol: strings.ToUpper(string(cfg.Protocol))}
+			if cfg.PublishMode == swarm.PortConfigPublishModeHost {
+				result.HostPort = cfg.PublishedPort
+			}
+			key := fmt.Sprintf("%d/%s/%d", result.ContainerPort, result.Protocol, result.HostPort)
+			if !seen[key] {
+				seen[key] = true
+				container.Ports = append(container.Ports, result)
+			}
+		}
+	}
+
+	for i, vol := range srv.Volumes {
+		m, err := ConvertVolume(vol, k.stackName, k.config.Volumes)
+		if err != nil {
+			k.report(at("volumes["+strconv.Itoa(i)+"]"), "%s", err.Error())
+			continue
+		}
+
+		volume := kubeVolume{Name: kubeName(fmt.Sprintf("volume-%d", i))}
+		switch m.Type {
+		case mount.TypeVolume:
+			if m.Source == "" {
+				volume.EmptyDir = &kubeEmptyDirSource{}
+				break
+			}
+			volume.PersistentVolumeClaim = &kubeClaimSource{ClaimName: kubeName(RemoveStackFromName(m.Source, k.stackName))}
+		case mount.TypeBind:
+			k.report(at("volumes["+strconv.Itoa(i)+"]"), "bind mount of %s becomes a hostPath volume, which ties the pod to the files of its node", m.Source)
+			volume.HostPath = &kubeHostPathSource{Path: m.Source}
+		case mount.TypeTmpfs:
+			volume.EmptyDir = &kubeEmptyDirSource{Medium: "Memory"}
+			if m.TmpfsOptions != nil && m.TmpfsOptions.SizeBytes > 0 {
+				volume.EmptyDir.SizeLimit = strconv.FormatInt(m.TmpfsOptions.SizeBytes, 10)
+			}
+		default:
+			k.report(at("volumes["+strconv.Itoa(i)+"]"), "%s mounts have no equivalent", m.Type)
+			continue
+		}
+		pod.Volumes = append(pod.Volumes, volume)
+		container.VolumeMounts = append(container.VolumeMounts, kubeVolumeMount{Name: volume.Name, MountPath: m.Target, ReadOnly: m.ReadOnly})
+	}
+
+	for i, tmpfs := range srv.Tmpfs {
+		m, err := ConvertTmpfs(tmpfs)
+		if err != nil {
+			k.report(at("tmpfs["+strconv.Itoa(i)+"]"), "%v", err)
+			continue
+		}
+		volume := kubeVolume{Name: fmt.Sprintf("tmpfs-%d", i), EmptyDir: &kubeEmptyDirSource{Medium: "Memory"}}
+		if m.TmpfsOptions != nil && m.TmpfsOptions.SizeBytes > 0 {
+			volume.EmptyDir.SizeLimit = strconv.FormatInt(m.TmpfsOptions.SizeBytes, 10)
+		}
+		pod.Volumes = append(pod.Volumes, volume)
+		container.VolumeMounts = append(container.VolumeMounts, kubeVolumeMount{Name: volume.Name, MountPath: m.Target})
+	}
+
+	// A config or secret mounted more than once is a single volume of the pod, each mount picks its file by subPath.
+	shared := map[string]bool{}
+	addShared := func(volume kubeVolume) {
+		if !shared[volume.Name] {
+			shared[volume.Name] = true
+			pod.Volumes = append(pod.Volumes, volume)
+		}
+	}
+
+	for _, ref := range srv.Configs {
+		target := ref.Target
+		if target == "" {
+			target = "/" + ref.Source
+		}
+		volume := kubeVolume{Name: kubeName("config-" + ref.Source), ConfigMap: &kubeConfigMapSource{Name: kubeName(ref.Source)}}
+		addShared(volume)
+		container.VolumeMounts = append(container.VolumeMounts, kubeVolumeMount{Name: volume.Name, MountPath: target, SubPath: ref.Source, ReadOnly: true})
+	}
+
+	for _, ref := range srv.Secrets {
+		target := ref.Target
+		if target == "" {
+			target = ref.Source
+		}
+		if !path.IsAbs(target) {
+			target = "/run/secrets/" + target
+		}
+		volume := kubeVolume{Name: kubeName("secret-" + ref.Source), Secret: &kubeSecretSource{SecretName: kubeName(ref.Source)}}
+		addShared(volume)
+		container.VolumeMounts = append(container.VolumeMounts, kubeVolumeMount{Name: volume.Name, MountPath: target, SubPath: ref.Source, ReadOnly: true})
+	}
+
+	for _, field := range []struct {
+		key string
+		set bool
+	}{
+		{"init", srv.Init != nil},
+		{"isolation", srv.Isolation != ""},
+		{"logging", srv.Logging != nil},
+		{"stop_signal", srv.StopSignal != ""},
+		{"ulimits", len(srv.Ulimits) > 0},
+	} {
+		if field.set {
+			k.report(at(field.key), "%s has no equivalent", field.key)
+		}
+	}
+
+	return container
+}
+
+func (k *kubernetesConverter) convertService(name string, srv parser.Service) {
+	at := "services." + name
+	pod := kubePodSpec{Hostname: srv.Hostname}
+	container := k.convertContainer(name, srv, &pod)
+	pod.Containers = []kubeContainer{container}
+
+	for _, host := range convertExtraHosts(srv.ExtraHosts) {
+		ip, hostname, _ := strings.Cut(host, " ")
+		pod.HostAliases = append(pod.HostAliases, kubeHostAlias{IP: ip, Hostnames: []string{hostname}})
+	}
+
+	if len(srv.DNS) > 0 || len(srv.DNSSearch) > 0 || len(srv.DNSOpt) > 0 {
+		pod.DNSPolicy = "None"
+		pod.DNSConfig = &kubeDNSConfig{Nameservers: srv.DNS, Searches: srv.DNSSearch}
+		for _, option := range srv.DNSOpt {
+			key, value, _ := strings.Cut(option, ":")
+			pod.DNSConfig.Options = append(pod.DNSConfig.Options, kubeDNSOption{Name: key, Value: value})
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(srv.Sysctls)) {
+		if pod.SecurityContext == nil {
+			pod.SecurityContext = &kubePodSecurity{}
+		}
+		pod.SecurityContext.Sysctls = append(pod.SecurityContext.Sysctls, kubeNameValue{Name: key, Value: srv.Sysctls[key]})
+	}
+
+	if seconds := kubeSeconds(srv.StopGracePeriod); seconds > 0 {
+		pod.TerminationGracePeriodSeconds = &seconds
+	}
+
+	k.convertPlacement(name, srv.Deploy.Placement, &pod)
+
+	labels := k.podLabels(name)
+	networks := srv.Networks.Names()
+	if len(networks) == 0 {
+		networks = []string{"default"}
+	}
+	for _, nw := range networks {
+		labels[networkLabel(nw)] = "true"
+	}
+
+	meta := k.meta(name)
+	if len(srv.Deploy.Labels) > 0 {
+		meta.Annotations = srv.Deploy.Labels
+	}
+	spec := kubeWorkloadSpec{
+		Selector: &kubeSelector{MatchLabels: k.podLabels(name)},
+		Template: kubePodTemplate{
+			Metadata: kubeMeta{Labels: labels, Annotations: srv.Labels},
+			Spec:     pod,
+		},
+	}
+
+	replicas := max(srv.Deploy.Replicas, 1)
+	policy := srv.Deploy.RestartPolicy
+	object := kubeObject{Metadata: meta, Spec: &spec}
+	switch srv.Deploy.Mode {
+	case "", "replicated":
+		object.APIVersion, object.Kind = "apps/v1", "Deployment"
+		spec.Replicas = &replicas
+		spec.Strategy = k.convertUpdateConfig(name, srv.Deploy)
+	case "global":
+		object.APIVersion, object.Kind = "apps/v1", "DaemonSet"
+		if strategy := k.convertUpdateConfig(name, srv.Deploy); strategy != nil {
+			strategy.RollingUpdate.MaxSurge = nil
+			spec.UpdateStrategy = strategy
+		}
+	case "replicated-job":
+		object.APIVersion, object.Kind = "batch/v1", "Job"
+		spec.Completions, spec.Parallelism, spec.Selector = &replicas, &replicas, nil
+		spec.Template.Spec.RestartPolicy = "OnFailure"
+		if policy != nil && policy.Condition == "none" {
+			spec.Template.Spec.RestartPolicy = "Never"
+		}
+		if policy != nil && policy.MaxAttempts > 0 {
+			spec.BackoffLimit = &policy.MaxAttempts
+		}
+		policy = nil
+	default:
+		k.report(at+".deploy.mode", "%s services have no equivalent", srv.Deploy.Mode)
+		return
+	}
+	if policy != nil && (policy.Condition == "none" || policy.Condition == "on-failure" || policy.MaxAttempts > 0 || policy.Delay != "" || policy.Window != "") {
+		k.report(at+".deploy.restart_policy", "%s containers are always restarted", object.Kind)
+	}
+	k.workloads = append(k.workloads, object)
+
+	k.convertEndpoint(name, srv, container.Ports)
+}
+
+// convertEndpoint adds a Service so the pods can be reached by the service name as on swarm, published ports make
+// it a load balancer and let traffic in past the network policies.
+func (k *kubernetesConverter) convertEndpoint(name string, srv parser.Service, containerPorts []kubeContainerPort) {
+	spec := kubeServiceSpec{Selector: k.podLabels(name)}
+	var published []kubePolicyPort
+
+	for _, port := range srv.Ports {
+		configs, err := ConvertPort(port)
+		if err != nil {
+			continue
+		}
+		for _, cfg := range configs {
+			protocol := strings.ToUpper(string(cfg.Protocol))
+			result := kubeServicePort{
+				Name:       fmt.Sprintf("%s-%d", strings.ToLower(protocol), cfg.TargetPort),
+				Port:       cfg.TargetPort,
+				TargetPort: cfg.TargetPort,
+				Protocol:   protocol,
+			}
+			if cfg.PublishedPort != 0 && cfg.PublishMode != swarm.PortConfigPublishModeHost {
+				spec.Type = "LoadBalancer"
+				result.Port = cfg.PublishedPort
+				result.Name = fmt.Sprintf("%s-%d", strings.ToLower(protocol), cfg.PublishedPort)
+				published = append(published, kubePolicyPort{Protocol: protocol, Port: cfg.TargetPort})
+			}
+			if !slices.ContainsFunc(spec.Ports, func(existing kubeServicePort) bool { return existing.Name == result.Name }) {
+				spec.Ports = append(spec.Ports, result)
+			}
+		}
+	}
+
+	if len(spec.Ports) == 0 || srv.Deploy.EndpointMode == "dnsrr" {
+		if spec.Type == "LoadBalancer" {
+			k.report("services."+name+".deploy.endpoint_mode", "dnsrr cannot be combined with published ports")
+		} else {
+			spec.ClusterIP = "None"
+		}
+	}
+
+	k.services = append(k.services, kubeObject{APIVersion: "v1", Kind: "Service", Metadata: k.meta(name), Spec: spec})
+
+	if len(published) > 0 {
+		k.policies = append(k.policies, kubeObject{
+			APIVersion: "networking.k8s.io/v1",
+			Kind:       "NetworkPolicy",
+			Metadata:   k.meta(name + "-published"),
+			Spec: kubePolicySpec{
+				PodSelector: kubeSelector{MatchLabels: k.podLabels(name)},
+				PolicyTypes: []string{"Ingress"},
+				Ingress:     []kubePolicyRule{{Ports: published}},
+			},
+		})
+	}
+}
+
+// ConvertKubernetes turns a compose file into Kubernetes manifests in a namespace named after the stack: services
+// become Deployments, DaemonSets or Jobs with a Service each, volumes PersistentVolumeClaims, configs ConfigMaps,
+// secrets Secrets and networks NetworkPolicies. Files holds the contents of the files configs and secrets refer to.
+// Settings without an equivalent are left out and listed in the export.
+func ConvertKubernetes(config parser.ComposeConfig, stackName string, files map[string][]byte) (models.KubernetesExport, error) {
+	k := &kubernetesConverter{config: config, stackName: stackName, files: files}
+	k.convertConfigs()
+	k.convertVolumes()
+	k.convertNetworks()
+	for _, name := range slices.Sorted(maps.Keys(config.Services)) {
+		k.convertService(name, config.Services[name])
+	}
+
+	namespace := kubeObject{APIVersion: "v1", Kind: "Namespace", Metadata: k.meta(stackName)}
+	namespace.Metadata.Namespace = ""
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	objects := append([]kubeObject{namespace}, k.configMaps...)
+	for _, group := range [][]kubeObject{k.secrets, k.claims, k.policies, k.workloads, k.services} {
+		objects = append(objects, group...)
+	}
+	for _, object := range objects {
+		if err := encoder.Encode(object); err != nil {
+			return models.KubernetesExport{}, err
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return models.KubernetesExport{}, err
+	}
+
+	unsupported := k.unsupported
+	if unsupported == nil {
+		unsupported = []models.ValidationError{}
+	}
+	return models.KubernetesExport{Manifests: out.String(), Unsupported: unsupported}, nil
+}
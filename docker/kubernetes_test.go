@@ -0,0 +1,196 @@
+package docker_test
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dockrelix/dockrelix-backend/docker"
+	"gopkg.in/yaml.v3"
+)
+
+// decodeManifests reads the objects of a multi-document manifest by kind and name.
+func decodeManifests(t *testing.T, manifests string) map[string]map[string]interface{} {
+	objects := map[string]map[string]interface{}{}
+	decoder := yaml.NewDecoder(strings.NewReader(manifests))
+	for {
+		var object map[string]interface{}
+		err := decoder.Decode(&object)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("invalid manifests: %v", err)
+		}
+		name := object["metadata"].(map[string]interface{})["name"].(string)
+		objects[object["kind"].(string)+"/"+name] = object
+	}
+	return objects
+}
+
+func TestConvertKubernetes(t *testing.T) {
+	data := `services:
+  web:
+    image: nginx:1.25
+    ports:
+      - "80:8080"
+    networks: [frontend]
+    volumes:
+      - static:/usr/share/nginx/html:ro
+    configs:
+      - source: site
+        target: /etc/nginx/conf.d/site.conf
+    healthcheck:
+      test: ["CMD-SHELL", "curl -f localhost:8080"]
+      interval: 30s
+      retries: 3
+    ulimits:
+      nofile: 1024
+    tmpfs: /run:size=64m
+    deploy:
+      replicas: 3
+      placement:
+        constraints:
+          - node.labels.zone == eu
+          - node.role != manager
+      resources:
+        limits:
+          cpus: "0.5"
+          memory: 512M
+      update_config:
+        parallelism: 2
+        order: start-first
+  agent:
+    image: agent:latest
+    deploy:
+      mode: global
+networks:
+  frontend: {}
+volumes:
+  static: {}
+configs:
+  site:
+    file: ./site.conf
+`
+
+	config, err := docker.RenderCompose(data, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result, err := docker.ConvertKubernetes(config, "test_stack", map[string][]byte{"site.conf": []byte("server {}")})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	objects := decodeManifests(t, result.Manifests)
+
+	for _, key := range []string{
+		"Namespace/test-stack",
+		"ConfigMap/site",
+		"PersistentVolumeClaim/static",
+		"NetworkPolicy/frontend-network",
+		"NetworkPolicy/web-published",
+		"Deployment/web",
+		"DaemonSet/agent",
+		"Service/web",
+		"Service/agent",
+	} {
+		if _, ok := objects[key]; !ok {
+			t.Errorf("expected %s in the manifests, got:\n%s", key, result.Manifests)
+		}
+	}
+
+	web := objects["Deployment/web"]["spec"].(map[string]interface{})
+	if web["replicas"] != 3 {
+		t.Errorf("expected 3 replicas, got %v", web["replicas"])
+	}
+	pod := web["template"].(map[string]interface{})["spec"].(map[string]interface{})
+	if selector := pod["nodeSelector"].(map[string]interface{}); selector["zone"] != "eu" {
+		t.Errorf("expected zone node selector, got %v", selector)
+	}
+	container := pod["containers"].([]interface{})[0].(map[string]interface{})
+	if limits := container["resources"].(map[string]interface{})["limits"].(map[string]interface{}); limits["cpu"] != "500m" || limits["memory"] != "512Mi" {
+		t.Errorf("expected resource limits, got %v", limits)
+	}
+	for _, volume := range pod["volumes"].([]interface{}) {
+		volume := volume.(map[string]interface{})
+		if volume["name"] != "tmpfs-0" {
+			continue
+		}
+		if emptyDir := volume["emptyDir"].(map[string]interface{}); emptyDir["medium"] != "Memory" || emptyDir["sizeLimit"] != "67108864" {
+			t.Errorf("expected a 64m memory volume, got %v", emptyDir)
+		}
+	}
+
+	service := objects["Service/web"]["spec"].(map[string]interface{})
+	port := service["ports"].([]interface{})[0].(map[string]interface{})
+	if service["type"] != "LoadBalancer" || port["port"] != 80 || port["targetPort"] != 8080 {
+		t.Errorf("expected published port on a load balancer, got %v", service)
+	}
+
+	if len(result.Unsupported) != 1 || result.Unsupported[0].Path != "services.web.ulimits" {
+		t.Errorf("expected only ulimits to be reported, got %+v", result.Unsupported)
+	}
+}
+
+func TestConvertKubernetesSharedVolumes(t *testing.T) {
+	data := `services:
+  web:
+    image: nginx:1.25
+    configs:
+      - source: site
+        target: /etc/nginx/conf.d/site.conf
+      - source: site
+        target: /etc/nginx/sites-enabled/site.conf
+    secrets:
+      - token
+      - source: token
+        target: /etc/app/token
+configs:
+  site:
+    file: ./site.conf
+secrets:
+  token:
+    file: ./token
+`
+
+	config, err := docker.RenderCompose(data, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result, err := docker.ConvertKubernetes(config, "test_stack", map[string][]byte{"site.conf": []byte("server {}"), "token": []byte("secret")})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	objects := decodeManifests(t, result.Manifests)
+	pod := objects["Deployment/web"]["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})
+
+	volumes := map[string]int{}
+	for _, volume := range pod["volumes"].([]interface{}) {
+		volumes[volume.(map[string]interface{})["name"].(string)]++
+	}
+	if len(volumes) != 2 || volumes["config-site"] != 1 || volumes["secret-token"] != 1 {
+		t.Errorf("expected a single volume per config and secret, got %v", volumes)
+	}
+
+	mounts := map[string]string{}
+	container := pod["containers"].([]interface{})[0].(map[string]interface{})
+	for _, m := range container["volumeMounts"].([]interface{}) {
+		m := m.(map[string]interface{})
+		mounts[m["mountPath"].(string)] = m["name"].(string) + "/" + m["subPath"].(string)
+	}
+	expected := map[string]string{
+		"/etc/nginx/conf.d/site.conf":        "config-site/site",
+		"/etc/nginx/sites-enabled/site.conf": "config-site/site",
+		"/run/secrets/token":                 "secret-token/token",
+		"/etc/app/token":                     "secret-token/token",
+	}
+	if !reflect.DeepEqual(mounts, expected) {
+		t.Errorf("expected each mount to use the shared volume, got %v", mounts)
+	}
+}
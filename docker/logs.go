@@ -0,0 +1,261 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/swarm"
+	timetypes "github.com/docker/docker/api/types/time"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/dockrelix/dockrelix-backend/models"
+)
+
+var (
+	ErrTaskNotFound      = errors.New("task not found")
+	ErrInvalidLogOptions = errors.New("invalid log options")
+)
+
+// LogClient adds the calls needed to read the logs of a service and tell which task and node each line came from
+type LogClient interface {
+	serviceInspector
+	ServiceLogs(ctx context.Context, serviceID string, options container.LogsOptions) (io.ReadCloser, error)
+	TaskLogs(ctx context.Context, taskID string, options container.LogsOptions) (io.ReadCloser, error)
+	TaskInspectWithRaw(ctx context.Context, taskID string) (swarm.Task, []byte, error)
+	NodeList(ctx context.Context, options types.NodeListOptions) ([]swarm.Node, error)
+}
+
+// LogOptions selects the logs of a service, Since and Until take a timestamp or a duration such as 10m,
+// Tail a number of lines or all and Task limits the logs to one task of the service.
+type LogOptions struct {
+	Follow     bool
+	Since      string
+	Until      string
+	Tail       string
+	Timestamps bool
+	Stdout     bool
+	Stderr     bool
+	Task       string
+}
+
+// LogStream is an open log stream of a service, it has to be closed once read.
+type LogStream struct {
+	cli        LogClient
+	reader     io.ReadCloser
+	tty        bool
+	service    string
+	timestamps bool
+	tasks      map[string]string
+	nodes      map[string]string
+}
+
+// OpenServiceLogs looks the service up and opens its log stream, so lookup errors surface before anything is
+// streamed. Each line is requested along with its timestamp and details, which carry the task and node it came from.
+func OpenServiceLogs(ctx context.Context, cli LogClient, stackName, service string, options LogOptions) (*LogStream, error) {
+	if !options.Stdout && !options.Stderr {
+		return nil, fmt.Errorf("%w: at least one of stdout and stderr has to be selected", ErrInvalidLogOptions)
+	}
+	if options.Tail == "" {
+		options.Tail = "all"
+	}
+	if tail, err := strconv.Atoi(options.Tail); options.Tail != "all" && (err != nil || tail < 0) {
+		return nil, fmt.Errorf("%w: tail has to be a number of lines or all", ErrInvalidLogOptions)
+	}
+
+	for _, value := range []string{options.Since, options.Until} {
+		if _, err := timetypes.GetTimestamp(value, time.Now()); value != "" && err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidLogOptions, err)
+		}
+	}
+
+	srv, err := getStackService(cli, stackName, service)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := &LogStream{
+		cli:        cli,
+		tty:        srv.Spec.TaskTemplate.ContainerSpec != nil && srv.Spec.TaskTemplate.ContainerSpec.TTY,
+		service:    service,
+		timestamps: options.Timestamps,
+		tasks:      map[string]string{},
+		nodes:      map[string]string{},
+	}
+
+	nodes, err := cli.NodeList(ctx, types.NodeListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		stream.nodes[node.ID] = node.Description.Hostname
+	}
+
+	logsOptions := container.LogsOptions{
+		ShowStdout: options.Stdout,
+		ShowStderr: options.Stderr,
+		Since:      options.Since,
+		Until:      options.Until,
+		Timestamps: true,
+		Follow:     options.Follow,
+		Tail:       options.Tail,
+		Details:    true,
+	}
+
+	if options.Task != "" {
+		task, _, err := cli.TaskInspectWithRaw(ctx, options.Task)
+		if errdefs.IsNotFound(err) || err == nil && task.ServiceID != srv.ID {
+			return nil, fmt.Errorf("%w: %s is not a task of %s", ErrTaskNotFound, options.Task, service)
+		}
+		if err != nil {
+			return nil, err
+		}
+		stream.tasks[task.ID] = taskName(service, task)
+		stream.reader, err = cli.TaskLogs(ctx, task.ID, logsOptions)
+	} else {
+		stream.reader, err = cli.ServiceLogs(ctx, srv.ID, logsOptions)
+	}
+	if err != nil {
+		if errdefs.IsInvalidParameter(err) {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidLogOptions, err)
+		}
+		return nil, err
+	}
+	return stream, nil
+}
+
+// taskName names a task the way the docker CLI does, by slot for replicated services and by node for global ones.
+func taskName(service string, task swarm.Task) string {
+	if task.Slot != 0 {
+		return fmt.Sprintf("%s.%d", service, task.Slot)
+	}
+	return service + "." + task.NodeID
+}
+
+// line turns a raw log line, prefixed with its timestamp and details, into a log line tagged with its task and node.
+func (s *LogStream) line(stream, raw string) models.LogLine {
+	line := models.LogLine{Stream: stream, Message: raw}
+
+	timestamp, rest, ok := strings.Cut(raw, " ")
+	parsed, err := time.Parse(time.RFC3339Nano, timestamp)
+	if !ok || err != nil {
+		return line
+	}
+	if s.timestamps {
+		line.Timestamp = &parsed
+	}
+	line.Message = rest
+
+	details, message, ok := strings.Cut(rest, " ")
+	if !ok || !strings.Contains(details, "=") {
+		return line
+	}
+	line.Message = message
+
+	for _, pair := range strings.Split(details, ",") {
+		key, value, _ := strings.Cut(pair, "=")
+		value, _ = url.QueryUnescape(value)
+		switch key {
+		case "com.docker.swarm.task.id":
+			line.TaskID = value
+		case "com.docker.swarm.node.id":
+			line.NodeID = value
+		}
+	}
+	line.Node = s.nodes[line.NodeID]
+
+	if line.TaskID != "" {
+		name, ok := s.tasks[line.TaskID]
+		if !ok {
+			name = s.service + "." + line.TaskID
+			if task, _, err := s.cli.TaskInspectWithRaw(context.Background(), line.TaskID); err == nil {
+				name = taskName(s.service, task)
+			}
+			s.tasks[line.TaskID] = name
+		}
+		line.Task = name
+	}
+	return line
+}
+
+// lineWriter splits what is written to it into lines and hands each of them on.
+type lineWriter struct {
+	buffer []byte
+	emit   func(raw string) error
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buffer = append(w.buffer, p...)
+	for {
+		i := bytes.IndexByte(w.buffer, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		raw := strings.TrimSuffix(string(w.buffer[:i]), "\r")
+		w.buffer = w.buffer[i+1:]
+		if err := w.emit(raw); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (w *lineWriter) flush() error {
+	if len(w.buffer) == 0 {
+		return nil
+	}
+	raw := string(w.buffer)
+	w.buffer = nil
+	return w.emit(raw)
+}
+
+// Each demultiplexes the stdout and stderr frames of the stream and hands each line to emit, until the stream ends
+// or emit returns an error.
+func (s *LogStream) Each(emit func(models.LogLine) error) error {
+	writer := func(stream string) *lineWriter {
+		return &lineWriter{emit: func(raw string) error { return emit(s.line(stream, raw)) }}
+	}
+	stdout, stderr := writer("stdout"), writer("stderr")
+
+	var err error
+	if s.tty {
+		_, err = io.Copy(stdout, s.reader)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, s.reader)
+	}
+	if err != nil {
+		return err
+	}
+	if err := stdout.flush(); err != nil {
+		return err
+	}
+	return stderr.flush()
+}
+
+func (s *LogStream) Close() error {
+	return s.reader.Close()
+}
+
+// FormatLogLine formats a log line as plain text the way `docker service logs` does.
+func FormatLogLine(line models.LogLine) string {
+	var b strings.Builder
+	if line.Timestamp != nil {
+		b.WriteString(line.Timestamp.Format(time.RFC3339Nano) + " ")
+	}
+	if line.Task != "" {
+		b.WriteString(line.Task)
+		if line.Node != "" {
+			b.WriteString("@" + line.Node)
+		}
+		b.WriteString("    | ")
+	}
+	b.WriteString(line.Message)
+	return b.String()
+}
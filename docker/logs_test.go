@@ -0,0 +1,116 @@
+package docker_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/dockrelix/dockrelix-backend/docker"
+	"github.com/dockrelix/dockrelix-backend/models"
+)
+
+type MockLogClient struct {
+	MockServiceClient
+	logs    []byte
+	options container.LogsOptions
+}
+
+func (m *MockLogClient) ServiceLogs(ctx context.Context, serviceID string, options container.LogsOptions) (io.ReadCloser, error) {
+	m.options = options
+	return io.NopCloser(bytes.NewReader(m.logs)), nil
+}
+
+func (m *MockLogClient) TaskLogs(ctx context.Context, taskID string, options container.LogsOptions) (io.ReadCloser, error) {
+	return m.ServiceLogs(ctx, taskID, options)
+}
+
+func (m *MockLogClient) TaskInspectWithRaw(ctx context.Context, taskID string) (swarm.Task, []byte, error) {
+	if taskID != "task_1" {
+		return swarm.Task{}, nil, errdefs.NotFound(errors.New("task " + taskID + " not found"))
+	}
+	return swarm.Task{ID: "task_1", ServiceID: "service_id", Slot: 1, NodeID: "node_1"}, nil, nil
+}
+
+func (m *MockLogClient) NodeList(ctx context.Context, options types.NodeListOptions) ([]swarm.Node, error) {
+	return []swarm.Node{{ID: "node_1", Description: swarm.NodeDescription{Hostname: "manager-1"}}}, nil
+}
+
+func newMockLogClient() *MockLogClient {
+	var logs bytes.Buffer
+	details := " com.docker.swarm.node.id=node_1,com.docker.swarm.service.id=service_id,com.docker.swarm.task.id=task_1 "
+	stdcopy.NewStdWriter(&logs, stdcopy.Stdout).Write([]byte("2025-01-02T03:04:05.000000000Z" + details + "listening on :80\n2025-01-02T03:04:06.0000"))
+	stdcopy.NewStdWriter(&logs, stdcopy.Stderr).Write([]byte("2025-01-02T03:04:05.500000000Z" + details + "warning: no config\n"))
+	stdcopy.NewStdWriter(&logs, stdcopy.Stdout).Write([]byte("00000Z" + details + "ready\n"))
+
+	return &MockLogClient{MockServiceClient: *newMockServiceClient(replicated(1)), logs: logs.Bytes()}
+}
+
+func TestServiceLogs(t *testing.T) {
+	mockClient := newMockLogClient()
+
+	logs, err := docker.OpenServiceLogs(context.Background(), mockClient, "test_stack", "web", docker.LogOptions{Stdout: true, Stderr: true, Tail: "10"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer logs.Close()
+
+	if !mockClient.options.Timestamps || !mockClient.options.Details || mockClient.options.Tail != "10" {
+		t.Errorf("expected timestamps and details to be requested, got %+v", mockClient.options)
+	}
+
+	var lines []models.LogLine
+	err = logs.Each(func(line models.LogLine) error {
+		lines = append(lines, line)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %+v", lines)
+	}
+	expected := models.LogLine{Stream: "stdout", Task: "web.1", TaskID: "task_1", NodeID: "node_1", Node: "manager-1", Message: "listening on :80"}
+	if lines[0] != expected {
+		t.Errorf("expected %+v, got %+v", expected, lines[0])
+	}
+	if lines[1].Stream != "stderr" || lines[1].Message != "warning: no config" {
+		t.Errorf("expected the stderr line, got %+v", lines[1])
+	}
+	if lines[2].Stream != "stdout" || lines[2].Message != "ready" {
+		t.Errorf("expected a line split across frames to be joined, got %+v", lines[2])
+	}
+
+	if text := docker.FormatLogLine(lines[0]); text != "web.1@manager-1    | listening on :80" {
+		t.Errorf("unexpected formatted line %q", text)
+	}
+}
+
+func TestServiceLogsOptions(t *testing.T) {
+	mockClient := newMockLogClient()
+
+	tests := []struct {
+		options  docker.LogOptions
+		expected error
+	}{
+		{docker.LogOptions{}, docker.ErrInvalidLogOptions},
+		{docker.LogOptions{Stdout: true, Tail: "-1"}, docker.ErrInvalidLogOptions},
+		{docker.LogOptions{Stdout: true, Since: "yesterday"}, docker.ErrInvalidLogOptions},
+		{docker.LogOptions{Stdout: true, Task: "task_2"}, docker.ErrTaskNotFound},
+		{docker.LogOptions{Stdout: true, Task: "task_1", Since: "10m"}, nil},
+	}
+
+	for _, test := range tests {
+		_, err := docker.OpenServiceLogs(context.Background(), mockClient, "test_stack", "web", test.options)
+		if !errors.Is(err, test.expected) {
+			t.Errorf("options %+v: expected %v, got %v", test.options, test.expected, err)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package docker
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/dockrelix/dockrelix-backend/models"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	resetTag    = "!reset"
+	overrideTag = "!override"
+)
+
+// sequenceKey returns the identity of a sequence entry, entries of an override with the same
+// identity as a base entry replace it rather than being appended.
+type sequenceKey func(node *yaml.Node) string
+
+// serviceSequences lists how the sequences of a service are merged, the ones missing here are appended.
+// A nil key means the override replaces the whole sequence.
+var serviceSequences = map[string]sequenceKey{
+	"ports":       nodeIdentity,
+	"networks":    nodeIdentity,
+	"volumes":     volumeTarget,
+	"secrets":     fileTarget,
+	"configs":     fileTarget,
+	"environment": assignmentKey,
+	"labels":      assignmentKey,
+	"sysctls":     assignmentKey,
+	"tmpfs":       nodeIdentity,
+	"cap_add":     nodeIdentity,
+	"cap_drop":    nodeIdentity,
+	"dns":         nodeIdentity,
+	"dns_search":  nodeIdentity,
+	"dns_opt":     nodeIdentity,
+	"extra_hosts": nodeIdentity,
+	"command":     nil,
+	"entrypoint":  nil,
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return resolveAlias(node.Content[i+1])
+		}
+	}
+	return nil
+}
+
+// nodeIdentity identifies a scalar by its value and a mapping by its scalar fields.
+func nodeIdentity(node *yaml.Node) string {
+	node = resolveAlias(node)
+	if node.Kind != yaml.MappingNode {
+		return node.Value
+	}
+
+	fields := []string{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		value := resolveAlias(node.Content[i+1])
+		if value.Kind == yaml.ScalarNode {
+			fields = append(fields, node.Content[i].Value+"="+value.Value)
+		}
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, ",")
+}
+
+func volumeTarget(node *yaml.Node) string {
+	node = resolveAlias(node)
+	if node.Kind == yaml.MappingNode {
+		if target := mappingValue(node, "target"); target != nil {
+			return target.Value
+		}
+		return nodeIdentity(node)
+	}
+
+	parts := strings.Split(node.Value, ":")
+	if len(parts) > 1 {
+		return parts[1]
+	}
+	return parts[0]
+}
+
+func fileTarget(node *yaml.Node) string {
+	node = resolveAlias(node)
+	if node.Kind != yaml.MappingNode {
+		return node.Value
+	}
+	if target := mappingValue(node, "target"); target != nil {
+		return target.Value
+	}
+	if source := mappingValue(node, "source"); source != nil {
+		return source.Value
+	}
+	return nodeIdentity(node)
+}
+
+func assignmentKey(node *yaml.Node) string {
+	node = resolveAlias(node)
+	return strings.SplitN(node.Value, "=", 2)[0]
+}
+
+// pathSequenceKey looks up the merge rule of the sequence at path, ok is false for plain sequences.
+func pathSequenceKey(path []string) (sequenceKey, bool) {
+	if len(path) != 3 || path[0] != "services" {
+		return nil, false
+	}
+	key, ok := serviceSequences[path[2]]
+	return key, ok
+}
+
+func clearMergeTag(node *yaml.Node) {
+	if node.Tag == resetTag || node.Tag == overrideTag {
+		node.Tag = ""
+		node.Style &^= yaml.TaggedStyle
+	}
+}
+
+// stripMergeTags drops !reset entries and !override tags from a node that has nothing left to merge with.
+func stripMergeTags(node *yaml.Node) *yaml.Node {
+	clearMergeTag(node)
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		content := make([]*yaml.Node, 0, len(node.Content))
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i+1].Tag == resetTag {
+				continue
+			}
+			content = append(content, node.Content[i], stripMergeTags(node.Content[i+1]))
+		}
+		node.Content = content
+	case yaml.SequenceNode:
+		content := make([]*yaml.Node, 0, len(node.Content))
+		for _, child := range node.Content {
+			if child.Tag == resetTag {
+				continue
+			}
+			content = append(content, stripMergeTags(child))
+		}
+		node.Content = content
+	}
+	return node
+}
+
+func mergeMapping(base, override *yaml.Node, path []string) *yaml.Node {
+	result := *base
+	result.Content = append([]*yaml.Node(nil), base.Content...)
+
+	for i := 0; i+1 < len(override.Content); i += 2 {
+		key, value := override.Content[i], override.Content[i+1]
+
+		index := -1
+		for j := 0; j+1 < len(result.Content); j += 2 {
+			if result.Content[j].Value == key.Value {
+				index = j
+				break
+			}
+		}
+
+		switch {
+		case value.Tag == resetTag:
+			if index >= 0 {
+				result.Content = append(result.Content[:index], result.Content[index+2:]...)
+			}
+		case index < 0:
+			result.Content = append(result.Content, key, stripMergeTags(value))
+		default:
+			childPath := append(append([]string(nil), path...), key.Value)
+			result.Content[index+1] = mergeNode(result.Content[index+1], value, childPath)
+		}
+	}
+	return &result
+}
+
+func mergeSequence(base, override *yaml.Node, path []string) *yaml.Node {
+	key, keyed := pathSequenceKey(path)
+	if keyed && key == nil {
+		return stripMergeTags(override)
+	}
+
+	result := *base
+	result.Content = append([]*yaml.Node(nil), base.Content...)
+
+	for _, item := range stripMergeTags(override).Content {
+		index := -1
+		if keyed {
+			identity := key(item)
+			for j, existing := range result.Content {
+				if key(existing) == identity {
+					index = j
+					break
+				}
+			}
+		}
+
+		if index >= 0 {
+			result.Content[index] = item
+		} else {
+			result.Content = append(result.Content, item)
+		}
+	}
+	return &result
+}
+
+// mergeNode merges override onto base following the compose merge rules: mappings are merged key by key,
+// sequences are appended or matched by key and scalars are replaced. An !override tag replaces the base
+// value as a whole and a !reset tag removes it.
+func mergeNode(base, override *yaml.Node, path []string) *yaml.Node {
+	base = resolveAlias(base)
+	override = resolveAlias(override)
+
+	if override.Tag == overrideTag {
+		return stripMergeTags(override)
+	}
+
+	switch {
+	case base.Kind == yaml.MappingNode && override.Kind == yaml.MappingNode:
+		return mergeMapping(base, override, path)
+	case base.Kind == yaml.SequenceNode && override.Kind == yaml.SequenceNode:
+		return mergeSequence(base, override, path)
+	}
+	return stripMergeTags(override)
+}
+
+// parseDocument parses every document of a compose file and merges them in order, so a single draft can
+// hold a base file followed by its overrides. Line numbers still point into the original file.
+func parseDocument(data string) (*yaml.Node, []models.ValidationError) {
+	decoder := yaml.NewDecoder(strings.NewReader(data))
+
+	var root *yaml.Node
+	for {
+		var document yaml.Node
+		err := decoder.Decode(&document)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, []models.ValidationError{syntaxError(err)}
+		}
+
+		if len(document.Content) == 0 || isNullNode(document.Content[0]) {
+			continue
+		}
+		expanded, errs := expandDocument(document.Content[0])
+		if errs != nil {
+			return nil, errs
+		}
+		if root == nil {
+			root = stripMergeTags(expanded)
+			continue
+		}
+		root = mergeNode(root, expanded, nil)
+	}
+
+	if root == nil {
+		return nil, []models.ValidationError{{Message: "compose file is empty"}}
+	}
+	return root, nil
+}
+
+// JoinDocuments builds a compose file holding each of the documents in order, to be merged on parsing.
+func JoinDocuments(documents []string) string {
+	parts := make([]string, 0, len(documents))
+	for _, document := range documents {
+		document = strings.TrimPrefix(strings.TrimSpace(document), "---")
+		parts = append(parts, strings.TrimSpace(document)+"\n")
+	}
+	return strings.Join(parts, "---\n")
+}
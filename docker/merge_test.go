@@ -0,0 +1,100 @@
+package docker_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dockrelix/dockrelix-backend/docker"
+	"github.com/dockrelix/dockrelix-backend/models/parser"
+)
+
+func TestRenderComposeMergesDocuments(t *testing.T) {
+	base := `services:
+  web:
+    image: nginx:1.24
+    ports:
+      - "80:80"
+    volumes:
+      - data:/var/www
+      - logs:/var/log
+    environment:
+      - MODE=dev
+      - DEBUG=1
+    networks:
+      - frontend
+    deploy:
+      replicas: 1
+  worker:
+    image: worker:latest
+networks:
+  frontend: {}
+volumes:
+  data: {}
+  logs: {}
+  cache: {}
+`
+	prod := `services:
+  web:
+    image: nginx:1.25
+    ports:
+      - "80:80"
+      - "443:443"
+    volumes:
+      - cache:/var/www
+    environment:
+      - MODE=prod
+    networks: !override
+      - backend
+    deploy:
+      replicas: 3
+  worker: !reset
+networks:
+  backend: {}
+`
+
+	config, err := docker.RenderCompose(docker.JoinDocuments([]string{base, prod}), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := config.Services["worker"]; ok {
+		t.Error("expected worker to be reset")
+	}
+
+	web := config.Services["web"]
+	if web.Image != "nginx:1.25" || web.Deploy.Replicas != 3 {
+		t.Errorf("expected scalars to be overridden, got %s with %d replicas", web.Image, web.Deploy.Replicas)
+	}
+	if expected := []parser.ServicePort{{Short: "80:80"}, {Short: "443:443"}}; !reflect.DeepEqual(web.Ports, expected) {
+		t.Errorf("expected ports %v, got %v", expected, web.Ports)
+	}
+	if expected := []parser.ServiceVolume{{Short: "cache:/var/www"}, {Short: "logs:/var/log"}}; !reflect.DeepEqual(web.Volumes, expected) {
+		t.Errorf("expected volumes %v, got %v", expected, web.Volumes)
+	}
+	if expected := (parser.KeyValueList{"MODE=prod", "DEBUG=1"}); !reflect.DeepEqual(web.Environment, expected) {
+		t.Errorf("expected environment %v, got %v", expected, web.Environment)
+	}
+	if expected := []string{"backend"}; !reflect.DeepEqual(web.Networks.Names(), expected) {
+		t.Errorf("expected networks %v, got %v", expected, web.Networks)
+	}
+	if len(config.Networks) != 2 {
+		t.Errorf("expected both networks to be defined, got %v", config.Networks)
+	}
+}
+
+func TestValidateComposeReportsOverrideLines(t *testing.T) {
+	data := `services:
+  web:
+    image: nginx
+---
+services:
+  web:
+    deploy:
+      replicas: two
+`
+
+	errs := docker.ValidateCompose(data)
+	if len(errs) != 1 || errs[0].Line != 8 {
+		t.Errorf("expected a single error on line 8, got %+v", errs)
+	}
+}
@@ -0,0 +1,320 @@
+package docker
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/api/types/system"
+	"github.com/dockrelix/dockrelix-backend/database"
+	"github.com/dockrelix/dockrelix-backend/models"
+)
+
+// defaultMetricsInterval and defaultMetricsRetention apply when METRICS_INTERVAL and METRICS_RETENTION are not set,
+// metricsWorkers bounds the number of containers sampled at once.
+const (
+	defaultMetricsInterval  = 15 * time.Second
+	defaultMetricsRetention = 24 * time.Hour
+	metricsWorkers          = 8
+)
+
+// MetricsClient adds the calls needed to sample the containers of stack tasks on the node of the daemon
+type MetricsClient interface {
+	Info(ctx context.Context) (system.Info, error)
+	ServiceList(ctx context.Context, options types.ServiceListOptions) ([]swarm.Service, error)
+	ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error)
+	ContainerStatsOneShot(ctx context.Context, containerID string) (container.StatsResponseReader, error)
+}
+
+// containerSample holds the counters of a container, rates and CPU usage are taken from two samples in a row.
+type containerSample struct {
+	read        time.Time
+	cpuTotal    uint64
+	systemTotal uint64
+	networkRx   uint64
+	networkTx   uint64
+	blockRead   uint64
+	blockWrite  uint64
+}
+
+// MetricsCollector samples the containers of stack tasks on the node of the daemon only and stores their usage per service
+// and stack, keeping a rolling window in the database.
+type MetricsCollector struct {
+	cli       MetricsClient
+	Interval  time.Duration
+	Retention time.Duration
+
+	mu       sync.Mutex
+	previous map[string]containerSample
+	current  []models.ServiceMetric
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		log.Printf("Invalid %s %q, using %s", key, value, fallback)
+		return fallback
+	}
+	return duration
+}
+
+// NewMetricsCollector reads the sampling interval and the window kept from METRICS_INTERVAL and METRICS_RETENTION,
+// an interval of 0 turns collecting off.
+func NewMetricsCollector(cli MetricsClient) *MetricsCollector {
+	return &MetricsCollector{
+		cli:       cli,
+		Interval:  durationFromEnv("METRICS_INTERVAL", defaultMetricsInterval),
+		Retention: durationFromEnv("METRICS_RETENTION", defaultMetricsRetention),
+		previous:  map[string]containerSample{},
+	}
+}
+
+func counterRate(current, previous uint64, seconds float64) float64 {
+	if current < previous || seconds <= 0 {
+		return 0
+	}
+	return float64(current-previous) / seconds
+}
+
+// containerMetric computes the usage of a container from its stats and its previous sample, the first sample of a
+// container only carries its memory usage. A container without a memory limit reports the memory of the node as its
+// limit, it is counted as unlimited instead.
+func containerMetric(stats container.StatsResponse, previous containerSample, found bool, nodeMemory uint64) (models.ServiceMetric, containerSample) {
+	sample := containerSample{
+		read:        stats.Read,
+		cpuTotal:    stats.CPUStats.CPUUsage.TotalUsage,
+		systemTotal: stats.CPUStats.SystemUsage,
+	}
+	for _, network := range stats.Networks {
+		sample.networkRx += network.RxBytes
+		sample.networkTx += network.TxBytes
+	}
+	for _, entry := range stats.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			sample.blockRead += entry.Value
+		case "write":
+			sample.blockWrite += entry.Value
+		}
+	}
+
+	// Like `docker stats`, the page cache that can be reclaimed is not counted as used.
+	memory := stats.MemoryStats
+	usage := memory.Usage
+	for _, key := range []string{"total_inactive_file", "inactive_file"} {
+		if inactive, ok := memory.Stats[key]; ok {
+			if inactive < usage {
+				usage -= inactive
+			}
+			break
+		}
+	}
+	metric := models.ServiceMetric{Tasks: 1, MemoryUsage: usage, MemoryLimit: memory.Limit}
+	if memory.Limit == 0 || (nodeMemory > 0 && memory.Limit >= nodeMemory) {
+		metric.MemoryLimit = 0
+		metric.UnlimitedTasks = 1
+	}
+
+	if !found {
+		return metric, sample
+	}
+
+	cpus := float64(stats.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if sample.systemTotal > previous.systemTotal && sample.cpuTotal >= previous.cpuTotal {
+		metric.CPUPercent = float64(sample.cpuTotal-previous.cpuTotal) / float64(sample.systemTotal-previous.systemTotal) * cpus * 100
+	}
+
+	seconds := sample.read.Sub(previous.read).Seconds()
+	metric.NetworkRx = counterRate(sample.networkRx, previous.networkRx, seconds)
+	metric.NetworkTx = counterRate(sample.networkTx, previous.networkTx, seconds)
+	metric.BlockRead = counterRate(sample.blockRead, previous.blockRead, seconds)
+	metric.BlockWrite = counterRate(sample.blockWrite, previous.blockWrite, seconds)
+	return metric, sample
+}
+
+func addMetric(total *models.ServiceMetric, metric models.ServiceMetric) {
+	total.Tasks += metric.Tasks
+	total.CPUPercent += metric.CPUPercent
+	total.MemoryUsage += metric.MemoryUsage
+	total.MemoryLimit += metric.MemoryLimit
+	total.UnlimitedTasks += metric.UnlimitedTasks
+	total.NetworkRx += metric.NetworkRx
+	total.NetworkTx += metric.NetworkTx
+	total.BlockRead += metric.BlockRead
+	total.BlockWrite += metric.BlockWrite
+}
+
+func (m *MetricsCollector) stats(ctx context.Context, id string) (container.StatsResponse, error) {
+	response, err := m.cli.ContainerStatsOneShot(ctx, id)
+	if err != nil {
+		return container.StatsResponse{}, err
+	}
+	defer response.Body.Close()
+
+	var stats container.StatsResponse
+	err = json.NewDecoder(response.Body).Decode(&stats)
+	return stats, err
+}
+
+// Collect samples the running containers of stack tasks on this node once, sums their usage per service and stack, stores the
+// result and drops what fell out of the window. Services with no task on this node are kept with their expected
+// tasks so the part that was not sampled shows.
+func (m *MetricsCollector) Collect(ctx context.Context) ([]models.ServiceMetric, error) {
+	info, err := m.cli.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	services, err := m.cli.ServiceList(ctx, types.ServiceListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", "com.docker.stack.namespace")),
+		Status:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	containers, err := m.cli.ContainerList(ctx, container.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", "com.docker.stack.namespace"), filters.Arg("label", "com.docker.swarm.task.id")),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make([]container.StatsResponse, len(containers))
+	errs := make([]error, len(containers))
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, metricsWorkers)
+	for i, c := range containers {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func() {
+			defer wg.Done()
+			samples[i], errs[i] = m.stats(ctx, c.ID)
+			<-workers
+		}()
+	}
+	wg.Wait()
+
+	now := time.Now()
+	totals := map[[2]string]*models.ServiceMetric{}
+	total := func(stack, service string) *models.ServiceMetric {
+		key := [2]string{stack, service}
+		if totals[key] == nil {
+			totals[key] = &models.ServiceMetric{Node: info.Name, StackName: stack, Service: service, Time: now}
+		}
+		return totals[key]
+	}
+
+	for _, srv := range services {
+		if srv.ServiceStatus == nil {
+			continue
+		}
+		stack := srv.Spec.Labels["com.docker.stack.namespace"]
+		total(stack, "").ExpectedTasks += srv.ServiceStatus.RunningTasks
+		total(stack, RemoveStackFromName(srv.Spec.Name, stack)).ExpectedTasks += srv.ServiceStatus.RunningTasks
+	}
+
+	m.mu.Lock()
+	previous := map[string]containerSample{}
+	for i, c := range containers {
+		if errs[i] != nil {
+			// The container may have stopped since it was listed.
+			continue
+		}
+		last, found := m.previous[c.ID]
+		metric, sample := containerMetric(samples[i], last, found, uint64(max(info.MemTotal, 0)))
+		previous[c.ID] = sample
+
+		stack := c.Labels["com.docker.stack.namespace"]
+		service := RemoveStackFromName(c.Labels["com.docker.swarm.service.name"], stack)
+		addMetric(total(stack, ""), metric)
+		addMetric(total(stack, service), metric)
+	}
+	m.previous = previous
+	m.mu.Unlock()
+
+	metrics := make([]models.ServiceMetric, 0, len(totals))
+	for _, metric := range totals {
+		metrics = append(metrics, *metric)
+	}
+	slices.SortFunc(metrics, func(a, b models.ServiceMetric) int {
+		return cmp.Or(cmp.Compare(a.StackName, b.StackName), cmp.Compare(a.Service, b.Service))
+	})
+
+	if len(metrics) > 0 {
+		if err := database.DB.Create(&metrics).Error; err != nil {
+			return nil, err
+		}
+	}
+	if err := database.DB.Where("time < ?", now.Add(-m.Retention)).Delete(&models.ServiceMetric{}).Error; err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.current = metrics
+	m.mu.Unlock()
+	return metrics, nil
+}
+
+// Run collects metrics on the interval until ctx is done.
+func (m *MetricsCollector) Run(ctx context.Context) {
+	if m.Interval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := m.Collect(ctx); err != nil {
+			log.Printf("Error collecting metrics: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Current returns the latest metrics of a stack on this node, its totals first, or of every stack when stackName is empty.
+func (m *MetricsCollector) Current(stackName string) []models.ServiceMetric {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := []models.ServiceMetric{}
+	for _, metric := range m.current {
+		if stackName == "" || metric.StackName == stackName {
+			result = append(result, metric)
+		}
+	}
+	return result
+}
+
+// GetMetricsHistory returns the stored metrics of a service since the given time, oldest first, or the totals of the
+// stack when service is empty.
+func GetMetricsHistory(stackName, service string, since time.Time) ([]models.ServiceMetric, error) {
+	metrics := []models.ServiceMetric{}
+	err := database.DB.
+		Where("stack_name = ? AND service = ? AND time >= ?", stackName, service, since).
+		Order("time").
+		Find(&metrics).Error
+	return metrics, err
+}
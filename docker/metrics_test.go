@@ -0,0 +1,132 @@
+package docker_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/api/types/system"
+	"github.com/dockrelix/dockrelix-backend/database"
+	"github.com/dockrelix/dockrelix-backend/docker"
+	"github.com/dockrelix/dockrelix-backend/models"
+)
+
+type MockMetricsClient struct {
+	round int
+	read  time.Time
+}
+
+func (m *MockMetricsClient) Info(ctx context.Context) (system.Info, error) {
+	return system.Info{Name: "node-1", MemTotal: 4 << 30}, nil
+}
+
+func (m *MockMetricsClient) ServiceList(ctx context.Context, options types.ServiceListOptions) ([]swarm.Service, error) {
+	service := func(name string, running uint64) swarm.Service {
+		return swarm.Service{
+			Spec:          swarm.ServiceSpec{Annotations: swarm.Annotations{Name: "test_stack_" + name, Labels: testStackLabels}},
+			ServiceStatus: &swarm.ServiceStatus{RunningTasks: running},
+		}
+	}
+	return []swarm.Service{service("web", 3), service("db", 1), service("cache", 1)}, nil
+}
+
+func (m *MockMetricsClient) ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error) {
+	labels := func(service string) map[string]string {
+		return map[string]string{"com.docker.stack.namespace": "test_stack", "com.docker.swarm.service.name": "test_stack_" + service}
+	}
+	return []container.Summary{
+		{ID: "web_1", Labels: labels("web")},
+		{ID: "web_2", Labels: labels("web")},
+		{ID: "db_1", Labels: labels("db")},
+	}, nil
+}
+
+// ContainerStatsOneShot reports each container ten seconds further on in every round, having used a quarter of
+// one of its two CPUs, received 1000 bytes a second and read 500. The db container has no memory limit and reports
+// the memory of the node.
+func (m *MockMetricsClient) ContainerStatsOneShot(ctx context.Context, containerID string) (container.StatsResponseReader, error) {
+	round := uint64(m.round)
+	limit := uint64(1 << 30)
+	if containerID == "db_1" {
+		limit = 4 << 30
+	}
+	stats := container.StatsResponse{
+		Read: m.read.Add(time.Duration(round) * 10 * time.Second),
+		CPUStats: container.CPUStats{
+			CPUUsage:    container.CPUUsage{TotalUsage: round * 5e9},
+			SystemUsage: round * 20e9,
+			OnlineCPUs:  2,
+		},
+		MemoryStats: container.MemoryStats{Usage: 300 << 20, Limit: limit, Stats: map[string]uint64{"inactive_file": 100 << 20}},
+		Networks:    map[string]container.NetworkStats{"eth0": {RxBytes: round * 10000, TxBytes: round * 2000}},
+		BlkioStats: container.BlkioStats{IoServiceBytesRecursive: []container.BlkioStatEntry{
+			{Op: "read", Value: round * 5000},
+			{Op: "write", Value: round * 1000},
+		}},
+	}
+	data, _ := json.Marshal(stats)
+	return container.StatsResponseReader{Body: io.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func TestMetricsCollector(t *testing.T) {
+	database.InitDBForTesting()
+	mockClient := &MockMetricsClient{read: time.Now()}
+	collector := docker.NewMetricsCollector(mockClient)
+
+	database.DB.Create(&models.ServiceMetric{StackName: "test_stack", Time: time.Now().Add(-48 * time.Hour)})
+
+	metrics, err := collector.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(metrics) != 4 || metrics[0].Service != "" || metrics[1].Service != "cache" || metrics[2].Service != "db" || metrics[3].Service != "web" {
+		t.Fatalf("expected stack totals followed by cache, db and web, got %+v", metrics)
+	}
+	if metrics[3].Tasks != 2 || metrics[3].MemoryUsage != 400<<20 || metrics[3].CPUPercent != 0 {
+		t.Errorf("expected the first sample to only carry memory, got %+v", metrics[3])
+	}
+	if metrics[0].Node != "node-1" || metrics[3].Node != "node-1" {
+		t.Errorf("expected the metrics to name the sampled node, got %+v", metrics)
+	}
+	if metrics[0].MemoryLimit != 2<<30 || metrics[0].UnlimitedTasks != 1 || metrics[2].MemoryLimit != 0 || metrics[2].UnlimitedTasks != 1 || metrics[3].MemoryLimit != 2<<30 {
+		t.Errorf("expected the unlimited db task left out of the memory limit, got %+v", metrics)
+	}
+	if metrics[0].ExpectedTasks != 5 || metrics[1].Tasks != 0 || metrics[1].ExpectedTasks != 1 || metrics[3].ExpectedTasks != 3 {
+		t.Errorf("expected the tasks swarm runs next to the sampled ones, got %+v", metrics)
+	}
+
+	mockClient.round++
+	if _, err := collector.Collect(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	current := collector.Current("test_stack")
+	if len(current) != 4 {
+		t.Fatalf("expected 4 current metrics, got %+v", current)
+	}
+	stack, web := current[0], current[3]
+	if stack.Tasks != 3 || math.Abs(stack.CPUPercent-150) > 0.001 || math.Abs(stack.NetworkRx-3000) > 0.001 {
+		t.Errorf("unexpected stack totals %+v", stack)
+	}
+	if math.Abs(web.CPUPercent-100) > 0.001 || math.Abs(web.NetworkTx-400) > 0.001 || math.Abs(web.BlockRead-1000) > 0.001 || math.Abs(web.BlockWrite-200) > 0.001 {
+		t.Errorf("unexpected web metrics %+v", web)
+	}
+	if len(collector.Current("other_stack")) != 0 {
+		t.Error("expected no metrics for another stack")
+	}
+
+	history, err := docker.GetMetricsHistory("test_stack", "", time.Now().Add(-72*time.Hour))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(history) != 2 || history[0].CPUPercent != 0 || history[1].CPUPercent == 0 {
+		t.Errorf("expected two stack samples oldest first without the expired one, got %+v", history)
+	}
+}
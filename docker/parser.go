@@ -2,12 +2,11 @@ package docker
 
 import (
 	"context"
-	"fmt"
-	"strconv"
 	"strings"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/api/types/swarm"
 	"github.com/docker/docker/api/types/volume"
@@ -19,24 +18,63 @@ import (
 type DockerClient interface {
 	ServiceList(ctx context.Context, options types.ServiceListOptions) ([]swarm.Service, error)
 	NetworkList(ctx context.Context, options network.ListOptions) ([]network.Summary, error)
+	NetworkInspect(ctx context.Context, networkID string, options network.InspectOptions) (network.Inspect, error)
 	VolumeList(ctx context.Context, options volume.ListOptions) (volume.ListResponse, error)
 	SecretList(ctx context.Context, options types.SecretListOptions) ([]swarm.Secret, error)
 	ConfigList(ctx context.Context, options types.ConfigListOptions) ([]swarm.Config, error)
 }
 
+func stackFilter(stackName string) filters.Args {
+	return filters.NewArgs(filters.Arg("label", "com.docker.stack.namespace="+stackName))
+}
+
+func isStackResource(labels map[string]string, stackName string) bool {
+	return labels["com.docker.stack.namespace"] == stackName
+}
+
+// customName returns the full name of a resource whose name does not follow the stack naming, so it is kept on redeploy.
+func customName(fullName, name string) string {
+	if fullName == name {
+		return fullName
+	}
+	return ""
+}
+
 func RemoveStackFromName(name, stackName string) string {
 	output, _ := strings.CutPrefix(name, stackName+"_")
 	return output
 }
 
 func getServiceMode(srv swarm.Service) string {
-	if srv.Spec.Mode.Replicated != nil {
+	switch mode := srv.Spec.Mode; {
+	case mode.Replicated != nil:
 		return "replicated"
+	case mode.ReplicatedJob != nil:
+		return "replicated-job"
+	case mode.GlobalJob != nil:
+		return "global-job"
 	}
 	return "global"
 }
 
-func GenerateStackConfig(services []swarm.Service, networks []swarm.Network, volumes []*volume.Volume, secrets []swarm.Secret, configs []swarm.Config, stackName string) ([]byte, error) {
+func formatUpdateConfig(config *swarm.UpdateConfig) *parser.UpdateConfig {
+	if config == nil {
+		return nil
+	}
+	result := &parser.UpdateConfig{
+		Parallelism:     int(config.Parallelism),
+		Delay:           config.Delay.String(),
+		FailureAction:   config.FailureAction,
+		MaxFailureRatio: config.MaxFailureRatio,
+		Order:           string(config.Order),
+	}
+	if config.Monitor != 0 {
+		result.Monitor = config.Monitor.String()
+	}
+	return result
+}
+
+func GenerateStackConfig(services []swarm.Service, networks []network.Inspect, volumes []*volume.Volume, secrets []swarm.Secret, configs []swarm.Config, stackName string) ([]byte, error) {
 	config := parser.ComposeConfig{
 		Version:  "3.8",
 		Services: make(map[string]parser.Service),
@@ -47,32 +85,88 @@ func GenerateStackConfig(services []swarm.Service, networks []swarm.Network, vol
 	}
 
 	for _, srv := range services {
+		containerSpec := srv.Spec.TaskTemplate.ContainerSpec
 		service := parser.Service{
-			Image: srv.Spec.TaskTemplate.ContainerSpec.Image,
+			Image:           containerSpec.Image,
+			Command:         containerSpec.Args,
+			Entrypoint:      containerSpec.Command,
+			User:            containerSpec.User,
+			WorkingDir:      containerSpec.Dir,
+			Hostname:        containerSpec.Hostname,
+			Labels:          userLabels(containerSpec.Labels),
+			StopSignal:      containerSpec.StopSignal,
+			StopGracePeriod: durationString(containerSpec.StopGracePeriod),
+			Init:            containerSpec.Init,
+			ReadOnly:        containerSpec.ReadOnly,
+			CapAdd:          containerSpec.CapabilityAdd,
+			CapDrop:         containerSpec.CapabilityDrop,
+			Sysctls:         containerSpec.Sysctls,
+			Ulimits:         formatUlimits(containerSpec.Ulimits),
+			ExtraHosts:      formatExtraHosts(containerSpec.Hosts),
 			Deploy: parser.DeployConfig{
-				Mode: getServiceMode(srv),
+				Mode:   getServiceMode(srv),
+				Labels: userLabels(srv.Spec.Labels),
 			},
-			Networks: []string{},
+			Networks: parser.ServiceNetworks{},
+		}
+
+		if dns := containerSpec.DNSConfig; dns != nil {
+			service.DNS = dns.Nameservers
+			service.DNSSearch = dns.Search
+			service.DNSOpt = dns.Options
+		}
+
+		if isolation := string(containerSpec.Isolation); isolation != "" && isolation != "default" {
+			service.Isolation = isolation
 		}
 
 		if srv.Spec.Mode.Replicated != nil && srv.Spec.Mode.Replicated.Replicas != nil {
 			service.Deploy.Replicas = int(*srv.Spec.Mode.Replicated.Replicas)
 		}
+		if srv.Spec.Mode.ReplicatedJob != nil && srv.Spec.Mode.ReplicatedJob.TotalCompletions != nil {
+			service.Deploy.Replicas = int(*srv.Spec.Mode.ReplicatedJob.TotalCompletions)
+		}
+
+		if endpoint := srv.Spec.EndpointSpec; endpoint != nil && endpoint.Mode != swarm.ResolutionModeVIP {
+			service.Deploy.EndpointMode = string(endpoint.Mode)
+		}
+
+		if logDriver := srv.Spec.TaskTemplate.LogDriver; logDriver != nil {
+			service.Logging = &parser.Logging{
+				Driver:  logDriver.Name,
+				Options: logDriver.Options,
+			}
+		}
 
 		for _, port := range srv.Endpoint.Ports {
-			service.Ports = append(service.Ports, fmt.Sprintf("%d:%d/%s", port.PublishedPort, port.TargetPort, port.Protocol))
+			service.Ports = append(service.Ports, formatPortConfig(port))
 		}
 
 		for _, nw := range srv.Spec.TaskTemplate.Networks {
 			for _, net := range networks {
-				if nw.Target == net.ID {
-					service.Networks = append(service.Networks, RemoveStackFromName(net.Spec.Name, srv.Spec.Name))
+				if nw.Target != net.ID {
+					continue
+				}
+				// The service name is the alias every service gets, only the ones added to it are kept.
+				var aliases []string
+				for _, alias := range nw.Aliases {
+					if alias != srv.Spec.Name {
+						aliases = append(aliases, alias)
+					}
 				}
+				service.Networks = append(service.Networks, parser.ServiceNetwork{
+					Name:    RemoveStackFromName(net.Name, stackName),
+					Aliases: aliases,
+				})
 			}
 		}
 
 		for _, mount := range srv.Spec.TaskTemplate.ContainerSpec.Mounts {
-			service.Volumes = append(service.Volumes, fmt.Sprintf("%s:%s", RemoveStackFromName(mount.Source, stackName), mount.Target))
+			if tmpfs, ok := formatTmpfs(mount); ok {
+				service.Tmpfs = append(service.Tmpfs, tmpfs)
+				continue
+			}
+			service.Volumes = append(service.Volumes, formatMount(mount, stackName))
 		}
 
 		for _, cfg := range srv.Spec.TaskTemplate.ContainerSpec.Configs {
@@ -101,35 +195,25 @@ func GenerateStackConfig(services []swarm.Service, networks []swarm.Network, vol
 			}
 		}
 
-		if updateConfig := srv.Spec.UpdateConfig; updateConfig != nil {
-			service.Deploy.UpdateConfig = &parser.UpdateConfig{
-				Parallelism: int(updateConfig.Parallelism),
-				Delay:       updateConfig.Delay.String(),
-				Order:       string(updateConfig.Order),
-			}
-		}
-
-		if rollbackConfig := srv.Spec.RollbackConfig; rollbackConfig != nil {
-			service.Deploy.RollbackConfig = &parser.UpdateConfig{
-				Parallelism: int(rollbackConfig.Parallelism),
-				Delay:       rollbackConfig.Delay.String(),
-				Order:       string(rollbackConfig.Order),
-			}
-		}
+		service.Deploy.UpdateConfig = formatUpdateConfig(srv.Spec.UpdateConfig)
+		service.Deploy.RollbackConfig = formatUpdateConfig(srv.Spec.RollbackConfig)
 
 		if restartPolicy := srv.Spec.TaskTemplate.RestartPolicy; restartPolicy != nil {
 			service.Deploy.RestartPolicy = &parser.RestartPolicy{
-				Condition:   string(restartPolicy.Condition),
-				Delay:       restartPolicy.Delay.String(),
-				MaxAttempts: int(*restartPolicy.MaxAttempts),
-				Window:      restartPolicy.Window.String(),
+				Condition: string(restartPolicy.Condition),
+				Delay:     durationString(restartPolicy.Delay),
+				Window:    durationString(restartPolicy.Window),
+			}
+			if restartPolicy.MaxAttempts != nil {
+				service.Deploy.RestartPolicy.MaxAttempts = int(*restartPolicy.MaxAttempts)
 			}
 		}
 
 		if placement := srv.Spec.TaskTemplate.Placement; placement != nil {
-			if len(placement.Constraints) > 0 || len(placement.Preferences) > 0 {
+			if len(placement.Constraints) > 0 || len(placement.Preferences) > 0 || placement.MaxReplicas > 0 {
 				service.Deploy.Placement = &parser.Placement{
-					Constraints: placement.Constraints,
+					Constraints:        placement.Constraints,
+					MaxReplicasPerNode: placement.MaxReplicas,
 				}
 				for _, pref := range placement.Preferences {
 					service.Deploy.Placement.Preferences = append(service.Deploy.Placement.Preferences, parser.PlacementPreference{
@@ -144,14 +228,14 @@ func GenerateStackConfig(services []swarm.Service, networks []swarm.Network, vol
 				service.Deploy.Resources = &parser.Resources{}
 				if resources.Limits != nil {
 					service.Deploy.Resources.Limits = &parser.ResourceSpec{
-						CPUs:   strconv.FormatInt(resources.Limits.NanoCPUs/1e9, 10),
-						Memory: strconv.FormatInt(resources.Limits.MemoryBytes/(1024*1024), 10) + "M",
+						CPUs:   formatCPUs(resources.Limits.NanoCPUs),
+						Memory: formatMemory(resources.Limits.MemoryBytes),
 					}
 				}
 				if resources.Reservations != nil {
 					service.Deploy.Resources.Reservations = &parser.ResourceSpec{
-						CPUs:   strconv.FormatInt(resources.Reservations.NanoCPUs/1e9, 10),
-						Memory: strconv.FormatInt(resources.Reservations.MemoryBytes/(1024*1024), 10) + "M",
+						CPUs:   formatCPUs(resources.Reservations.NanoCPUs),
+						Memory: formatMemory(resources.Reservations.MemoryBytes),
 					}
 				}
 			}
@@ -161,80 +245,150 @@ func GenerateStackConfig(services []swarm.Service, networks []swarm.Network, vol
 	}
 
 	for _, net := range networks {
-		labels := net.Spec.Labels
-		delete(labels, "com.docker.stack.namespace")
-		config.Networks[RemoveStackFromName(net.Spec.Annotations.Name, stackName)] = parser.Network{
-			Driver:     net.Spec.DriverConfiguration.Name,
-			External:   !net.Spec.Internal,
-			Labels:     labels,
-			Attachable: net.Spec.Attachable,
-			Internal:   net.Spec.Internal,
+		name := RemoveStackFromName(net.Name, stackName)
+		if !isStackResource(net.Labels, stackName) {
+			config.Networks[name] = parser.Network{External: true, Name: net.Name}
+			continue
+		}
+
+		// Swarm picks the VXLAN ID of overlay networks itself, keeping it would pin it on redeploy.
+		var driverOpts map[string]string
+		for key, value := range net.Options {
+			if key == "com.docker.network.driver.overlay.vxlanid_list" {
+				continue
+			}
+			if driverOpts == nil {
+				driverOpts = map[string]string{}
+			}
+			driverOpts[key] = value
+		}
+
+		config.Networks[name] = parser.Network{
+			Name:       customName(net.Name, name),
+			Driver:     net.Driver,
+			DriverOpts: driverOpts,
+			Labels:     userLabels(net.Labels),
+			Attachable: net.Attachable,
+			Internal:   net.Internal,
+			EnableIPv6: net.EnableIPv6,
+			IPAM:       formatIPAM(net.IPAM),
 		}
 	}
 
 	for _, vol := range volumes {
-		labels := vol.Labels
-		delete(labels, "com.docker.stack.namespace")
-		config.Volumes[RemoveStackFromName(vol.Name, stackName)] = parser.Volume{
-			Driver:   vol.Driver,
-			External: vol.Options["external"] == "true",
-			Labels:   labels,
+		name := RemoveStackFromName(vol.Name, stackName)
+		config.Volumes[name] = parser.Volume{
+			Driver:     vol.Driver,
+			DriverOpts: vol.Options,
+			Labels:     userLabels(vol.Labels),
+		}
+	}
+
+	// Volumes are created on the nodes running the tasks, so the ones missing from the list are taken from the mounts.
+	for _, srv := range services {
+		for _, m := range srv.Spec.TaskTemplate.ContainerSpec.Mounts {
+			name := RemoveStackFromName(m.Source, stackName)
+			if m.Type != mount.TypeVolume || m.Source == "" {
+				continue
+			}
+			if _, ok := config.Volumes[name]; ok {
+				continue
+			}
+			if m.VolumeOptions == nil || !isStackResource(m.VolumeOptions.Labels, stackName) {
+				config.Volumes[name] = parser.Volume{External: true, Name: m.Source}
+				continue
+			}
+			vol := parser.Volume{Labels: userLabels(m.VolumeOptions.Labels)}
+			if driver := m.VolumeOptions.DriverConfig; driver != nil {
+				vol.Driver = driver.Name
+				vol.DriverOpts = driver.Options
+			}
+			config.Volumes[name] = vol
 		}
 	}
 
 	for _, secret := range secrets {
-		labels := secret.Spec.Labels
-		delete(labels, "com.docker.stack.namespace")
-		config.Secrets[RemoveStackFromName(secret.Spec.Name, stackName)] = parser.Secret{
-			File: secret.Spec.Name,
+		name := RemoveStackFromName(secret.Spec.Name, stackName)
+		// Swarm never hands back the value of a secret, so it is read from a file that has to be filled in.
+		result := parser.Secret{
+			Name:   customName(secret.Spec.Name, name),
+			File:   "./secrets/" + name,
+			Labels: userLabels(secret.Spec.Labels),
 		}
+		if secret.Spec.Templating != nil {
+			result.TemplateDriver = secret.Spec.Templating.Name
+		}
+		config.Secrets[name] = result
 	}
 
 	for _, cfg := range configs {
-		labels := cfg.Spec.Labels
-		delete(labels, "com.docker.stack.namespace")
-		config.Configs[RemoveStackFromName(cfg.Spec.Name, stackName)] = parser.Config{
-			File: cfg.Spec.Name,
+		name := RemoveStackFromName(cfg.Spec.Name, stackName)
+		result := parser.Config{
+			Name:    customName(cfg.Spec.Name, name),
+			Content: string(cfg.Spec.Data),
+			Labels:  userLabels(cfg.Spec.Labels),
+		}
+		if cfg.Spec.Templating != nil {
+			result.TemplateDriver = cfg.Spec.Templating.Name
+		}
+		config.Configs[name] = result
+	}
+
+	// Secrets and configs a service uses without them being part of the stack were created outside of it. Like
+	// external networks and volumes they are keyed the way services refer to them, with their swarm name set.
+	for _, srv := range services {
+		for _, ref := range srv.Spec.TaskTemplate.ContainerSpec.Secrets {
+			name := RemoveStackFromName(ref.SecretName, stackName)
+			if _, ok := config.Secrets[name]; !ok {
+				config.Secrets[name] = parser.Secret{External: true, Name: ref.SecretName}
+			}
+		}
+		for _, ref := range srv.Spec.TaskTemplate.ContainerSpec.Configs {
+			name := RemoveStackFromName(ref.ConfigName, stackName)
+			if _, ok := config.Configs[name]; !ok {
+				config.Configs[name] = parser.Config{External: true, Name: ref.ConfigName}
+			}
 		}
 	}
 
 	return yaml.Marshal(config)
 }
 
-func ParseStackConfig(cli DockerClient, stackName string) (parser.ComposeConfig, error) {
+// generateStackFile collects the resources of a running stack and returns them as a compose file.
+func generateStackFile(cli DockerClient, stackName string) ([]byte, error) {
 	services, err := cli.ServiceList(context.Background(), types.ServiceListOptions{
-		Filters: filters.NewArgs(filters.Arg("label", "com.docker.stack.namespace="+stackName)),
+		Filters: stackFilter(stackName),
 	})
 	if err != nil {
-		return parser.ComposeConfig{}, err
+		return nil, err
 	}
 
 	networks, err := cli.NetworkList(context.Background(), network.ListOptions{
-		Filters: filters.NewArgs(filters.Arg("label", "com.docker.stack.namespace="+stackName)),
+		Filters: stackFilter(stackName),
 	})
 	if err != nil {
-		return parser.ComposeConfig{}, err
+		return nil, err
 	}
 
 	volumes, err := cli.VolumeList(context.Background(), volume.ListOptions{
-		Filters: filters.NewArgs(filters.Arg("label", "com.docker.stack.namespace="+stackName)),
+		Filters: stackFilter(stackName),
 	})
 	if err != nil {
-		return parser.ComposeConfig{}, err
+		return nil, err
 	}
 
 	secrets, err := cli.SecretList(context.Background(), types.SecretListOptions{
-		Filters: filters.NewArgs(filters.Arg("label", "com.docker.stack.namespace="+stackName)),
+		Filters: stackFilter(stackName),
 	})
 	if err != nil {
-		return parser.ComposeConfig{}, err
+		return nil, err
 	}
 
 	configs, err := cli.ConfigList(context.Background(), types.ConfigListOptions{
-		Filters: filters.NewArgs(filters.Arg("label", "com.docker.stack.namespace="+stackName)),
+		Filters: stackFilter(stackName),
 	})
 	if err != nil {
-		return parser.ComposeConfig{}, err
+		return nil, err
 	}
 
 	var sanitizedServices []swarm.Service
@@ -245,47 +399,32 @@ func ParseStackConfig(cli DockerClient, stackName string) (parser.ComposeConfig,
 		sanitizedServices = append(sanitizedServices, sanitizedSrv)
 	}
 
-	var networksList []swarm.Network
+	var networksList []network.Inspect
+	listed := map[string]bool{}
 	for _, net := range networks {
-		networksList = append(networksList, swarm.Network{
-			ID: net.ID,
-			Spec: swarm.NetworkSpec{
-				Annotations: swarm.Annotations{
-					Name:   RemoveStackFromName(net.Name, stackName),
-					Labels: net.Labels,
-				},
-				DriverConfiguration: &swarm.Driver{
-					Name: net.Driver,
-				},
-				Scope:      net.Scope,
-				Attachable: net.Attachable,
-				Internal:   net.Internal,
-			},
-		})
+		listed[net.ID] = true
 	}
-
-	var sanitizedVolumes []*volume.Volume
-	for _, vol := range volumes.Volumes {
-		sanitizedVol := *vol
-		sanitizedVol.Name = RemoveStackFromName(vol.Name, stackName)
-		sanitizedVolumes = append(sanitizedVolumes, &sanitizedVol)
+	for _, srv := range services {
+		for _, nw := range srv.Spec.TaskTemplate.Networks {
+			if !listed[nw.Target] {
+				listed[nw.Target] = true
+				networks = append(networks, network.Summary{ID: nw.Target})
+			}
+		}
 	}
-
-	var sanitizedSecrets []swarm.Secret
-	for _, secret := range secrets {
-		sanitizedSecret := secret
-		sanitizedSecret.Spec.Name = RemoveStackFromName(secret.Spec.Name, stackName)
-		sanitizedSecrets = append(sanitizedSecrets, sanitizedSecret)
+	for _, net := range networks {
+		inspected, err := cli.NetworkInspect(context.Background(), net.ID, network.InspectOptions{})
+		if err != nil {
+			return nil, err
+		}
+		networksList = append(networksList, inspected)
 	}
 
-	var sanitizedConfigs []swarm.Config
-	for _, cfg := range configs {
-		sanitizedCfg := cfg
-		sanitizedCfg.Spec.Name = RemoveStackFromName(cfg.Spec.Name, stackName)
-		sanitizedConfigs = append(sanitizedConfigs, sanitizedCfg)
-	}
+	return GenerateStackConfig(sanitizedServices, networksList, volumes.Volumes, secrets, configs, stackName)
+}
 
-	configBytes, err := GenerateStackConfig(sanitizedServices, networksList, sanitizedVolumes, sanitizedSecrets, sanitizedConfigs, stackName)
+func ParseStackConfig(cli DockerClient, stackName string) (parser.ComposeConfig, error) {
+	configBytes, err := generateStackFile(cli, stackName)
 	if err != nil {
 		return parser.ComposeConfig{}, err
 	}
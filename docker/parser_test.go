@@ -2,9 +2,11 @@ package docker_test
 
 import (
 	"context"
+	"fmt"
 	"testing"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/api/types/swarm"
 	"github.com/docker/docker/api/types/volume"
@@ -17,6 +19,8 @@ type MockClient struct {
 	VolumeListFunc  func(ctx context.Context, options volume.ListOptions) (volume.ListResponse, error)
 	SecretListFunc  func(ctx context.Context, options types.SecretListOptions) ([]swarm.Secret, error)
 	ConfigListFunc  func(ctx context.Context, options types.ConfigListOptions) ([]swarm.Config, error)
+
+	NetworkInspectFunc func(ctx context.Context, networkID string, options network.InspectOptions) (network.Inspect, error)
 }
 
 func (m *MockClient) ServiceList(ctx context.Context, options types.ServiceListOptions) ([]swarm.Service, error) {
@@ -27,6 +31,23 @@ func (m *MockClient) NetworkList(ctx context.Context, options network.ListOption
 	return m.NetworkListFunc(ctx, options)
 }
 
+// NetworkInspect falls back to the listed network with the same ID, as a list already holds most of an inspect.
+func (m *MockClient) NetworkInspect(ctx context.Context, networkID string, options network.InspectOptions) (network.Inspect, error) {
+	if m.NetworkInspectFunc != nil {
+		return m.NetworkInspectFunc(ctx, networkID, options)
+	}
+	networks, err := m.NetworkList(ctx, network.ListOptions{})
+	if err != nil {
+		return network.Inspect{}, err
+	}
+	for _, net := range networks {
+		if net.ID == networkID {
+			return net, nil
+		}
+	}
+	return network.Inspect{}, fmt.Errorf("network %s not found", networkID)
+}
+
 func (m *MockClient) VolumeList(ctx context.Context, options volume.ListOptions) (volume.ListResponse, error) {
 	return m.VolumeListFunc(ctx, options)
 }
@@ -39,6 +60,8 @@ func (m *MockClient) ConfigList(ctx context.Context, options types.ConfigListOpt
 	return m.ConfigListFunc(ctx, options)
 }
 
+var testStackLabels = map[string]string{"com.docker.stack.namespace": "test_stack"}
+
 func TestParseStackConfig(t *testing.T) {
 	mockClient := &MockClient{
 		ServiceListFunc: func(ctx context.Context, options types.ServiceListOptions) ([]swarm.Service, error) {
@@ -58,16 +81,16 @@ func TestParseStackConfig(t *testing.T) {
 		NetworkListFunc: func(ctx context.Context, options network.ListOptions) ([]network.Summary, error) {
 			return []network.Summary{
 				{
-					ID: "network_id_1",
-
-					Name: "test_stack_network1",
+					ID:     "network_id_1",
+					Name:   "test_stack_network1",
+					Labels: testStackLabels,
 				},
 			}, nil
 		},
 		VolumeListFunc: func(ctx context.Context, options volume.ListOptions) (volume.ListResponse, error) {
 			return volume.ListResponse{
 				Volumes: []*volume.Volume{
-					{Name: "test_stack_volume1"},
+					{Name: "test_stack_volume1", Labels: testStackLabels},
 				},
 			}, nil
 		},
@@ -75,7 +98,7 @@ func TestParseStackConfig(t *testing.T) {
 			return []swarm.Secret{
 				{
 					Spec: swarm.SecretSpec{
-						Annotations: swarm.Annotations{Name: "test_stack_secret1"},
+						Annotations: swarm.Annotations{Name: "test_stack_secret1", Labels: testStackLabels},
 					},
 				},
 			}, nil
@@ -84,7 +107,7 @@ func TestParseStackConfig(t *testing.T) {
 			return []swarm.Config{
 				{
 					Spec: swarm.ConfigSpec{
-						Annotations: swarm.Annotations{Name: "test_stack_config1"},
+						Annotations: swarm.Annotations{Name: "test_stack_config1", Labels: testStackLabels},
 					},
 				},
 			}, nil
@@ -123,3 +146,179 @@ func TestParseStackConfig(t *testing.T) {
 		t.Errorf("expected image to be 'nginx:latest', got %s", config.Services["service1"].Image)
 	}
 }
+
+func TestParseStackConfigNetworkIPAM(t *testing.T) {
+	mockClient := &MockClient{
+		ServiceListFunc: func(ctx context.Context, options types.ServiceListOptions) ([]swarm.Service, error) {
+			return nil, nil
+		},
+		NetworkListFunc: func(ctx context.Context, options network.ListOptions) ([]network.Summary, error) {
+			return []network.Summary{{ID: "network_id_1", Name: "test_stack_backend"}}, nil
+		},
+		NetworkInspectFunc: func(ctx context.Context, networkID string, options network.InspectOptions) (network.Inspect, error) {
+			return network.Inspect{
+				ID:         networkID,
+				Name:       "test_stack_backend",
+				Labels:     testStackLabels,
+				Driver:     "overlay",
+				EnableIPv6: true,
+				IPAM: network.IPAM{
+					Driver: "default",
+					Config: []network.IPAMConfig{{
+						Subnet:     "10.20.0.0/24",
+						Gateway:    "10.20.0.1",
+						AuxAddress: map[string]string{"router": "10.20.0.2"},
+					}},
+				},
+				Options: map[string]string{
+					"encrypted": "",
+					"com.docker.network.driver.overlay.vxlanid_list": "4097",
+				},
+			}, nil
+		},
+		VolumeListFunc: func(ctx context.Context, options volume.ListOptions) (volume.ListResponse, error) {
+			return volume.ListResponse{}, nil
+		},
+		SecretListFunc: func(ctx context.Context, options types.SecretListOptions) ([]swarm.Secret, error) {
+			return nil, nil
+		},
+		ConfigListFunc: func(ctx context.Context, options types.ConfigListOptions) ([]swarm.Config, error) {
+			return nil, nil
+		},
+	}
+
+	config, err := docker.ParseStackConfig(mockClient, "test_stack")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	backend, ok := config.Networks["backend"]
+	if !ok {
+		t.Fatalf("expected backend network, got %v", config.Networks)
+	}
+	if !backend.EnableIPv6 || backend.Driver != "overlay" {
+		t.Errorf("expected an IPv6 overlay network, got %+v", backend)
+	}
+	if len(backend.DriverOpts) != 1 || backend.DriverOpts["encrypted"] != "" {
+		t.Errorf("expected only the encrypted driver option, got %v", backend.DriverOpts)
+	}
+	if backend.IPAM == nil || backend.IPAM.Driver != "" || len(backend.IPAM.Config) != 1 {
+		t.Fatalf("expected a single IPAM pool with the default driver, got %+v", backend.IPAM)
+	}
+	pool := backend.IPAM.Config[0]
+	if pool.Subnet != "10.20.0.0/24" || pool.Gateway != "10.20.0.1" || pool.AuxAddresses["router"] != "10.20.0.2" {
+		t.Errorf("expected the IPAM pool to be kept, got %+v", pool)
+	}
+}
+
+func TestParseStackConfigExternalResources(t *testing.T) {
+	mockClient := &MockClient{
+		ServiceListFunc: func(ctx context.Context, options types.ServiceListOptions) ([]swarm.Service, error) {
+			return []swarm.Service{{
+				Spec: swarm.ServiceSpec{
+					Annotations: swarm.Annotations{Name: "test_stack_web", Labels: testStackLabels},
+					TaskTemplate: swarm.TaskSpec{
+						ContainerSpec: &swarm.ContainerSpec{
+							Image: "nginx:latest",
+							Mounts: []mount.Mount{
+								{Type: mount.TypeVolume, Source: "backups", Target: "/backups"},
+								{Type: mount.TypeVolume, Source: "test_stack_cache", Target: "/cache"},
+								{
+									Type:   mount.TypeVolume,
+									Source: "test_stack_data",
+									Target: "/data",
+									VolumeOptions: &mount.VolumeOptions{
+										Labels:       testStackLabels,
+										DriverConfig: &mount.Driver{Name: "local", Options: map[string]string{"type": "nfs"}},
+									},
+								},
+							},
+							Secrets: []*swarm.SecretReference{
+								{SecretName: "shared_token", File: &swarm.SecretReferenceFileTarget{Name: "token"}},
+								{SecretName: "test_stack_legacy", File: &swarm.SecretReferenceFileTarget{Name: "legacy"}},
+							},
+							Configs: []*swarm.ConfigReference{
+								{ConfigName: "test_stack_nginx", File: &swarm.ConfigReferenceFileTarget{Name: "/etc/nginx/nginx.conf"}},
+								{ConfigName: "test_stack_proxy", File: &swarm.ConfigReferenceFileTarget{Name: "/etc/proxy.conf"}},
+							},
+						},
+						Networks: []swarm.NetworkAttachmentConfig{{Target: "proxy_id"}},
+					},
+				},
+			}}, nil
+		},
+		NetworkListFunc: func(ctx context.Context, options network.ListOptions) ([]network.Summary, error) {
+			return nil, nil
+		},
+		NetworkInspectFunc: func(ctx context.Context, networkID string, options network.InspectOptions) (network.Inspect, error) {
+			return network.Inspect{ID: networkID, Name: "proxy", Driver: "overlay"}, nil
+		},
+		VolumeListFunc: func(ctx context.Context, options volume.ListOptions) (volume.ListResponse, error) {
+			return volume.ListResponse{}, nil
+		},
+		SecretListFunc: func(ctx context.Context, options types.SecretListOptions) ([]swarm.Secret, error) {
+			return []swarm.Secret{{
+				Spec: swarm.SecretSpec{Annotations: swarm.Annotations{Name: "test_stack_password", Labels: testStackLabels}},
+			}}, nil
+		},
+		ConfigListFunc: func(ctx context.Context, options types.ConfigListOptions) ([]swarm.Config, error) {
+			return []swarm.Config{{
+				Spec: swarm.ConfigSpec{
+					Annotations: swarm.Annotations{Name: "test_stack_nginx", Labels: testStackLabels},
+					Data:        []byte("worker_processes 1;"),
+					Templating:  &swarm.Driver{Name: "golang"},
+				},
+			}}, nil
+		},
+	}
+
+	config, err := docker.ParseStackConfig(mockClient, "test_stack")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if !config.Networks["proxy"].External || !config.Volumes["backups"].External || !config.Secrets["shared_token"].External {
+		t.Errorf("expected proxy, backups and shared_token to be external, got %+v", config)
+	}
+
+	data := config.Volumes["data"]
+	if data.External || data.Driver != "local" || data.DriverOpts["type"] != "nfs" {
+		t.Errorf("expected data to be a stack volume with driver options, got %+v", data)
+	}
+
+	nginx := config.Configs["nginx"]
+	if nginx.External || nginx.File != "" || nginx.Content != "worker_processes 1;" || nginx.TemplateDriver != "golang" {
+		t.Errorf("expected nginx config with its content, got %+v", nginx)
+	}
+
+	if password := config.Secrets["password"]; password.External || password.File != "./secrets/password" {
+		t.Errorf("expected password secret to be read from a file, got %+v", password)
+	}
+
+	web := config.Services["web"]
+	if len(web.Networks) != 1 || web.Networks[0].Name != "proxy" {
+		t.Errorf("expected web to use the proxy network, got %v", web.Networks)
+	}
+
+	// Resources created outside of the stack under a name that starts like the stack's are keyed the way the
+	// service refers to them, with their full name kept.
+	if cache := config.Volumes["cache"]; !cache.External || cache.Name != "test_stack_cache" {
+		t.Errorf("expected cache to be an external volume named test_stack_cache, got %+v", cache)
+	}
+	if legacy := config.Secrets["legacy"]; !legacy.External || legacy.Name != "test_stack_legacy" {
+		t.Errorf("expected legacy to be an external secret named test_stack_legacy, got %+v", legacy)
+	}
+	if proxy := config.Configs["proxy"]; !proxy.External || proxy.Name != "test_stack_proxy" {
+		t.Errorf("expected proxy to be an external config named test_stack_proxy, got %+v", proxy)
+	}
+
+	spec, err := docker.ConvertService("test_stack", "web", web, config)
+	if err != nil {
+		t.Fatalf("expected the stack config to convert back, got %v", err)
+	}
+	container := spec.TaskTemplate.ContainerSpec
+	if container.Mounts[1].Source != "test_stack_cache" || container.Secrets[1].SecretName != "test_stack_legacy" ||
+		container.Configs[1].ConfigName != "test_stack_proxy" {
+		t.Errorf("expected external resources to keep their names, got %+v", container)
+	}
+}
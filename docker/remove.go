@@ -0,0 +1,169 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/dockrelix/dockrelix-backend/models"
+)
+
+// RemoveClient adds the calls needed to tear a stack down
+type RemoveClient interface {
+	DockerClient
+	ServiceRemove(ctx context.Context, serviceID string) error
+	NetworkRemove(ctx context.Context, networkID string) error
+	VolumeRemove(ctx context.Context, volumeID string, force bool) error
+	SecretRemove(ctx context.Context, id string) error
+	ConfigRemove(ctx context.Context, id string) error
+	TaskList(ctx context.Context, options types.TaskListOptions) ([]swarm.Task, error)
+}
+
+var taskDrainTimeout = 2 * time.Minute
+
+func isTerminalTaskState(state swarm.TaskState) bool {
+	switch state {
+	case swarm.TaskStateComplete, swarm.TaskStateShutdown, swarm.TaskStateFailed,
+		swarm.TaskStateRejected, swarm.TaskStateRemove, swarm.TaskStateOrphaned:
+		return true
+	}
+	return false
+}
+
+// waitForTasksToDrain polls the tasks of a stack until none of them runs, the drain timeout is over or ctx is done.
+func waitForTasksToDrain(ctx context.Context, cli RemoveClient, stackName string) error {
+	deadline := time.After(taskDrainTimeout)
+	ticker := time.NewTicker(taskPollInterval)
+	defer ticker.Stop()
+
+	for {
+		tasks, err := cli.TaskList(ctx, types.TaskListOptions{
+			Filters: stackFilter(stackName),
+		})
+		if err != nil {
+			return err
+		}
+
+		running := 0
+		for _, task := range tasks {
+			if !isTerminalTaskState(task.Status.State) {
+				running++
+			}
+		}
+		if running == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			return fmt.Errorf("%d tasks still running after %s", running, taskDrainTimeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+func removalResult(resourceType, name string, err error) models.ResourceResult {
+	result := models.ResourceResult{
+		Type:    resourceType,
+		Name:    name,
+		Removed: err == nil,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// RemoveStack removes every resource labelled with the stack namespace, volumes only when asked to. Once ctx is done,
+// such as when the client went away, it stops without touching the resources left.
+func RemoveStack(ctx context.Context, cli RemoveClient, stackName string, removeVolumes bool) (models.StackRemoval, error) {
+	removal := models.StackRemoval{
+		Name:      stackName,
+		Resources: []models.ResourceResult{},
+	}
+
+	services, err := cli.ServiceList(ctx, types.ServiceListOptions{
+		Filters: stackFilter(stackName),
+	})
+	if err != nil {
+		return removal, err
+	}
+
+	networks, err := cli.NetworkList(ctx, network.ListOptions{
+		Filters: stackFilter(stackName),
+	})
+	if err != nil {
+		return removal, err
+	}
+
+	secrets, err := cli.SecretList(ctx, types.SecretListOptions{
+		Filters: stackFilter(stackName),
+	})
+	if err != nil {
+		return removal, err
+	}
+
+	configs, err := cli.ConfigList(ctx, types.ConfigListOptions{
+		Filters: stackFilter(stackName),
+	})
+	if err != nil {
+		return removal, err
+	}
+
+	var volumes []*volume.Volume
+	if removeVolumes {
+		list, err := cli.VolumeList(ctx, volume.ListOptions{
+			Filters: stackFilter(stackName),
+		})
+		if err != nil {
+			return removal, err
+		}
+		volumes = list.Volumes
+	}
+
+	if len(services)+len(networks)+len(secrets)+len(configs)+len(volumes) == 0 {
+		return removal, ErrStackNotFound
+	}
+
+	for _, srv := range services {
+		err := cli.ServiceRemove(ctx, srv.ID)
+		removal.Resources = append(removal.Resources, removalResult("service", srv.Spec.Name, err))
+	}
+
+	if len(services) > 0 {
+		if err := waitForTasksToDrain(ctx, cli, stackName); err != nil {
+			if ctx.Err() != nil {
+				return removal, ctx.Err()
+			}
+			removal.Resources = append(removal.Resources, removalResult("tasks", stackName, err))
+		}
+	}
+
+	for _, secret := range secrets {
+		err := cli.SecretRemove(ctx, secret.ID)
+		removal.Resources = append(removal.Resources, removalResult("secret", secret.Spec.Name, err))
+	}
+
+	for _, cfg := range configs {
+		err := cli.ConfigRemove(ctx, cfg.ID)
+		removal.Resources = append(removal.Resources, removalResult("config", cfg.Spec.Name, err))
+	}
+
+	for _, net := range networks {
+		err := cli.NetworkRemove(ctx, net.ID)
+		removal.Resources = append(removal.Resources, removalResult("network", net.Name, err))
+	}
+
+	for _, vol := range volumes {
+		err := cli.VolumeRemove(ctx, vol.Name, false)
+		removal.Resources = append(removal.Resources, removalResult("volume", vol.Name, err))
+	}
+
+	return removal, nil
+}
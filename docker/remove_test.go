@@ -0,0 +1,148 @@
+package docker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/dockrelix/dockrelix-backend/docker"
+)
+
+type MockRemoveClient struct {
+	MockClient
+	removed []string
+	// running keeps the tasks of the stack from draining
+	running bool
+}
+
+func (m *MockRemoveClient) ServiceRemove(ctx context.Context, serviceID string) error {
+	m.removed = append(m.removed, "service:"+serviceID)
+	return nil
+}
+
+func (m *MockRemoveClient) NetworkRemove(ctx context.Context, networkID string) error {
+	return errors.New("network has active endpoints")
+}
+
+func (m *MockRemoveClient) VolumeRemove(ctx context.Context, volumeID string, force bool) error {
+	m.removed = append(m.removed, "volume:"+volumeID)
+	return nil
+}
+
+func (m *MockRemoveClient) SecretRemove(ctx context.Context, id string) error {
+	m.removed = append(m.removed, "secret:"+id)
+	return nil
+}
+
+func (m *MockRemoveClient) ConfigRemove(ctx context.Context, id string) error {
+	m.removed = append(m.removed, "config:"+id)
+	return nil
+}
+
+func (m *MockRemoveClient) TaskList(ctx context.Context, options types.TaskListOptions) ([]swarm.Task, error) {
+	if m.running {
+		return []swarm.Task{{Status: swarm.TaskStatus{State: swarm.TaskStateRunning}}}, nil
+	}
+	return []swarm.Task{{Status: swarm.TaskStatus{State: swarm.TaskStateShutdown}}}, nil
+}
+
+func newMockRemoveClient() *MockRemoveClient {
+	return &MockRemoveClient{
+		MockClient: MockClient{
+			ServiceListFunc: func(ctx context.Context, options types.ServiceListOptions) ([]swarm.Service, error) {
+				return []swarm.Service{{ID: "service_id_1", Spec: swarm.ServiceSpec{Annotations: swarm.Annotations{Name: "test_stack_web"}}}}, nil
+			},
+			NetworkListFunc: func(ctx context.Context, options network.ListOptions) ([]network.Summary, error) {
+				return []network.Summary{{ID: "network_id_1", Name: "test_stack_default"}}, nil
+			},
+			VolumeListFunc: func(ctx context.Context, options volume.ListOptions) (volume.ListResponse, error) {
+				return volume.ListResponse{Volumes: []*volume.Volume{{Name: "test_stack_data"}}}, nil
+			},
+			SecretListFunc: func(ctx context.Context, options types.SecretListOptions) ([]swarm.Secret, error) {
+				return []swarm.Secret{{ID: "secret_id_1", Spec: swarm.SecretSpec{Annotations: swarm.Annotations{Name: "test_stack_token"}}}}, nil
+			},
+			ConfigListFunc: func(ctx context.Context, options types.ConfigListOptions) ([]swarm.Config, error) {
+				return nil, nil
+			},
+		},
+	}
+}
+
+func TestRemoveStack(t *testing.T) {
+	mockClient := newMockRemoveClient()
+
+	removal, err := docker.RemoveStack(context.Background(), mockClient, "test_stack", false)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(removal.Resources) != 3 {
+		t.Fatalf("expected 3 resources, got %d", len(removal.Resources))
+	}
+
+	for _, resource := range removal.Resources {
+		if resource.Type == "network" && (resource.Removed || resource.Error == "") {
+			t.Errorf("expected network removal failure to be reported")
+		}
+		if resource.Type == "volume" {
+			t.Errorf("expected volumes to be kept")
+		}
+	}
+}
+
+func TestRemoveStackWithVolumes(t *testing.T) {
+	mockClient := newMockRemoveClient()
+
+	if _, err := docker.RemoveStack(context.Background(), mockClient, "test_stack", true); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	found := false
+	for _, removed := range mockClient.removed {
+		if removed == "volume:test_stack_data" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected volume to be removed, got %v", mockClient.removed)
+	}
+}
+
+func TestRemoveStackNotFound(t *testing.T) {
+	mockClient := newMockRemoveClient()
+	mockClient.ServiceListFunc = func(ctx context.Context, options types.ServiceListOptions) ([]swarm.Service, error) {
+		return nil, nil
+	}
+	mockClient.NetworkListFunc = func(ctx context.Context, options network.ListOptions) ([]network.Summary, error) {
+		return nil, nil
+	}
+	mockClient.SecretListFunc = func(ctx context.Context, options types.SecretListOptions) ([]swarm.Secret, error) {
+		return nil, nil
+	}
+
+	if _, err := docker.RemoveStack(context.Background(), mockClient, "test_stack", false); !errors.Is(err, docker.ErrStackNotFound) {
+		t.Errorf("expected ErrStackNotFound, got %v", err)
+	}
+}
+
+func TestRemoveStackCanceled(t *testing.T) {
+	mockClient := newMockRemoveClient()
+	mockClient.running = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := docker.RemoveStack(ctx, mockClient, "test_stack", true)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the removal to stop with its context, got %v", err)
+	}
+
+	if len(mockClient.removed) != 1 || mockClient.removed[0] != "service:service_id_1" {
+		t.Errorf("expected only the services to be removed, got %v", mockClient.removed)
+	}
+}
@@ -0,0 +1,172 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dockrelix/dockrelix-backend/database"
+	"github.com/dockrelix/dockrelix-backend/models"
+	"github.com/dockrelix/dockrelix-backend/models/parser"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+)
+
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// revisionAttempts bounds how often a revision is saved again when a concurrent save took its number first.
+const revisionAttempts = 5
+
+func latestRevision(tx *gorm.DB, stackName string) (uint, error) {
+	var latest uint
+	err := tx.Model(&models.StackRevision{}).
+		Where("stack_name = ?", stackName).
+		Select("COALESCE(MAX(revision), 0)").
+		Scan(&latest).Error
+	return latest, err
+}
+
+// SaveDeployRevision records a deploy along with the config it rendered to, which a rollback to it deploys again.
+func SaveDeployRevision(stackName, data string, config parser.ComposeConfig, author, message string) (models.StackRevision, error) {
+	rendered, err := yaml.Marshal(config)
+	if err != nil {
+		return models.StackRevision{}, err
+	}
+	revision := models.StackRevision{
+		StackName: stackName,
+		Kind:      models.RevisionKindDeploy,
+		Data:      data,
+		Rendered:  string(rendered),
+		Author:    author,
+		Message:   message,
+	}
+	if err := saveWithRevision(&revision, nil); err != nil {
+		return models.StackRevision{}, err
+	}
+	return revision, nil
+}
+
+// createRevision stores revision numbered after the latest one of its stack.
+func createRevision(tx *gorm.DB, revision *models.StackRevision) error {
+	latest, err := latestRevision(tx, revision.StackName)
+	if err != nil {
+		return err
+	}
+	revision.Model = gorm.Model{}
+	revision.Revision = latest + 1
+	return tx.Create(revision).Error
+}
+
+// saveWithRevision runs write and records revision in the same transaction, so a change is never stored without its
+// revision or the other way round. A nil revision records nothing. The transaction is run again when a concurrent
+// save took the revision number first.
+func saveWithRevision(revision *models.StackRevision, write func(tx *gorm.DB) error) error {
+	for attempt := 0; attempt < revisionAttempts; attempt++ {
+		conflict := false
+		err := database.DB.Transaction(func(tx *gorm.DB) error {
+			if write != nil {
+				if err := write(tx); err != nil {
+					return err
+				}
+			}
+			if revision == nil {
+				return nil
+			}
+			err := createRevision(tx, revision)
+			conflict = err != nil && isUniqueViolation(err)
+			return err
+		})
+		if !conflict {
+			return err
+		}
+	}
+	return fmt.Errorf("revision could not be numbered after %d attempts", revisionAttempts)
+}
+
+// draftRevision fills in a draft revision of draft from the author and message of revision, which may be nil.
+func draftRevision(revision *models.StackRevision, draft models.StackDraft) *models.StackRevision {
+	if revision == nil {
+		return nil
+	}
+	revision.StackName = draft.Name
+	revision.Kind = models.RevisionKindDraft
+	revision.Data = draft.Data
+	return revision
+}
+
+// RevisionConfig returns the config a deploy revision was deployed with. Revisions recorded without it are rendered
+// again with the current variables of the environment.
+func RevisionConfig(revision models.StackRevision, environment string) (parser.ComposeConfig, error) {
+	if revision.Rendered == "" {
+		variables, err := GetVariables(revision.StackName, environment)
+		if err != nil {
+			return parser.ComposeConfig{}, err
+		}
+		return RenderCompose(revision.Data, variables)
+	}
+
+	var config parser.ComposeConfig
+	if err := yaml.Unmarshal([]byte(revision.Rendered), &config); err != nil {
+		return parser.ComposeConfig{}, fmt.Errorf("revision %d: %w", revision.Revision, err)
+	}
+	return config, nil
+}
+
+// renameRevisions moves the draft history of a stack to a new name, numbered after any history the new name already
+// has. Deploy revisions stay with the stack that was deployed under the old name, which can still be rolled back.
+func renameRevisions(tx *gorm.DB, from, to string) error {
+	latest, err := latestRevision(tx, to)
+	if err != nil {
+		return err
+	}
+	return tx.Model(&models.StackRevision{}).
+		Where("stack_name = ? AND kind = ?", from, models.RevisionKindDraft).
+		Updates(map[string]interface{}{"stack_name": to, "revision": gorm.Expr("revision + ?", latest)}).Error
+}
+
+func GetRevisions(stackName string) []models.StackRevision {
+	var revisions []models.StackRevision
+	database.DB.Where("stack_name = ?", stackName).Order("revision desc").Find(&revisions)
+	return revisions
+}
+
+func GetRevision(stackName string, revision uint) (models.StackRevision, error) {
+	var result models.StackRevision
+	err := database.DB.Where("stack_name = ? AND revision = ?", stackName, revision).First(&result).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return result, ErrRevisionNotFound
+	}
+	return result, err
+}
+
+// DiffRevisions renders both revisions with the current default variables of the stack and compares them.
+func DiffRevisions(from, to models.StackRevision) (models.StackDiff, error) {
+	variables, err := GetVariables(to.StackName, "")
+	if err != nil {
+		return models.StackDiff{}, err
+	}
+
+	current, err := RenderCompose(from.Data, variables)
+	if err != nil {
+		return models.StackDiff{}, fmt.Errorf("revision %d: %w", from.Revision, err)
+	}
+	desired, err := RenderCompose(to.Data, variables)
+	if err != nil {
+		return models.StackDiff{}, fmt.Errorf("revision %d: %w", to.Revision, err)
+	}
+
+	return DiffDrafts(to.StackName, desired, current), nil
+}
+
+// RestoreRevision makes the data of a revision the current draft, creating the draft if it was deleted.
+func RestoreRevision(revision models.StackRevision, author string) (models.StackDraft, error) {
+	restored := &models.StackRevision{Author: author, Message: fmt.Sprintf("Restored revision %d", revision.Revision)}
+
+	draft, err := GetDraft(revision.StackName)
+	switch {
+	case errors.Is(err, ErrDraftNotFound):
+		return SaveDraft(models.StackDraft{Name: revision.StackName, Data: revision.Data}, restored)
+	case err != nil:
+		return models.StackDraft{}, err
+	}
+	return UpdateDraft(draft.Name, draft.Version, models.StackDraft{Data: revision.Data}, restored)
+}
@@ -0,0 +1,108 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/dockrelix/dockrelix-backend/database"
+	"github.com/dockrelix/dockrelix-backend/models"
+	"github.com/dockrelix/dockrelix-backend/models/parser"
+)
+
+// PreviousDeployRevision returns the deploy revision before the one currently running.
+func PreviousDeployRevision(stackName string) (models.StackRevision, error) {
+	var revisions []models.StackRevision
+	err := database.DB.Where("stack_name = ? AND kind = ?", stackName, models.RevisionKindDeploy).
+		Order("revision desc").
+		Limit(2).
+		Find(&revisions).Error
+	if err != nil {
+		return models.StackRevision{}, err
+	}
+	if len(revisions) < 2 {
+		return models.StackRevision{}, ErrRevisionNotFound
+	}
+	return revisions[1], nil
+}
+
+// PruneServices removes the services of a stack that config does not define, so rolling back to a revision also
+// drops the services added after it. It returns the names of the removed services.
+func PruneServices(cli RemoveClient, stackName string, config parser.ComposeConfig) ([]string, error) {
+	services, err := cli.ServiceList(context.Background(), types.ServiceListOptions{
+		Filters: stackFilter(stackName),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	removed := []string{}
+	for _, srv := range services {
+		name := RemoveStackFromName(srv.Spec.Name, stackName)
+		if _, ok := config.Services[name]; ok {
+			continue
+		}
+		if err := cli.ServiceRemove(context.Background(), srv.ID); err != nil {
+			return removed, fmt.Errorf("service %q: %w", name, err)
+		}
+		removed = append(removed, name)
+	}
+	return removed, nil
+}
+
+// RollbackServices asks swarm to roll services back to their previous spec, honouring each service's rollback_config.
+// An empty list of services rolls back the whole stack.
+func RollbackServices(cli DeployClient, stackName string, names []string) ([]models.ServiceRollback, error) {
+	services, err := cli.ServiceList(context.Background(), types.ServiceListOptions{
+		Filters: stackFilter(stackName),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(services) == 0 {
+		return nil, ErrStackNotFound
+	}
+
+	selected := map[string]bool{}
+	for _, name := range names {
+		selected[name] = true
+	}
+	for _, srv := range services {
+		delete(selected, RemoveStackFromName(srv.Spec.Name, stackName))
+	}
+	for name := range selected {
+		return nil, fmt.Errorf("%w: %s is not part of stack %s", ErrServiceNotFound, name, stackName)
+	}
+
+	wanted := map[string]bool{}
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	results := []models.ServiceRollback{}
+	for _, srv := range services {
+		name := RemoveStackFromName(srv.Spec.Name, stackName)
+		if len(wanted) > 0 && !wanted[name] {
+			continue
+		}
+
+		result := models.ServiceRollback{Name: name}
+		if srv.PreviousSpec == nil {
+			result.Error = "service has no previous version"
+			results = append(results, result)
+			continue
+		}
+
+		_, err := cli.ServiceUpdate(context.Background(), srv.ID, srv.Version, srv.Spec, types.ServiceUpdateOptions{
+			Rollback: "previous",
+		})
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.RolledBack = true
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
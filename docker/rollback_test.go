@@ -0,0 +1,153 @@
+package docker_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/dockrelix/dockrelix-backend/database"
+	"github.com/dockrelix/dockrelix-backend/docker"
+	"github.com/dockrelix/dockrelix-backend/models/parser"
+)
+
+type MockDeployClient struct {
+	MockClient
+	updates map[string]types.ServiceUpdateOptions
+}
+
+func (m *MockDeployClient) NetworkCreate(ctx context.Context, name string, options network.CreateOptions) (network.CreateResponse, error) {
+	return network.CreateResponse{}, nil
+}
+
+func (m *MockDeployClient) ServiceCreate(ctx context.Context, service swarm.ServiceSpec, options types.ServiceCreateOptions) (swarm.ServiceCreateResponse, error) {
+	return swarm.ServiceCreateResponse{}, nil
+}
+
+func (m *MockDeployClient) ServiceUpdate(ctx context.Context, serviceID string, version swarm.Version, service swarm.ServiceSpec, options types.ServiceUpdateOptions) (swarm.ServiceUpdateResponse, error) {
+	m.updates[serviceID] = options
+	return swarm.ServiceUpdateResponse{}, nil
+}
+
+func (m *MockDeployClient) SecretCreate(ctx context.Context, secret swarm.SecretSpec) (types.SecretCreateResponse, error) {
+	return types.SecretCreateResponse{ID: secret.Name + "_id"}, nil
+}
+
+func (m *MockDeployClient) ConfigCreate(ctx context.Context, config swarm.ConfigSpec) (types.ConfigCreateResponse, error) {
+	return types.ConfigCreateResponse{ID: config.Name + "_id"}, nil
+}
+
+func newMockDeployClient(services []swarm.Service) *MockDeployClient {
+	return &MockDeployClient{
+		MockClient: MockClient{
+			ServiceListFunc: func(ctx context.Context, options types.ServiceListOptions) ([]swarm.Service, error) {
+				return services, nil
+			},
+		},
+		updates: map[string]types.ServiceUpdateOptions{},
+	}
+}
+
+func TestRollbackServices(t *testing.T) {
+	mockClient := newMockDeployClient([]swarm.Service{
+		{
+			ID:           "service_id_1",
+			Spec:         swarm.ServiceSpec{Annotations: swarm.Annotations{Name: "test_stack_web"}},
+			PreviousSpec: &swarm.ServiceSpec{Annotations: swarm.Annotations{Name: "test_stack_web"}},
+		},
+		{
+			ID:   "service_id_2",
+			Spec: swarm.ServiceSpec{Annotations: swarm.Annotations{Name: "test_stack_worker"}},
+		},
+	})
+
+	results, err := docker.RollbackServices(mockClient, "test_stack", nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+
+	if !results[0].RolledBack || mockClient.updates["service_id_1"].Rollback != "previous" {
+		t.Errorf("expected web to be rolled back, got %+v", results[0])
+	}
+
+	if results[1].RolledBack || results[1].Error == "" {
+		t.Errorf("expected worker without previous spec to fail, got %+v", results[1])
+	}
+}
+
+func TestRollbackServicesUnknownService(t *testing.T) {
+	mockClient := newMockDeployClient([]swarm.Service{
+		{ID: "service_id_1", Spec: swarm.ServiceSpec{Annotations: swarm.Annotations{Name: "test_stack_web"}}},
+	})
+
+	_, err := docker.RollbackServices(mockClient, "test_stack", []string{"api"})
+	if !errors.Is(err, docker.ErrServiceNotFound) {
+		t.Errorf("expected ErrServiceNotFound, got %v", err)
+	}
+
+	if len(mockClient.updates) != 0 {
+		t.Errorf("expected no service to be updated")
+	}
+}
+
+func TestPruneServices(t *testing.T) {
+	mockClient := newMockRemoveClient()
+	mockClient.ServiceListFunc = func(ctx context.Context, options types.ServiceListOptions) ([]swarm.Service, error) {
+		return []swarm.Service{
+			{ID: "service_id_1", Spec: swarm.ServiceSpec{Annotations: swarm.Annotations{Name: "test_stack_web"}}},
+			{ID: "service_id_2", Spec: swarm.ServiceSpec{Annotations: swarm.Annotations{Name: "test_stack_worker"}}},
+		}, nil
+	}
+
+	config := parser.ComposeConfig{Services: map[string]parser.Service{"web": {Image: "nginx"}}}
+	removed, err := docker.PruneServices(mockClient, "test_stack", config)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(removed) != 1 || removed[0] != "worker" || len(mockClient.removed) != 1 || mockClient.removed[0] != "service:service_id_2" {
+		t.Errorf("expected only worker to be removed, got %v, %v", removed, mockClient.removed)
+	}
+}
+
+func TestRevisionConfig(t *testing.T) {
+	database.InitDBForTesting()
+
+	data := "services:\n  web:\n    image: nginx:${TAG}\n    environment:\n      MODE: $${MODE}\n"
+	config, err := docker.RenderCompose(data, map[string]string{"TAG": "1.25"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	revision, err := docker.SaveDeployRevision("test_stack", data, config, "admin", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := docker.SetVariables("test_stack", "", map[string]string{"TAG": "1.27"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	restored, err := docker.RevisionConfig(revision, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(restored, config) {
+		t.Errorf("expected the deployed config regardless of the current variables, got %+v", restored)
+	}
+
+	revision.Rendered = ""
+	rerendered, err := docker.RevisionConfig(revision, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rerendered.Services["web"].Image != "nginx:1.27" {
+		t.Errorf("expected a revision without its config to be rendered again, got %+v", rerendered.Services["web"])
+	}
+}
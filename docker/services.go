@@ -0,0 +1,297 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/errdefs"
+	"github.com/dockrelix/dockrelix-backend/models"
+)
+
+var (
+	ErrServiceNotScalable = errors.New("only replicated services can be scaled")
+	ErrInvalidImage       = errors.New("invalid image reference")
+)
+
+// updateAttempts bounds how often an update is retried when the service changed between reading and writing it.
+const updateAttempts = 5
+
+// taskPollInterval is how often tasks are listed while waiting for a service to converge.
+var taskPollInterval = time.Second
+
+// ServiceClient adds the calls needed to change a single service and follow its tasks
+type ServiceClient interface {
+	ServiceInspectWithRaw(ctx context.Context, serviceID string, options types.ServiceInspectOptions) (swarm.Service, []byte, error)
+	ServiceUpdate(ctx context.Context, serviceID string, version swarm.Version, service swarm.ServiceSpec, options types.ServiceUpdateOptions) (swarm.ServiceUpdateResponse, error)
+	TaskList(ctx context.Context, options types.TaskListOptions) ([]swarm.Task, error)
+}
+
+// serviceInspector is the part of the clients needed to look a service up
+type serviceInspector interface {
+	ServiceInspectWithRaw(ctx context.Context, serviceID string, options types.ServiceInspectOptions) (swarm.Service, []byte, error)
+}
+
+func isOutOfSequence(err error) bool {
+	return strings.Contains(err.Error(), "update out of sequence")
+}
+
+// getStackService looks a service up by its name within the stack.
+func getStackService(cli serviceInspector, stackName, name string) (swarm.Service, error) {
+	srv, _, err := cli.ServiceInspectWithRaw(context.Background(), AddStackToName(name, stackName), types.ServiceInspectOptions{})
+	if errdefs.IsNotFound(err) || err == nil && !isStackResource(srv.Spec.Labels, stackName) {
+		return swarm.Service{}, fmt.Errorf("%w: %s is not part of stack %s", ErrServiceNotFound, name, stackName)
+	}
+	return srv, err
+}
+
+// updateService applies change to the current spec of a service and sends the update with its version index,
+// reading the service again and retrying when it was updated by someone else in between.
+func updateService(cli ServiceClient, stackName, name string, options types.ServiceUpdateOptions, change func(srv *swarm.Service) error) (swarm.Service, []string, error) {
+	var err error
+	for attempt := 0; attempt < updateAttempts; attempt++ {
+		var srv swarm.Service
+		if srv, err = getStackService(cli, stackName, name); err != nil {
+			return swarm.Service{}, nil, err
+		}
+		if err = change(&srv); err != nil {
+			return swarm.Service{}, nil, err
+		}
+
+		var response swarm.ServiceUpdateResponse
+		response, err = cli.ServiceUpdate(context.Background(), srv.ID, srv.Version, srv.Spec, options)
+		if err == nil {
+			return srv, response.Warnings, nil
+		}
+		if !isOutOfSequence(err) {
+			return swarm.Service{}, nil, err
+		}
+	}
+	return swarm.Service{}, nil, err
+}
+
+// runningTasks counts the tasks of a service that are running, it also reports whether exactly the wanted number
+// of tasks is meant to run, so a service scaling down is not taken as converged too early.
+func runningTasks(cli ServiceClient, serviceID string, replicas uint64) (uint64, bool, error) {
+	tasks, err := cli.TaskList(context.Background(), types.TaskListOptions{
+		Filters: filters.NewArgs(filters.Arg("service", serviceID), filters.Arg("desired-state", "running")),
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	var running uint64
+	for _, task := range tasks {
+		if task.Status.State == swarm.TaskStateRunning {
+			running++
+		}
+	}
+	return running, running == replicas && uint64(len(tasks)) == replicas, nil
+}
+
+// waitForReplicas polls the tasks of a service until the wanted number of them runs or ctx is done,
+// it returns the number of running tasks and whether the service converged.
+func waitForReplicas(ctx context.Context, cli ServiceClient, serviceID string, replicas uint64) (uint64, bool, error) {
+	ticker := time.NewTicker(taskPollInterval)
+	defer ticker.Stop()
+
+	for {
+		running, converged, err := runningTasks(cli, serviceID, replicas)
+		if err != nil || converged {
+			return running, converged, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return running, false, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// ScaleService sets the number of replicas of a replicated service. A positive wait blocks until that many tasks
+// are running, the wait is over or ctx is done, in which case the result holds the number of running tasks.
+func ScaleService(ctx context.Context, cli ServiceClient, stackName, name string, replicas uint64, wait time.Duration) (models.ServiceScale, bool, error) {
+	result := models.ServiceScale{Name: name, Replicas: replicas}
+
+	srv, _, err := updateService(cli, stackName, name, types.ServiceUpdateOptions{}, func(srv *swarm.Service) error {
+		mode := srv.Spec.Mode.Replicated
+		if mode == nil {
+			return fmt.Errorf("%w: %s runs in %s mode", ErrServiceNotScalable, name, getServiceMode(*srv))
+		}
+		if mode.Replicas != nil {
+			result.Previous = *mode.Replicas
+		}
+		srv.Spec.Mode.Replicated = &swarm.ReplicatedService{Replicas: &replicas}
+		return nil
+	})
+	if err != nil {
+		return result, false, err
+	}
+
+	if wait <= 0 {
+		return result, true, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	running, converged, err := waitForReplicas(ctx, cli, srv.ID, replicas)
+	if err != nil {
+		return result, false, err
+	}
+	result.Running = &running
+	return result, converged, nil
+}
+
+func serviceUpdateResult(name string, srv swarm.Service, warnings []string) models.ServiceUpdate {
+	result := models.ServiceUpdate{Name: name, ForceUpdate: srv.Spec.TaskTemplate.ForceUpdate, Warnings: warnings}
+	if srv.Spec.TaskTemplate.ContainerSpec != nil {
+		result.Image = srv.Spec.TaskTemplate.ContainerSpec.Image
+	}
+	return result
+}
+
+// RestartService replaces every task of a service by bumping its force update counter, the tasks are replaced
+// following the update config of the service like any other update.
+func RestartService(cli ServiceClient, stackName, name string) (models.ServiceUpdate, swarm.Service, error) {
+	srv, warnings, err := updateService(cli, stackName, name, types.ServiceUpdateOptions{}, func(srv *swarm.Service) error {
+		srv.Spec.TaskTemplate.ForceUpdate++
+		return nil
+	})
+	if err != nil {
+		return models.ServiceUpdate{}, srv, err
+	}
+	return serviceUpdateResult(name, srv, warnings), srv, nil
+}
+
+// UpdateServiceImage rolls a service out with another image. With resolve set the daemon pins the image to the digest
+// it currently points to in the registry, so every node runs the same image.
+func UpdateServiceImage(cli ServiceClient, stackName, name, image string, resolve bool) (models.ServiceUpdate, swarm.Service, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return models.ServiceUpdate{}, swarm.Service{}, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	image = reference.FamiliarString(named)
+
+	srv, warnings, err := updateService(cli, stackName, name, types.ServiceUpdateOptions{QueryRegistry: resolve}, func(srv *swarm.Service) error {
+		if srv.Spec.TaskTemplate.ContainerSpec == nil {
+			return fmt.Errorf("%w: %s does not run a container", ErrInvalidImage, name)
+		}
+		srv.Spec.TaskTemplate.ContainerSpec.Image = image
+		return nil
+	})
+	if err != nil {
+		return models.ServiceUpdate{}, srv, err
+	}
+	return serviceUpdateResult(name, srv, warnings), srv, nil
+}
+
+// updateDone lists the update states after which swarm takes no further steps on its own.
+var updateDone = []swarm.UpdateState{
+	swarm.UpdateStateCompleted,
+	swarm.UpdateStatePaused,
+	swarm.UpdateStateRollbackCompleted,
+	swarm.UpdateStateRollbackPaused,
+}
+
+// isUpToDate tells whether a task runs what the service spec asks for as far as a restart or an image update goes,
+// which is its force update counter and its image.
+func isUpToDate(task swarm.Task, spec swarm.TaskSpec) bool {
+	if task.Spec.ForceUpdate != spec.ForceUpdate {
+		return false
+	}
+	if task.Spec.ContainerSpec == nil || spec.ContainerSpec == nil {
+		return task.Spec.ContainerSpec == spec.ContainerSpec
+	}
+	return task.Spec.ContainerSpec.Image == spec.ContainerSpec.Image
+}
+
+// sameUpdate tells whether two update statuses describe the same update.
+func sameUpdate(a, b *swarm.UpdateStatus) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.StartedAt == nil || b.StartedAt == nil {
+		return a.StartedAt == b.StartedAt && a.State == b.State
+	}
+	return a.StartedAt.Equal(*b.StartedAt)
+}
+
+// serviceUpdateProgress reads the update status of a service along with its tasks and compares them to before, the
+// service as it was read before the update. Everything is judged by the versions and statuses swarm keeps, so the
+// clock of this host plays no part. An update that left the tasks as they were starts no rollout and is done at once.
+func serviceUpdateProgress(cli ServiceClient, before swarm.Service) (models.ServiceUpdateProgress, error) {
+	srv, _, err := cli.ServiceInspectWithRaw(context.Background(), before.ID, types.ServiceInspectOptions{})
+	if err != nil {
+		return models.ServiceUpdateProgress{}, err
+	}
+	tasks, err := cli.TaskList(context.Background(), types.TaskListOptions{
+		Filters: filters.NewArgs(filters.Arg("service", before.ID), filters.Arg("desired-state", "running")),
+	})
+	if err != nil {
+		return models.ServiceUpdateProgress{}, err
+	}
+
+	progress := models.ServiceUpdateProgress{State: "pending", Desired: uint64(len(tasks))}
+	if replicated := srv.Spec.Mode.Replicated; replicated != nil && replicated.Replicas != nil {
+		progress.Desired = *replicated.Replicas
+	}
+	for _, task := range tasks {
+		if task.Status.State != swarm.TaskStateRunning {
+			continue
+		}
+		progress.Running++
+		if isUpToDate(task, srv.Spec.TaskTemplate) {
+			progress.Replaced++
+		}
+	}
+
+	status := srv.UpdateStatus
+	switch {
+	case status != nil && !sameUpdate(status, before.UpdateStatus):
+		progress.State = string(status.State)
+		progress.Message = status.Message
+		progress.Done = slices.Contains(updateDone, status.State)
+	case srv.Version.Index > before.Version.Index && progress.Replaced == progress.Running && progress.Running == progress.Desired &&
+		uint64(len(tasks)) == progress.Desired:
+		progress.State = string(swarm.UpdateStateCompleted)
+		progress.Message = "no update was needed, the tasks already run the service spec"
+		progress.Done = true
+	}
+	return progress, nil
+}
+
+// FollowServiceUpdate reports the progress of the update made to before, the service as it was read before the
+// update, each time it changes, until swarm is done with it or ctx is done.
+func FollowServiceUpdate(ctx context.Context, cli ServiceClient, before swarm.Service, report func(models.ServiceUpdateProgress)) error {
+	ticker := time.NewTicker(taskPollInterval)
+	defer ticker.Stop()
+
+	var last models.ServiceUpdateProgress
+	for {
+		progress, err := serviceUpdateProgress(cli, before)
+		if err != nil {
+			return err
+		}
+		if progress != last {
+			report(progress)
+			last = progress
+		}
+		if progress.Done {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
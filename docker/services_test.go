@@ -0,0 +1,260 @@
+package docker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/errdefs"
+	"github.com/dockrelix/dockrelix-backend/docker"
+	"github.com/dockrelix/dockrelix-backend/models"
+)
+
+type MockServiceClient struct {
+	services map[string]swarm.Service
+	tasks    []swarm.Task
+	// conflicts is the number of updates rejected as out of sequence before one goes through
+	conflicts int
+	updates   []swarm.Version
+	options   types.ServiceUpdateOptions
+}
+
+func (m *MockServiceClient) ServiceInspectWithRaw(ctx context.Context, serviceID string, options types.ServiceInspectOptions) (swarm.Service, []byte, error) {
+	srv, ok := m.services[serviceID]
+	for _, service := range m.services {
+		if service.ID == serviceID {
+			srv, ok = service, true
+		}
+	}
+	if !ok {
+		return swarm.Service{}, nil, errdefs.NotFound(errors.New("service " + serviceID + " not found"))
+	}
+	return srv, nil, nil
+}
+
+func (m *MockServiceClient) ServiceUpdate(ctx context.Context, serviceID string, version swarm.Version, service swarm.ServiceSpec, options types.ServiceUpdateOptions) (swarm.ServiceUpdateResponse, error) {
+	m.updates = append(m.updates, version)
+	m.options = options
+	if m.conflicts > 0 {
+		m.conflicts--
+		srv := m.services[service.Name]
+		srv.Version.Index++
+		m.services[service.Name] = srv
+		return swarm.ServiceUpdateResponse{}, errors.New("rpc error: code = Unknown desc = update out of sequence")
+	}
+
+	srv := m.services[service.Name]
+	srv.Spec = service
+	srv.Version.Index++
+	m.services[service.Name] = srv
+	return swarm.ServiceUpdateResponse{}, nil
+}
+
+func (m *MockServiceClient) TaskList(ctx context.Context, options types.TaskListOptions) ([]swarm.Task, error) {
+	return m.tasks, nil
+}
+
+func newMockServiceClient(mode swarm.ServiceMode) *MockServiceClient {
+	return &MockServiceClient{
+		services: map[string]swarm.Service{
+			"test_stack_web": {
+				ID:   "service_id",
+				Meta: swarm.Meta{Version: swarm.Version{Index: 10}},
+				Spec: swarm.ServiceSpec{
+					Annotations: swarm.Annotations{
+						Name:   "test_stack_web",
+						Labels: map[string]string{"com.docker.stack.namespace": "test_stack"},
+					},
+					Mode: mode,
+					TaskTemplate: swarm.TaskSpec{
+						ContainerSpec: &swarm.ContainerSpec{Image: "nginx:1.27"},
+						ForceUpdate:   3,
+					},
+				},
+			},
+		},
+	}
+}
+
+func replicated(replicas uint64) swarm.ServiceMode {
+	return swarm.ServiceMode{Replicated: &swarm.ReplicatedService{Replicas: &replicas}}
+}
+
+func TestScaleService(t *testing.T) {
+	mockClient := newMockServiceClient(replicated(2))
+	mockClient.conflicts = 1
+
+	result, converged, err := docker.ScaleService(context.Background(), mockClient, "test_stack", "web", 5, 0)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if !converged || result.Previous != 2 || result.Replicas != 5 || result.Running != nil {
+		t.Errorf("unexpected result %+v, converged %v", result, converged)
+	}
+
+	if len(mockClient.updates) != 2 || mockClient.updates[1].Index != 11 {
+		t.Errorf("expected a retry with the new version index, got %+v", mockClient.updates)
+	}
+
+	if replicas := *mockClient.services["test_stack_web"].Spec.Mode.Replicated.Replicas; replicas != 5 {
+		t.Errorf("expected 5 replicas, got %d", replicas)
+	}
+}
+
+func TestScaleServiceWait(t *testing.T) {
+	mockClient := newMockServiceClient(replicated(1))
+	mockClient.tasks = []swarm.Task{
+		{Status: swarm.TaskStatus{State: swarm.TaskStateRunning}},
+		{Status: swarm.TaskStatus{State: swarm.TaskStateRunning}},
+	}
+
+	result, converged, err := docker.ScaleService(context.Background(), mockClient, "test_stack", "web", 2, time.Second)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !converged || result.Running == nil || *result.Running != 2 {
+		t.Errorf("expected 2 running tasks, got %+v", result)
+	}
+
+	mockClient.tasks[1].Status.State = swarm.TaskStatePreparing
+	result, converged, err = docker.ScaleService(context.Background(), mockClient, "test_stack", "web", 2, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if converged || *result.Running != 1 {
+		t.Errorf("expected the wait to time out with 1 running task, got %+v", result)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	result, converged, err = docker.ScaleService(ctx, mockClient, "test_stack", "web", 2, time.Minute)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if converged || *result.Running != 1 || time.Since(start) > time.Second {
+		t.Errorf("expected the wait to end with the request, got %+v", result)
+	}
+}
+
+func TestScaleServiceRejected(t *testing.T) {
+	mockClient := newMockServiceClient(swarm.ServiceMode{Global: &swarm.GlobalService{}})
+
+	if _, _, err := docker.ScaleService(context.Background(), mockClient, "test_stack", "web", 3, 0); !errors.Is(err, docker.ErrServiceNotScalable) {
+		t.Errorf("expected ErrServiceNotScalable, got %v", err)
+	}
+	if len(mockClient.updates) != 0 {
+		t.Errorf("expected no update, got %+v", mockClient.updates)
+	}
+
+	if _, _, err := docker.ScaleService(context.Background(), mockClient, "test_stack", "db", 3, 0); !errors.Is(err, docker.ErrServiceNotFound) {
+		t.Errorf("expected ErrServiceNotFound, got %v", err)
+	}
+	if _, _, err := docker.ScaleService(context.Background(), mockClient, "other_stack", "web", 3, 0); !errors.Is(err, docker.ErrServiceNotFound) {
+		t.Errorf("expected ErrServiceNotFound for another stack, got %v", err)
+	}
+}
+
+func TestRestartService(t *testing.T) {
+	mockClient := newMockServiceClient(replicated(2))
+
+	result, _, err := docker.RestartService(mockClient, "test_stack", "web")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if result.ForceUpdate != 4 || mockClient.services["test_stack_web"].Spec.TaskTemplate.ForceUpdate != 4 {
+		t.Errorf("expected force update to be bumped to 4, got %+v", result)
+	}
+}
+
+func TestUpdateServiceImage(t *testing.T) {
+	mockClient := newMockServiceClient(replicated(2))
+
+	if _, _, err := docker.UpdateServiceImage(mockClient, "test_stack", "web", "Not An Image", false); !errors.Is(err, docker.ErrInvalidImage) {
+		t.Errorf("expected ErrInvalidImage, got %v", err)
+	}
+
+	result, _, err := docker.UpdateServiceImage(mockClient, "test_stack", "web", "docker.io/library/nginx:1.28", true)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if result.Image != "nginx:1.28" || mockClient.services["test_stack_web"].Spec.TaskTemplate.ContainerSpec.Image != "nginx:1.28" {
+		t.Errorf("expected image nginx:1.28, got %+v", result)
+	}
+	if !mockClient.options.QueryRegistry {
+		t.Error("expected the image to be resolved by the registry")
+	}
+}
+
+func TestFollowServiceUpdate(t *testing.T) {
+	earlier, later := time.Now().Add(-time.Hour), time.Now()
+
+	mockClient := newMockServiceClient(replicated(2))
+	srv := mockClient.services["test_stack_web"]
+	srv.UpdateStatus = &swarm.UpdateStatus{State: swarm.UpdateStateCompleted, StartedAt: &earlier, Message: "update completed"}
+	mockClient.services["test_stack_web"] = srv
+
+	_, before, err := docker.RestartService(mockClient, "test_stack", "web")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	srv = mockClient.services["test_stack_web"]
+	srv.UpdateStatus = &swarm.UpdateStatus{State: swarm.UpdateStateCompleted, StartedAt: &later, Message: "update completed"}
+	mockClient.services["test_stack_web"] = srv
+	mockClient.tasks = []swarm.Task{
+		{Spec: swarm.TaskSpec{ContainerSpec: &swarm.ContainerSpec{Image: "nginx:1.27"}, ForceUpdate: 4}, Status: swarm.TaskStatus{State: swarm.TaskStateRunning}},
+		{Spec: swarm.TaskSpec{ContainerSpec: &swarm.ContainerSpec{Image: "nginx:1.27"}, ForceUpdate: 3}, Status: swarm.TaskStatus{State: swarm.TaskStateRunning}},
+	}
+
+	var reports []models.ServiceUpdateProgress
+	err = docker.FollowServiceUpdate(context.Background(), mockClient, before, func(progress models.ServiceUpdateProgress) {
+		reports = append(reports, progress)
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	expected := models.ServiceUpdateProgress{State: "completed", Message: "update completed", Replaced: 1, Running: 2, Desired: 2, Done: true}
+	if len(reports) != 1 || reports[0] != expected {
+		t.Errorf("expected %+v, got %+v", expected, reports)
+	}
+}
+
+func TestFollowServiceUpdateWithoutChanges(t *testing.T) {
+	earlier := time.Now().Add(-time.Hour)
+
+	mockClient := newMockServiceClient(replicated(2))
+	srv := mockClient.services["test_stack_web"]
+	srv.UpdateStatus = &swarm.UpdateStatus{State: swarm.UpdateStateCompleted, StartedAt: &earlier, Message: "update completed"}
+	mockClient.services["test_stack_web"] = srv
+	mockClient.tasks = []swarm.Task{
+		{Spec: swarm.TaskSpec{ContainerSpec: &swarm.ContainerSpec{Image: "nginx:1.27"}, ForceUpdate: 3}, Status: swarm.TaskStatus{State: swarm.TaskStateRunning}},
+		{Spec: swarm.TaskSpec{ContainerSpec: &swarm.ContainerSpec{Image: "nginx:1.27"}, ForceUpdate: 3}, Status: swarm.TaskStatus{State: swarm.TaskStateRunning}},
+	}
+
+	_, before, err := docker.UpdateServiceImage(mockClient, "test_stack", "web", "nginx:1.27", false)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var reports []models.ServiceUpdateProgress
+	err = docker.FollowServiceUpdate(ctx, mockClient, before, func(progress models.ServiceUpdateProgress) {
+		reports = append(reports, progress)
+	})
+	if err != nil {
+		t.Fatalf("expected the stream to end at once, got %v", err)
+	}
+
+	if len(reports) != 1 || !reports[0].Done || reports[0].Replaced != 2 {
+		t.Errorf("expected a single done report, got %+v", reports)
+	}
+}
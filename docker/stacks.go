@@ -2,18 +2,23 @@ package docker
 
 import (
 	"context"
+	"errors"
 	"log"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
-	"github.com/dockrelix/dockrelix-backend/database"
 	"github.com/dockrelix/dockrelix-backend/models"
 )
 
+var (
+	ErrStackNotFound   = errors.New("stack not found")
+	ErrServiceNotFound = errors.New("service not found")
+)
+
 func ListStacks(cli *client.Client) []models.Stack {
-	services, err := cli.ServiceList(context.Background(), types.ServiceListOptions{})
+	services, err := cli.ServiceList(context.Background(), types.ServiceListOptions{Status: true})
 	if err != nil {
 		log.Fatalf("Error fetching services: %v", err)
 	}
@@ -55,6 +60,13 @@ func ListStacks(cli *client.Client) []models.Stack {
 			serviceData.Replicas = &replicas
 		}
 
+		if service.ServiceStatus != nil {
+			serviceData.Running = service.ServiceStatus.RunningTasks
+			serviceData.Desired = service.ServiceStatus.DesiredTasks
+			stacks[stackName].Running += serviceData.Running
+			stacks[stackName].Desired += serviceData.Desired
+		}
+
 		if service.Endpoint.Ports != nil {
 			for _, port := range service.Endpoint.Ports {
 				portData := models.PortData{
@@ -108,17 +120,3 @@ func ListStacks(cli *client.Client) []models.Stack {
 
 	return result
 }
-
-func SaveDraft(stackDraft models.StackDraft) error {
-	if err := database.DB.Create(&models.StackDraft{Name: stackDraft.Name, Data: stackDraft.Data}).Error; err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func GetDrafts() []models.StackDraft {
-	var drafts []models.StackDraft
-	database.DB.Find(&drafts)
-	return drafts
-}
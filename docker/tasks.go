@@ -0,0 +1,110 @@
+package docker
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/dockrelix/dockrelix-backend/models"
+)
+
+// TaskClient adds the calls needed to list the tasks of a stack and the nodes they were scheduled on
+type TaskClient interface {
+	DockerClient
+	TaskList(ctx context.Context, options types.TaskListOptions) ([]swarm.Task, error)
+	NodeList(ctx context.Context, options types.NodeListOptions) ([]swarm.Node, error)
+}
+
+// exitedStates lists the task states in which the container has exited and its exit code means something.
+var exitedStates = []swarm.TaskState{swarm.TaskStateComplete, swarm.TaskStateFailed, swarm.TaskStateShutdown}
+
+func taskData(task swarm.Task, service string, nodes map[string]string) models.TaskData {
+	data := models.TaskData{
+		ID:           task.ID,
+		Service:      service,
+		Slot:         task.Slot,
+		NodeID:       task.NodeID,
+		Node:         nodes[task.NodeID],
+		DesiredState: string(task.DesiredState),
+		State:        string(task.Status.State),
+		Message:      task.Status.Message,
+		Error:        task.Status.Err,
+		CreatedAt:    task.CreatedAt,
+		UpdatedAt:    task.UpdatedAt,
+		Timestamp:    task.Status.Timestamp,
+	}
+	if task.Spec.ContainerSpec != nil {
+		data.Image = task.Spec.ContainerSpec.Image
+	}
+	if status := task.Status.ContainerStatus; status != nil {
+		data.ContainerID = status.ContainerID
+		if slices.Contains(exitedStates, task.Status.State) {
+			exitCode := status.ExitCode
+			data.ExitCode = &exitCode
+		}
+	}
+	return data
+}
+
+// ListTasks lists the tasks of a stack, or of one of its services when service is set, along with the node each
+// runs on. Tasks are ordered by service and slot, the newest first, the same as `docker stack ps`.
+// With running set only the tasks meant to be running are listed, leaving out the history of each slot.
+func ListTasks(cli TaskClient, stackName, service string, running bool) ([]models.TaskData, error) {
+	services, err := cli.ServiceList(context.Background(), types.ServiceListOptions{
+		Filters: stackFilter(stackName),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(services) == 0 {
+		return nil, ErrStackNotFound
+	}
+
+	names := map[string]string{}
+	filter := stackFilter(stackName)
+	for _, srv := range services {
+		name := RemoveStackFromName(srv.Spec.Name, stackName)
+		names[srv.ID] = name
+		if name == service {
+			filter.Add("service", srv.ID)
+		}
+	}
+	if service != "" && !filter.Contains("service") {
+		return nil, fmt.Errorf("%w: %s is not part of stack %s", ErrServiceNotFound, service, stackName)
+	}
+	if running {
+		filter.Add("desired-state", string(swarm.TaskStateRunning))
+	}
+
+	tasks, err := cli.TaskList(context.Background(), types.TaskListOptions{Filters: filter})
+	if err != nil {
+		return nil, err
+	}
+
+	nodeList, err := cli.NodeList(context.Background(), types.NodeListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	nodes := map[string]string{}
+	for _, node := range nodeList {
+		nodes[node.ID] = node.Description.Hostname
+	}
+
+	result := make([]models.TaskData, 0, len(tasks))
+	for _, task := range tasks {
+		result = append(result, taskData(task, names[task.ServiceID], nodes))
+	}
+
+	slices.SortStableFunc(result, func(a, b models.TaskData) int {
+		return cmp.Or(
+			cmp.Compare(a.Service, b.Service),
+			cmp.Compare(a.Slot, b.Slot),
+			cmp.Compare(a.Node, b.Node),
+			b.CreatedAt.Compare(a.CreatedAt),
+		)
+	})
+	return result, nil
+}
@@ -0,0 +1,86 @@
+package docker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/dockrelix/dockrelix-backend/docker"
+)
+
+type MockTaskClient struct {
+	MockClient
+	tasks   []swarm.Task
+	filters []string
+}
+
+func (m *MockTaskClient) TaskList(ctx context.Context, options types.TaskListOptions) ([]swarm.Task, error) {
+	m.filters = options.Filters.Get("service")
+	return m.tasks, nil
+}
+
+func (m *MockTaskClient) NodeList(ctx context.Context, options types.NodeListOptions) ([]swarm.Node, error) {
+	return []swarm.Node{{ID: "node_1", Description: swarm.NodeDescription{Hostname: "manager-1"}}}, nil
+}
+
+func TestListTasks(t *testing.T) {
+	now := time.Now()
+	mockClient := &MockTaskClient{
+		MockClient: MockClient{
+			ServiceListFunc: func(ctx context.Context, options types.ServiceListOptions) ([]swarm.Service, error) {
+				return []swarm.Service{
+					{ID: "web_id", Spec: swarm.ServiceSpec{Annotations: swarm.Annotations{Name: "test_stack_web"}}},
+					{ID: "db_id", Spec: swarm.ServiceSpec{Annotations: swarm.Annotations{Name: "test_stack_db"}}},
+				}, nil
+			},
+		},
+		tasks: []swarm.Task{
+			{
+				ID: "old", ServiceID: "web_id", Slot: 1, NodeID: "node_1", DesiredState: swarm.TaskStateShutdown,
+				Meta: swarm.Meta{CreatedAt: now.Add(-time.Hour)},
+				Status: swarm.TaskStatus{
+					State: swarm.TaskStateFailed, Err: "task: non-zero exit (1)",
+					ContainerStatus: &swarm.ContainerStatus{ContainerID: "container_old", ExitCode: 1},
+				},
+			},
+			{
+				ID: "new", ServiceID: "web_id", Slot: 1, NodeID: "node_1", DesiredState: swarm.TaskStateRunning,
+				Meta: swarm.Meta{CreatedAt: now},
+				Status: swarm.TaskStatus{
+					State:           swarm.TaskStateRunning,
+					ContainerStatus: &swarm.ContainerStatus{ContainerID: "container_new"},
+				},
+			},
+			{ID: "db", ServiceID: "db_id", Slot: 1, DesiredState: swarm.TaskStateRunning, Meta: swarm.Meta{CreatedAt: now}},
+		},
+	}
+
+	tasks, err := docker.ListTasks(mockClient, "test_stack", "", false)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(tasks) != 3 || tasks[0].ID != "db" || tasks[1].ID != "new" || tasks[2].ID != "old" {
+		t.Fatalf("expected tasks ordered by service and age, got %+v", tasks)
+	}
+	if tasks[1].Service != "web" || tasks[1].Node != "manager-1" || tasks[1].ContainerID != "container_new" || tasks[1].ExitCode != nil {
+		t.Errorf("unexpected running task %+v", tasks[1])
+	}
+	if tasks[2].ExitCode == nil || *tasks[2].ExitCode != 1 || tasks[2].Error != "task: non-zero exit (1)" {
+		t.Errorf("expected failed task with exit code 1, got %+v", tasks[2])
+	}
+
+	if _, err := docker.ListTasks(mockClient, "test_stack", "web", true); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(mockClient.filters) != 1 || mockClient.filters[0] != "web_id" {
+		t.Errorf("expected tasks to be filtered by service web_id, got %v", mockClient.filters)
+	}
+
+	if _, err := docker.ListTasks(mockClient, "test_stack", "cache", false); !errors.Is(err, docker.ErrServiceNotFound) {
+		t.Errorf("expected ErrServiceNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,486 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dockrelix/dockrelix-backend/models"
+	"github.com/dockrelix/dockrelix-backend/models/parser"
+	"gopkg.in/yaml.v3"
+)
+
+var yamlLineRegex = regexp.MustCompile(`line (\d+)`)
+
+var unmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+
+// ignoredKeys are compose spec keys the deployer has no use for. Like docker stack deploy, it drops them with a
+// warning rather than refusing the file.
+var ignoredKeys = map[reflect.Type][]string{
+	reflect.TypeOf(parser.ComposeConfig{}): {"name", "include"},
+	reflect.TypeOf(parser.Service{}): {
+		"build", "cgroup_parent", "container_name", "cpu_shares", "cpus", "depends_on", "devices", "domainname",
+		"env_file", "expose", "external_links", "links", "mac_address", "mem_limit", "memswap_limit", "network_mode",
+		"pid", "ipc", "platform", "privileged", "profiles", "pull_policy", "restart", "security_opt", "shm_size",
+		"stdin_open", "tty", "userns_mode", "volumes_from",
+	},
+	reflect.TypeOf(parser.ServiceNetwork{}): {"ipv4_address", "ipv6_address", "link_local_ips", "priority"},
+	reflect.TypeOf(parser.ConfigRef{}):      {"uid", "gid", "mode"},
+	reflect.TypeOf(parser.SecretRef{}):      {"uid", "gid", "mode"},
+}
+
+type composeValidator struct {
+	root     *yaml.Node
+	errors   []models.ValidationError
+	warnings []models.ValidationError
+}
+
+func validationError(node *yaml.Node, path, format string, args ...interface{}) models.ValidationError {
+	err := models.ValidationError{
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	}
+	if node != nil {
+		err.Line = node.Line
+		err.Column = node.Column
+	}
+	return err
+}
+
+func (v *composeValidator) add(node *yaml.Node, path, format string, args ...interface{}) {
+	v.errors = append(v.errors, validationError(node, path, format, args...))
+}
+
+func (v *composeValidator) warn(node *yaml.Node, path, format string, args ...interface{}) {
+	v.warnings = append(v.warnings, validationError(node, path, format, args...))
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node != nil && node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
+
+func isNullNode(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.ShortTag() == "!!null"
+}
+
+func kindName(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+	return "a scalar"
+}
+
+func structFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() || isInlineField(field) || field.Tag.Get("yaml") == "-" {
+			continue
+		}
+		fields[yamlFieldName(field)] = field
+	}
+	return fields
+}
+
+// validateNode checks a node against the Go type the parser decodes it into, so every key the
+// parser would silently drop or fail on is reported with its position.
+func (v *composeValidator) validateNode(node *yaml.Node, t reflect.Type, path string) {
+	node = resolveAlias(node)
+	if node == nil || isNullNode(node) {
+		return
+	}
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	// A list of mappings, such as the service networks, can be written as a mapping keyed by name.
+	if reflect.PointerTo(t).Implements(unmarshalerType) && t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Struct && node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			v.validateNode(node.Content[i+1], t.Elem(), joinPath(path, node.Content[i].Value))
+		}
+		return
+	}
+
+	// The long syntax of ports, volumes, ulimits and resources is checked key by key below like any
+	// other mapping, decoding it whole would let typos through and reject variables in numeric fields.
+	if reflect.PointerTo(t).Implements(unmarshalerType) && (t.Kind() != reflect.Struct || node.Kind != yaml.MappingNode) {
+		if node.Kind == yaml.ScalarNode && hasInterpolation(node.Value) {
+			return
+		}
+		if err := node.Decode(reflect.New(t).Interface()); err != nil {
+			v.add(node, path, "%s", strings.TrimPrefix(err.Error(), "yaml: "))
+		}
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			v.add(node, path, "expected a mapping, got %s", kindName(node))
+			return
+		}
+		fields := structFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if strings.HasPrefix(key.Value, "x-") {
+				continue
+			}
+			field, ok := fields[key.Value]
+			switch {
+			case !ok && slices.Contains(ignoredKeys[t], key.Value):
+				v.warn(key, joinPath(path, key.Value), "key %q is not supported by swarm and is ignored", key.Value)
+			case !ok:
+				v.add(key, joinPath(path, key.Value), "unknown key %q", key.Value)
+			case key.Value == "external" && resolveAlias(value).Kind == yaml.MappingNode:
+				v.validateNode(value, reflect.TypeOf(parser.LegacyExternal{}), joinPath(path, key.Value))
+			default:
+				v.validateNode(value, field.Type, joinPath(path, key.Value))
+			}
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			v.add(node, path, "expected a mapping, got %s", kindName(node))
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			v.validateNode(value, t.Elem(), joinPath(path, key.Value))
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			v.add(node, path, "expected a list, got %s", kindName(node))
+			return
+		}
+		for i, item := range node.Content {
+			v.validateNode(item, t.Elem(), path+"["+strconv.Itoa(i)+"]")
+		}
+	case reflect.Interface:
+	default:
+		if node.Kind != yaml.ScalarNode {
+			v.add(node, path, "expected a scalar, got %s", kindName(node))
+			return
+		}
+		if hasInterpolation(node.Value) {
+			return
+		}
+		if err := node.Decode(reflect.New(t).Interface()); err != nil {
+			v.add(node, path, "invalid value %q: expected %s", node.Value, t.Kind())
+		}
+	}
+}
+
+// findNode follows mapping keys and list indexes from the document root, falling back to the
+// closest parent that exists so an error always has a position.
+func (v *composeValidator) findNode(path ...string) *yaml.Node {
+	node := v.root
+	for _, key := range path {
+		current := resolveAlias(node)
+		var next *yaml.Node
+		switch current.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(current.Content); i += 2 {
+				if current.Content[i].Value == key {
+					next = current.Content[i+1]
+				}
+			}
+		case yaml.SequenceNode:
+			if index, err := strconv.Atoi(key); err == nil && index < len(current.Content) {
+				next = current.Content[index]
+			}
+		}
+		if next == nil {
+			return current
+		}
+		node = next
+	}
+	return resolveAlias(node)
+}
+
+func hasInterpolation(value string) bool {
+	return strings.Contains(value, "$")
+}
+
+// nodeHasInterpolation reports whether any scalar below node still holds a variable.
+func nodeHasInterpolation(node *yaml.Node) bool {
+	node = resolveAlias(node)
+	if node == nil {
+		return false
+	}
+	if node.Kind == yaml.ScalarNode {
+		return hasInterpolation(node.Value)
+	}
+	for _, child := range node.Content {
+		if nodeHasInterpolation(child) {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *composeValidator) check(err error, path ...string) {
+	if err == nil {
+		return
+	}
+	display := ""
+	for _, key := range path {
+		if _, isIndex := strconv.Atoi(key); isIndex == nil {
+			display += "[" + key + "]"
+		} else {
+			display = joinPath(display, key)
+		}
+	}
+	v.add(v.findNode(path...), display, "%s", err.Error())
+}
+
+func (v *composeValidator) checkDuration(value string, path ...string) {
+	if hasInterpolation(value) {
+		return
+	}
+	_, err := parseDuration(value)
+	if err != nil {
+		err = fmt.Errorf("invalid duration %q", value)
+	}
+	v.check(err, path...)
+}
+
+func (v *composeValidator) checkResources(spec *parser.ResourceSpec, path ...string) {
+	if spec == nil {
+		return
+	}
+	if _, err := parseCPUs(spec.CPUs); err != nil && !hasInterpolation(spec.CPUs) {
+		v.check(fmt.Errorf("invalid CPU value %q", spec.CPUs), append(path, "cpus")...)
+	}
+	if _, err := parseMemory(spec.Memory); err != nil && !hasInterpolation(spec.Memory) {
+		v.check(fmt.Errorf("invalid memory value %q", spec.Memory), append(path, "memory")...)
+	}
+}
+
+func (v *composeValidator) checkUpdateConfig(config *parser.UpdateConfig, failureActions []string, path ...string) {
+	if config == nil {
+		return
+	}
+	v.checkDuration(config.Delay, append(path, "delay")...)
+	v.checkDuration(config.Monitor, append(path, "monitor")...)
+	if config.FailureAction != "" && !slices.Contains(failureActions, config.FailureAction) {
+		v.check(fmt.Errorf("invalid failure action %q, expected one of %s", config.FailureAction, strings.Join(failureActions, ", ")), append(path, "failure_action")...)
+	}
+	if config.MaxFailureRatio < 0 || config.MaxFailureRatio > 1 {
+		v.check(fmt.Errorf("invalid max failure ratio %v, expected a value between 0 and 1", config.MaxFailureRatio), append(path, "max_failure_ratio")...)
+	}
+	if config.Order != "" && config.Order != "stop-first" && config.Order != "start-first" {
+		v.check(fmt.Errorf("invalid order %q, expected stop-first or start-first", config.Order), append(path, "order")...)
+	}
+}
+
+func (v *composeValidator) checkNetwork(name string, network parser.Network) {
+	if network.IPAM == nil {
+		return
+	}
+
+	for i, pool := range network.IPAM.Config {
+		path := []string{"networks", name, "ipam", "config", strconv.Itoa(i)}
+		at := func(key string) []string {
+			return append(append([]string{}, path...), key)
+		}
+
+		if _, _, err := net.ParseCIDR(pool.Subnet); pool.Subnet != "" && err != nil && !hasInterpolation(pool.Subnet) {
+			v.check(fmt.Errorf("invalid subnet %q", pool.Subnet), at("subnet")...)
+		}
+		if _, _, err := net.ParseCIDR(pool.IPRange); pool.IPRange != "" && err != nil && !hasInterpolation(pool.IPRange) {
+			v.check(fmt.Errorf("invalid ip range %q", pool.IPRange), at("ip_range")...)
+		}
+		if pool.Gateway != "" && net.ParseIP(pool.Gateway) == nil && !hasInterpolation(pool.Gateway) {
+			v.check(fmt.Errorf("invalid gateway %q", pool.Gateway), at("gateway")...)
+		}
+		for host, address := range pool.AuxAddresses {
+			if net.ParseIP(address) == nil && !hasInterpolation(address) {
+				v.check(fmt.Errorf("invalid address %q for %q", address, host), at("aux_addresses")...)
+			}
+		}
+	}
+}
+
+func (v *composeValidator) checkService(name string, srv parser.Service, config parser.ComposeConfig) {
+	path := []string{"services", name}
+	at := func(keys ...string) []string {
+		return append(append([]string{}, path...), keys...)
+	}
+
+	if srv.Image == "" {
+		v.check(errors.New("image is required"), path...)
+	}
+
+	for i, port := range srv.Ports {
+		if _, err := ConvertPort(port); err != nil && !nodeHasInterpolation(v.findNode(at("ports", strconv.Itoa(i))...)) {
+			v.check(err, at("ports", strconv.Itoa(i))...)
+		}
+	}
+
+	for i, nw := range srv.Networks {
+		if _, ok := config.Networks[nw.Name]; !ok && nw.Name != "default" {
+			path := at("networks", strconv.Itoa(i))
+			if v.findNode(at("networks")...).Kind == yaml.MappingNode {
+				path = at("networks", nw.Name)
+			}
+			v.check(fmt.Errorf("network %q is not defined", nw.Name), path...)
+		}
+	}
+
+	for i, vol := range srv.Volumes {
+		if _, err := ConvertVolume(vol, "", config.Volumes); err != nil && !nodeHasInterpolation(v.findNode(at("volumes", strconv.Itoa(i))...)) {
+			v.check(err, at("volumes", strconv.Itoa(i))...)
+		}
+	}
+
+	for i, ref := range srv.Secrets {
+		if _, ok := config.Secrets[ref.Source]; !ok {
+			v.check(fmt.Errorf("secret %q is not defined", ref.Source), at("secrets", strconv.Itoa(i))...)
+		}
+	}
+
+	for i, ref := range srv.Configs {
+		if _, ok := config.Configs[ref.Source]; !ok {
+			v.check(fmt.Errorf("config %q is not defined", ref.Source), at("configs", strconv.Itoa(i))...)
+		}
+	}
+
+	v.checkDuration(srv.StopGracePeriod, at("stop_grace_period")...)
+
+	switch srv.Isolation {
+	case "", "default", "process", "hyperv":
+	default:
+		v.check(fmt.Errorf("invalid isolation %q", srv.Isolation), at("isolation")...)
+	}
+
+	if hc := srv.Healthcheck; hc != nil {
+		v.checkDuration(hc.Interval, at("healthcheck", "interval")...)
+		v.checkDuration(hc.Timeout, at("healthcheck", "timeout")...)
+		v.checkDuration(hc.StartPeriod, at("healthcheck", "start_period")...)
+	}
+
+	deploy := srv.Deploy
+	switch deploy.Mode {
+	case "", "replicated", "global", "replicated-job", "global-job":
+	default:
+		v.check(fmt.Errorf("invalid deploy mode %q", deploy.Mode), at("deploy", "mode")...)
+	}
+
+	switch deploy.EndpointMode {
+	case "", "vip", "dnsrr":
+	default:
+		v.check(fmt.Errorf("invalid endpoint mode %q, expected vip or dnsrr", deploy.EndpointMode), at("deploy", "endpoint_mode")...)
+	}
+
+	v.checkUpdateConfig(deploy.UpdateConfig, []string{"continue", "pause", "rollback"}, at("deploy", "update_config")...)
+	v.checkUpdateConfig(deploy.RollbackConfig, []string{"continue", "pause"}, at("deploy", "rollback_config")...)
+
+	if policy := deploy.RestartPolicy; policy != nil {
+		switch policy.Condition {
+		case "", "none", "on-failure", "any":
+		default:
+			v.check(fmt.Errorf("invalid restart condition %q", policy.Condition), at("deploy", "restart_policy", "condition")...)
+		}
+		v.checkDuration(policy.Delay, at("deploy", "restart_policy", "delay")...)
+		v.checkDuration(policy.Window, at("deploy", "restart_policy", "window")...)
+	}
+
+	if resources := deploy.Resources; resources != nil {
+		v.checkResources(resources.Limits, at("deploy", "resources", "limits")...)
+		v.checkResources(resources.Reservations, at("deploy", "resources", "reservations")...)
+	}
+}
+
+func syntaxError(err error) models.ValidationError {
+	result := models.ValidationError{Message: strings.TrimPrefix(err.Error(), "yaml: ")}
+	if match := yamlLineRegex.FindStringSubmatch(err.Error()); match != nil {
+		result.Line, _ = strconv.Atoi(match[1])
+	}
+	return result
+}
+
+func sortByPosition(errs []models.ValidationError) {
+	sort.SliceStable(errs, func(i, j int) bool {
+		if errs[i].Line != errs[j].Line {
+			return errs[i].Line < errs[j].Line
+		}
+		return errs[i].Column < errs[j].Column
+	})
+}
+
+// validateDocument returns the errors found in a document, which make it unusable, and the warnings about keys
+// that are dropped when it is deployed.
+func validateDocument(root *yaml.Node) ([]models.ValidationError, []models.ValidationError) {
+	v := &composeValidator{root: root, errors: []models.ValidationError{}, warnings: []models.ValidationError{}}
+	v.validateNode(v.root, reflect.TypeOf(parser.ComposeConfig{}), "")
+	if len(v.errors) > 0 {
+		return v.errors, v.warnings
+	}
+
+	// Type errors left at this point come from values that still hold variables, the
+	// decoder keeps going past them so the rest of the file can be checked.
+	var config parser.ComposeConfig
+	var typeErr *yaml.TypeError
+	if err := root.Decode(&config); err != nil && !errors.As(err, &typeErr) {
+		return []models.ValidationError{syntaxError(err)}, v.warnings
+	}
+
+	networks := make([]string, 0, len(config.Networks))
+	for name := range config.Networks {
+		networks = append(networks, name)
+	}
+	sort.Strings(networks)
+	for _, name := range networks {
+		v.checkNetwork(name, config.Networks[name])
+	}
+
+	names := make([]string, 0, len(config.Services))
+	for name := range config.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v.checkService(name, config.Services[name], config)
+	}
+
+	sortByPosition(v.errors)
+	sortByPosition(v.warnings)
+	return v.errors, v.warnings
+}
+
+// CheckCompose checks a compose file against the subset of the compose spec the parser understands.
+// It returns every problem found rather than stopping at the first one, along with warnings about the
+// spec keys swarm has no use for, which are ignored. Values that still contain variables are only
+// checked once they have been interpolated, see RenderCompose.
+func CheckCompose(data string) ([]models.ValidationError, []models.ValidationError) {
+	root, errs := parseDocument(data)
+	if errs != nil {
+		return errs, []models.ValidationError{}
+	}
+	return validateDocument(root)
+}
+
+// ValidateCompose returns the errors CheckCompose finds in a compose file, leaving out its warnings.
+func ValidateCompose(data string) []models.ValidationError {
+	errs, _ := CheckCompose(data)
+	return errs
+}
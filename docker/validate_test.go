@@ -0,0 +1,258 @@
+package docker_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dockrelix/dockrelix-backend/docker"
+)
+
+func TestValidateComposeValid(t *testing.T) {
+	data := `version: "3.8"
+services:
+  web:
+    image: nginx:latest
+    ports:
+      - "8080:80"
+    networks:
+      - frontend
+    deploy:
+      replicas: 2
+      resources:
+        limits:
+          cpus: "0.5"
+          memory: 512M
+networks:
+  frontend: {}
+`
+
+	if errs := docker.ValidateCompose(data); len(errs) != 0 {
+		t.Errorf("expected no errors, got %+v", errs)
+	}
+}
+
+func TestValidateComposeErrors(t *testing.T) {
+	data := `services:
+  web:
+    image: nginx:latest
+    ports:
+      - "80/http"
+    networks:
+      - backend
+    secrets:
+      - source: token
+    healthcheck:
+      interval: soon
+    deploy:
+      replicas: two
+      resources:
+        limits:
+          memory: lots
+    restrat: always
+`
+
+	errs := docker.ValidateCompose(data)
+
+	expected := map[string]int{
+		"services.web.restrat":         17,
+		"services.web.deploy.replicas": 13,
+	}
+	for _, err := range errs {
+		if line, ok := expected[err.Path]; ok {
+			if err.Line != line {
+				t.Errorf("expected %s on line %d, got %d", err.Path, line, err.Line)
+			}
+			delete(expected, err.Path)
+		}
+	}
+	if len(expected) != 0 {
+		t.Errorf("missing structural errors %v in %+v", expected, errs)
+	}
+
+	data = `services:
+  web:
+    image: nginx:latest
+    ports:
+      - "80/http"
+    networks:
+      - backend
+    secrets:
+      - source: token
+    healthcheck:
+      interval: soon
+    deploy:
+      resources:
+        limits:
+          memory: lots
+`
+
+	errs = docker.ValidateCompose(data)
+
+	expected = map[string]int{
+		"services.web.ports[0]":                       5,
+		"services.web.networks[0]":                    7,
+		"services.web.secrets[0]":                     9,
+		"services.web.healthcheck.interval":           11,
+		"services.web.deploy.resources.limits.memory": 15,
+	}
+	for _, err := range errs {
+		if line, ok := expected[err.Path]; ok {
+			if err.Line != line {
+				t.Errorf("expected %s on line %d, got %d", err.Path, line, err.Line)
+			}
+			delete(expected, err.Path)
+		}
+	}
+	if len(expected) != 0 {
+		t.Errorf("missing errors %v in %+v", expected, errs)
+	}
+}
+
+func TestValidateComposeSyntaxError(t *testing.T) {
+	errs := docker.ValidateCompose("services:\n  web:\n    image: [nginx\n")
+	if len(errs) != 1 || errs[0].Line == 0 {
+		t.Errorf("expected a single syntax error with a line, got %+v", errs)
+	}
+}
+
+func TestValidateComposeNetworkIPAM(t *testing.T) {
+	data := `services:
+  web:
+    image: nginx
+    networks:
+      - backend
+networks:
+  backend:
+    ipam:
+      config:
+        - subnet: 10.20.0.0/33
+          gateway: 10.20.0.1
+`
+
+	errs := docker.ValidateCompose(data)
+	if len(errs) != 1 || errs[0].Path != "networks.backend.ipam.config[0].subnet" || errs[0].Line != 10 {
+		t.Errorf("expected a single subnet error on line 10, got %+v", errs)
+	}
+}
+
+func TestValidateComposeLongSyntax(t *testing.T) {
+	data := `services:
+  web:
+    image: nginx
+    ports:
+      - target: 80
+        publised: 8080
+      - target: ${WEB_PORT}
+        published: ${PUBLISHED_PORT}
+    volumes:
+      - type: volume
+        source: data
+        target: /data
+        read_onyl: true
+      - type: bind
+        source: ./html
+        target: /html
+        read_only: ${READ_ONLY}
+    ulimits:
+      nofile:
+        soft: ${NOFILE}
+        hard: 40000
+volumes:
+  data: {}
+`
+
+	errs := docker.ValidateCompose(data)
+
+	expected := map[string]int{
+		"services.web.ports[0].publised":    6,
+		"services.web.volumes[0].read_onyl": 13,
+	}
+	for _, err := range errs {
+		line, ok := expected[err.Path]
+		if !ok {
+			t.Errorf("unexpected error %+v", err)
+			continue
+		}
+		if err.Line != line {
+			t.Errorf("expected %s on line %d, got %d", err.Path, line, err.Line)
+		}
+		delete(expected, err.Path)
+	}
+	if len(expected) != 0 {
+		t.Errorf("missing errors %v in %+v", expected, errs)
+	}
+
+	data = strings.NewReplacer("publised", "published", "read_onyl", "read_only").Replace(data)
+	if errs := docker.ValidateCompose(data); len(errs) != 0 {
+		t.Errorf("expected variables in the long syntax to be accepted, got %+v", errs)
+	}
+}
+
+func TestCheckComposeSpecForms(t *testing.T) {
+	data := `services:
+  web:
+    image: nginx
+    build: .
+    restart: always
+    depends_on: [db]
+    environment:
+      MODE: prod
+      DEBUG:
+    secrets: [token]
+    configs:
+      - site
+    networks:
+      frontend:
+        aliases: [www]
+        ipv4_address: 10.0.0.10
+      backend:
+  db:
+    image: postgres
+networks:
+  frontend: {}
+  backend:
+    external:
+      name: shared_backend
+configs:
+  site:
+    file: ./site.conf
+secrets:
+  token:
+    external: true
+`
+
+	errs, warnings := docker.CheckCompose(data)
+	if len(errs) != 0 {
+		t.Errorf("expected no errors, got %+v", errs)
+	}
+
+	expected := map[string]int{
+		"services.web.build":                          4,
+		"services.web.restart":                        5,
+		"services.web.depends_on":                     6,
+		"services.web.networks.frontend.ipv4_address": 16,
+	}
+	for _, warning := range warnings {
+		line, ok := expected[warning.Path]
+		if !ok {
+			t.Errorf("unexpected warning %+v", warning)
+			continue
+		}
+		if warning.Line != line {
+			t.Errorf("expected %s on line %d, got %d", warning.Path, line, warning.Line)
+		}
+		delete(expected, warning.Path)
+	}
+	if len(expected) != 0 {
+		t.Errorf("missing warnings %v in %+v", expected, warnings)
+	}
+
+	errs, _ = docker.CheckCompose(strings.Replace(data, "      backend:\n  db:", "      missing:\n  db:", 1))
+	if len(errs) != 1 || errs[0].Path != "services.web.networks.missing" {
+		t.Errorf("expected an undefined network in the mapping form, got %+v", errs)
+	}
+	errs, _ = docker.CheckCompose(strings.Replace(data, "      name: shared_backend", "      nmae: shared_backend", 1))
+	if len(errs) != 1 || errs[0].Path != "networks.backend.external.nmae" {
+		t.Errorf("expected an unknown key in the legacy external form, got %+v", errs)
+	}
+}
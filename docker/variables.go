@@ -0,0 +1,81 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dockrelix/dockrelix-backend/database"
+	"github.com/dockrelix/dockrelix-backend/models"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidVariableName = errors.New("invalid variable name")
+
+func ListVariables(draftName, environment string) (map[string]string, error) {
+	var variables []models.StackVariable
+	err := database.DB.Where("draft_name = ? AND environment = ?", draftName, environment).Find(&variables).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]string{}
+	for _, variable := range variables {
+		result[variable.Key] = variable.Value
+	}
+	return result, nil
+}
+
+// GetVariables returns the default variables of a draft overridden by those of the given environment.
+func GetVariables(draftName, environment string) (map[string]string, error) {
+	variables, err := ListVariables(draftName, "")
+	if err != nil {
+		return nil, err
+	}
+	if environment == "" {
+		return variables, nil
+	}
+
+	overrides, err := ListVariables(draftName, environment)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range overrides {
+		variables[key] = value
+	}
+	return variables, nil
+}
+
+// SetVariables replaces the variables of a draft for one environment, the empty environment holds the defaults.
+func SetVariables(draftName, environment string, variables map[string]string) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		return setVariables(tx, draftName, environment, variables)
+	})
+}
+
+func setVariables(tx *gorm.DB, draftName, environment string, variables map[string]string) error {
+	for key := range variables {
+		if !IsVariableName(key) {
+			return fmt.Errorf("%w: %q", ErrInvalidVariableName, key)
+		}
+	}
+
+	err := tx.Unscoped().
+		Where("draft_name = ? AND environment = ?", draftName, environment).
+		Delete(&models.StackVariable{}).Error
+	if err != nil {
+		return err
+	}
+
+	for key, value := range variables {
+		variable := models.StackVariable{
+			DraftName:   draftName,
+			Environment: environment,
+			Key:         key,
+			Value:       value,
+		}
+		if err := tx.Create(&variable).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/dockrelix/dockrelix-backend/database"
+	"github.com/dockrelix/dockrelix-backend/middleware"
 	"github.com/dockrelix/dockrelix-backend/models"
 	"github.com/dockrelix/dockrelix-backend/utils"
 
@@ -52,6 +53,29 @@ func Login(c *gin.Context) {
 	c.JSON(200, gin.H{"token": tokenString})
 }
 
+// streamTokenLifetime is how long a stream token can be used to open a connection, which then stays open.
+const streamTokenLifetime = time.Minute
+
+// StreamToken hands the signed in user a short-lived token to pass as the token query parameter of a WebSocket or
+// event stream request, which browsers open without an Authorization header.
+func StreamToken(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":   user.ID,
+		"scope": middleware.StreamScope,
+		"exp":   time.Now().Add(streamTokenLifetime).Unix(),
+	})
+
+	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"token": tokenString, "expires_in": int(streamTokenLifetime.Seconds())})
+}
+
 func IsSetup(c *gin.Context) {
 	var count int64
 	database.DB.Model(&models.User{}).Count(&count)
@@ -5,12 +5,15 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"os"
 
 	"github.com/dockrelix/dockrelix-backend/database"
 	"github.com/dockrelix/dockrelix-backend/handlers"
+	"github.com/dockrelix/dockrelix-backend/middleware"
 	"github.com/dockrelix/dockrelix-backend/models"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
@@ -211,3 +214,86 @@ func TestSetupAlreadyComplete(t *testing.T) {
 		t.Errorf("expected 'Setup already complete' error, got %v", response["error"])
 	}
 }
+
+func TestStreamToken(t *testing.T) {
+	database.InitDBForTesting()
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	user := models.User{Username: "testuser", Email: "test@example.com", Password: handlers.HashPassword("testpassword")}
+	database.DB.Create(&user)
+
+	router := setupRouter()
+	router.POST("/stream-token", middleware.JWTAuth(), handlers.StreamToken)
+	signedIn := func(c *gin.Context) {
+		c.String(http.StatusOK, c.MustGet("user").(models.User).Username)
+	}
+	router.GET("/events", middleware.StreamAuth(), signedIn)
+	router.GET("/stacks", middleware.JWTAuth(), signedIn)
+
+	login := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": user.ID, "exp": time.Now().Add(time.Hour).Unix()})
+	loginToken, _ := login.SignedString([]byte("test-secret"))
+
+	req, _ := http.NewRequest("POST", "/stream-token", nil)
+	req.Header.Set("Authorization", "Bearer "+loginToken)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %v: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.Token == "" {
+		t.Fatalf("expected a stream token, got %s", w.Body.String())
+	}
+
+	request := func(path, header string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		if header != "" {
+			req.Header.Set("Authorization", "Bearer "+header)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := request("/events?token="+response.Token, ""); w.Code != http.StatusOK || w.Body.String() != "testuser" {
+		t.Errorf("expected the stream token to sign in an event stream, got %v: %s", w.Code, w.Body.String())
+	}
+	if w := request("/events", loginToken); w.Code != http.StatusOK {
+		t.Errorf("expected a login token in the header to sign in an event stream, got %v", w.Code)
+	}
+	if w := request("/stacks?token="+response.Token, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the query token to be ignored outside of streams, got %v", w.Code)
+	}
+	if w := request("/stacks", response.Token); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the stream token to be refused in the header, got %v", w.Code)
+	}
+	if w := request("/events", response.Token); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the stream token to be refused in the header of a stream, got %v", w.Code)
+	}
+	if w := request("/events?token="+loginToken, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a login token to be refused as a query parameter, got %v", w.Code)
+	}
+}
+
+func TestLoggerRedactsToken(t *testing.T) {
+	var buf bytes.Buffer
+	writer := gin.DefaultWriter
+	gin.DefaultWriter = &buf
+	defer func() { gin.DefaultWriter = writer }()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.Logger())
+	router.GET("/events", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest("GET", "/events?since=1&token=secret-token", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if strings.Contains(buf.String(), "secret-token") || !strings.Contains(buf.String(), "/events?since=1&token=REDACTED") {
+		t.Errorf("expected the token to be redacted, got %q", buf.String())
+	}
+}
@@ -1,6 +1,10 @@
 package handlers
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+
 	"github.com/docker/docker/client"
 	"github.com/dockrelix/dockrelix-backend/docker"
 	"github.com/dockrelix/dockrelix-backend/models"
@@ -13,47 +17,221 @@ func ListStacks(cli *client.Client, c *gin.Context) {
 	c.JSON(200, result)
 }
 
+// ParseStackConfig returns the compose file of a running stack, as JSON by default. The yaml format returns it as a
+// docker-compose.yml, the tar format as a tar.gz bundle along with the files of its configs and the
+// kubernetes format as Kubernetes manifests.
 func ParseStackConfig(cli *client.Client, c *gin.Context) {
 	stackName := c.Param("name")
-	result, err := docker.ParseStackConfig(cli, stackName)
+
+	switch c.Query("format") {
+	case "", "json":
+		result, err := docker.ParseStackConfig(cli, stackName)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, result)
+	case "yaml":
+		data, err := docker.ExportStack(cli, stackName)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Content-Disposition", `attachment; filename="docker-compose.yml"`)
+		c.Data(200, "application/yaml", data)
+	case "tar":
+		var bundle bytes.Buffer
+		if err := docker.ExportStackBundle(cli, stackName, &bundle); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Content-Disposition", `attachment; filename="`+stackName+`.tar.gz"`)
+		c.Data(200, "application/gzip", bundle.Bytes())
+	case "kubernetes":
+		config, err := docker.ParseStackConfig(cli, stackName)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		result, err := docker.ConvertKubernetes(config, stackName, nil)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, result)
+	default:
+		c.JSON(400, gin.H{"error": "Invalid format, expected json, yaml, tar or kubernetes"})
+	}
+}
+
+func DeployStackDraft(cli *client.Client, c *gin.Context) {
+	draft, ok := loadDraft(c)
+	if !ok {
+		return
+	}
+
+	config, err := docker.RenderDraft(draft, c.Query("environment"))
+	if err != nil {
+		respondRenderError(c, err)
+		return
+	}
+
+	var request struct {
+		Message string `json:"message"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	files, err := docker.GetDraftFiles(draft.Name)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(200, result)
+
+	if err := docker.DeployStack(cli, draft.Name, config, files); err != nil {
+		c.JSON(500, gin.H{"error": "Stack could not be deployed: " + err.Error()})
+		return
+	}
+
+	if !recordDeployRevision(c, draft.Name, draft.Data, config, request.Message) {
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Stack deployed successfully"})
 }
 
-func CreateStackDraft(cli *client.Client, c *gin.Context) {
-	var stackDraft models.StackDraft
-	if err := c.ShouldBindJSON(&stackDraft); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+func RemoveStack(cli *client.Client, c *gin.Context) {
+	removal, err := docker.RemoveStack(c.Request.Context(), cli, c.Param("name"), c.Query("volumes") == "true")
+	if errors.Is(err, docker.ErrStackNotFound) {
+		c.JSON(404, gin.H{"error": "Stack not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Stack could not be removed: " + err.Error()})
 		return
 	}
 
-	if stackDraft.Name == "" {
-		c.JSON(400, gin.H{"error": "Name is required"})
+	for _, resource := range removal.Resources {
+		if !resource.Removed {
+			c.JSON(207, removal)
+			return
+		}
+	}
+
+	c.JSON(200, removal)
+}
+
+func DiffStackDraft(cli *client.Client, c *gin.Context) {
+	draft, ok := loadDraft(c)
+	if !ok {
 		return
 	}
 
-	if stackDraft.Data == "" {
-		c.JSON(400, gin.H{"error": "Data is required"})
+	desired, err := docker.RenderDraft(draft, c.Query("environment"))
+	if err != nil {
+		respondRenderError(c, err)
 		return
 	}
 
-	err := docker.SaveDraft(stackDraft)
+	current, err := docker.ParseStackConfig(cli, draft.Name)
 	if err != nil {
-		if err.Error() == "UNIQUE constraint failed: stack_drafts.name" {
-			c.JSON(400, gin.H{"error": "Draft with this name already exists"})
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, docker.DiffStack(draft.Name, desired, current))
+}
+
+func RollbackStack(cli *client.Client, c *gin.Context) {
+	var request struct {
+		Revision uint     `json:"revision"`
+		Native   bool     `json:"native"`
+		Services []string `json:"services"`
+		Message  string   `json:"message"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(500, gin.H{"error": "Stack could not be drafted: " + err.Error()})
+	}
+
+	stackName := c.Param("name")
+
+	if request.Native {
+		results, err := docker.RollbackServices(cli, stackName, request.Services)
+		switch {
+		case errors.Is(err, docker.ErrStackNotFound):
+			c.JSON(404, gin.H{"error": "Stack not found"})
+			return
+		case errors.Is(err, docker.ErrServiceNotFound):
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			c.JSON(500, gin.H{"error": "Stack could not be rolled back: " + err.Error()})
+			return
+		}
+
+		for _, result := range results {
+			if !result.RolledBack {
+				c.JSON(207, results)
+				return
+			}
+		}
+		c.JSON(200, results)
 		return
 	}
 
-	c.JSON(200, gin.H{"message": "Stack draft created successfully"})
-}
+	var revision models.StackRevision
+	var err error
+	if request.Revision != 0 {
+		revision, err = docker.GetRevision(stackName, request.Revision)
+	} else {
+		revision, err = docker.PreviousDeployRevision(stackName)
+	}
+	if errors.Is(err, docker.ErrRevisionNotFound) {
+		c.JSON(404, gin.H{"error": "No revision to roll back to"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
 
-func GetStackDrafts(c *gin.Context) {
-	result := docker.GetDrafts()
-	c.JSON(200, result)
+	config, err := docker.RevisionConfig(revision, c.Query("environment"))
+	if err != nil {
+		respondRenderError(c, err)
+		return
+	}
+
+	files, err := docker.GetDraftFiles(stackName)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := docker.DeployStack(cli, stackName, config, files); err != nil {
+		c.JSON(500, gin.H{"error": "Stack could not be rolled back: " + err.Error()})
+		return
+	}
+
+	removed, err := docker.PruneServices(cli, stackName, config)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Services added after the revision could not be removed: " + err.Error()})
+		return
+	}
+
+	message := request.Message
+	if message == "" {
+		message = fmt.Sprintf("Rolled back to revision %d", revision.Revision)
+	}
+	if !recordDeployRevision(c, stackName, revision.Data, config, message) {
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Stack rolled back successfully", "revision": revision.Revision, "removed_services": removed})
 }
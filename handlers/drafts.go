@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/client"
+	"github.com/dockrelix/dockrelix-backend/docker"
+	"github.com/dockrelix/dockrelix-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type draftRequest struct {
+	Name      *string  `json:"name"`
+	Data      *string  `json:"data"`
+	Documents []string `json:"documents"`
+	Version   *uint    `json:"version"`
+	Message   string   `json:"message"`
+}
+
+// bindDraftRequest reads a draft request, an ordered list of documents is joined into the draft data.
+func bindDraftRequest(c *gin.Context, request *draftRequest) bool {
+	if err := c.ShouldBindJSON(request); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return false
+	}
+
+	if request.Documents != nil {
+		if request.Data != nil {
+			c.JSON(400, gin.H{"error": "Either data or documents can be given, not both"})
+			return false
+		}
+		data := docker.JoinDocuments(request.Documents)
+		request.Data = &data
+	}
+	return true
+}
+
+func draftETag(draft models.StackDraft) string {
+	return `"` + strconv.FormatUint(uint64(draft.Version), 10) + `"`
+}
+
+// expectedVersion reads the version the client last saw from If-Match, falling back to the request body.
+// A wildcard If-Match returns the current version so the write always goes through.
+func expectedVersion(c *gin.Context, draft models.StackDraft, body *uint) (uint, bool) {
+	header := strings.TrimPrefix(strings.TrimSpace(c.GetHeader("If-Match")), "W/")
+	if header == "*" {
+		return draft.Version, true
+	}
+	if header != "" {
+		version, err := strconv.ParseUint(strings.Trim(header, `"`), 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return uint(version), true
+	}
+	if body != nil {
+		return *body, true
+	}
+	return 0, false
+}
+
+func respondDraftError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, docker.ErrDraftNotFound):
+		c.JSON(404, gin.H{"error": "Draft not found"})
+	case errors.Is(err, docker.ErrDraftExists):
+		c.JSON(400, gin.H{"error": "Draft with this name already exists"})
+	case errors.Is(err, docker.ErrDraftVersionConflict):
+		c.JSON(412, gin.H{"error": "Draft was modified by someone else, reload it and try again"})
+	default:
+		c.JSON(500, gin.H{"error": err.Error()})
+	}
+}
+
+// rejectInvalidCompose answers with every validation error found in data, it reports whether it did. Otherwise
+// it returns the warnings about keys swarm ignores.
+func rejectInvalidCompose(c *gin.Context, data string) ([]models.ValidationError, bool) {
+	errs, warnings := docker.CheckCompose(data)
+	if len(errs) > 0 {
+		c.JSON(400, gin.H{"error": "Invalid compose file", "errors": errs, "warnings": warnings})
+		return nil, true
+	}
+	return warnings, false
+}
+
+func loadDraft(c *gin.Context) (models.StackDraft, bool) {
+	draft, err := docker.GetDraft(c.Param("name"))
+	if err != nil {
+		respondDraftError(c, err)
+		return draft, false
+	}
+	return draft, true
+}
+
+func CreateStackDraft(cli *client.Client, c *gin.Context) {
+	var request draftRequest
+	if !bindDraftRequest(c, &request) {
+		return
+	}
+
+	if request.Name == nil || *request.Name == "" {
+		c.JSON(400, gin.H{"error": "Name is required"})
+		return
+	}
+
+	if request.Data == nil || *request.Data == "" {
+		c.JSON(400, gin.H{"error": "Data is required"})
+		return
+	}
+
+	warnings, rejected := rejectInvalidCompose(c, *request.Data)
+	if rejected {
+		return
+	}
+
+	revision := &models.StackRevision{Author: currentUsername(c), Message: request.Message}
+	draft, err := docker.SaveDraft(models.StackDraft{Name: *request.Name, Data: *request.Data}, revision)
+	if err != nil {
+		if errors.Is(err, docker.ErrDraftExists) {
+			c.JSON(400, gin.H{"error": "Draft with this name already exists"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Stack could not be drafted: " + err.Error()})
+		return
+	}
+
+	c.Header("ETag", draftETag(draft))
+	c.JSON(200, gin.H{"message": "Stack draft created successfully", "warnings": warnings})
+}
+
+// ImportStackDraft creates a draft from an uploaded compose file or a tar, tar.gz or zip archive holding one.
+func ImportStackDraft(c *gin.Context) {
+	name := c.PostForm("name")
+	if name == "" {
+		c.JSON(400, gin.H{"error": "Name is required"})
+		return
+	}
+
+	upload, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(400, gin.H{"error": "File is required"})
+		return
+	}
+
+	file, err := upload.Open()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	archive, err := docker.ReadImport(upload.Filename, file)
+	if err != nil {
+		if errors.Is(err, docker.ErrInvalidImport) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	revision := &models.StackRevision{Author: currentUsername(c), Message: "Imported from " + upload.Filename}
+	report, err := docker.ImportDraft(name, archive, revision)
+	if err != nil {
+		switch {
+		case errors.Is(err, docker.ErrInvalidImport):
+			c.JSON(400, gin.H{"error": err.Error()})
+		case errors.Is(err, docker.ErrDraftExists):
+			respondDraftError(c, err)
+		default:
+			respondRenderError(c, err)
+		}
+		return
+	}
+
+	c.Header("ETag", draftETag(report.Draft))
+	c.JSON(200, report)
+}
+
+func GetStackDrafts(c *gin.Context) {
+	result := docker.GetDrafts()
+	c.JSON(200, result)
+}
+
+func GetStackDraft(c *gin.Context) {
+	draft, ok := loadDraft(c)
+	if !ok {
+		return
+	}
+
+	c.Header("ETag", draftETag(draft))
+	c.JSON(200, draft)
+}
+
+func updateStackDraft(c *gin.Context, partial bool) {
+	var request draftRequest
+	if !bindDraftRequest(c, &request) {
+		return
+	}
+
+	if request.Name != nil && *request.Name == "" {
+		c.JSON(400, gin.H{"error": "Name cannot be empty"})
+		return
+	}
+
+	if (!partial || request.Data != nil) && (request.Data == nil || *request.Data == "") {
+		c.JSON(400, gin.H{"error": "Data is required"})
+		return
+	}
+
+	if partial && request.Name == nil && request.Data == nil {
+		c.JSON(400, gin.H{"error": "Nothing to update"})
+		return
+	}
+
+	if request.Data != nil {
+		if _, rejected := rejectInvalidCompose(c, *request.Data); rejected {
+			return
+		}
+	}
+
+	draft, ok := loadDraft(c)
+	if !ok {
+		return
+	}
+
+	if c.GetHeader("If-Match") == "" && request.Version == nil {
+		c.JSON(428, gin.H{"error": "If-Match header or version is required"})
+		return
+	}
+
+	version, ok := expectedVersion(c, draft, request.Version)
+	if !ok {
+		c.JSON(412, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+
+	var changes models.StackDraft
+	if request.Name != nil {
+		changes.Name = *request.Name
+	}
+	if request.Data != nil {
+		changes.Data = *request.Data
+	}
+
+	var revision *models.StackRevision
+	if request.Data != nil {
+		revision = &models.StackRevision{Author: currentUsername(c), Message: request.Message}
+	}
+
+	updated, err := docker.UpdateDraft(draft.Name, version, changes, revision)
+	if err != nil {
+		respondDraftError(c, err)
+		return
+	}
+
+	c.Header("ETag", draftETag(updated))
+	c.JSON(200, updated)
+}
+
+func UpdateStackDraft(c *gin.Context) {
+	updateStackDraft(c, false)
+}
+
+func PatchStackDraft(c *gin.Context) {
+	updateStackDraft(c, true)
+}
+
+func DeleteStackDraft(c *gin.Context) {
+	draft, ok := loadDraft(c)
+	if !ok {
+		return
+	}
+
+	if c.GetHeader("If-Match") == "" {
+		c.JSON(428, gin.H{"error": "If-Match header is required"})
+		return
+	}
+
+	version, ok := expectedVersion(c, draft, nil)
+	if !ok {
+		c.JSON(412, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+
+	if err := docker.DeleteDraft(draft.Name, version); err != nil {
+		respondDraftError(c, err)
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Stack draft deleted successfully"})
+}
+
+func ValidateStackConfig(c *gin.Context) {
+	var request draftRequest
+	if !bindDraftRequest(c, &request) {
+		return
+	}
+
+	if request.Data == nil || *request.Data == "" {
+		c.JSON(400, gin.H{"error": "Data is required"})
+		return
+	}
+
+	errs, warnings := docker.CheckCompose(*request.Data)
+	c.JSON(200, gin.H{"valid": len(errs) == 0, "errors": errs, "warnings": warnings})
+}
@@ -0,0 +1,175 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dockrelix/dockrelix-backend/database"
+	"github.com/dockrelix/dockrelix-backend/handlers"
+	"github.com/dockrelix/dockrelix-backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+func setupDraftRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+
+	router.GET("/drafts/:name", handlers.GetStackDraft)
+	router.PUT("/drafts/:name", handlers.UpdateStackDraft)
+	router.PATCH("/drafts/:name", handlers.PatchStackDraft)
+	router.DELETE("/drafts/:name", handlers.DeleteStackDraft)
+
+	return router
+}
+
+func createDraft(t *testing.T, name, data string) {
+	draft := models.StackDraft{Name: name, Data: data, Version: 1}
+	if err := database.DB.Create(&draft).Error; err != nil {
+		t.Fatalf("failed to create draft: %v", err)
+	}
+}
+
+func sendDraftRequest(router *gin.Engine, method, path, ifMatch string, payload interface{}) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	if payload != nil {
+		_ = json.NewEncoder(&body).Encode(payload)
+	}
+	req, _ := http.NewRequest(method, path, &body)
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestGetStackDraft(t *testing.T) {
+	database.InitDBForTesting()
+	createDraft(t, "web", "services: {}")
+
+	router := setupDraftRouter()
+
+	w := sendDraftRequest(router, "GET", "/drafts/web", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %v", w.Code)
+	}
+
+	if w.Header().Get("ETag") != `"1"` {
+		t.Errorf("expected ETag \"1\", got %v", w.Header().Get("ETag"))
+	}
+
+	w = sendDraftRequest(router, "GET", "/drafts/missing", "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %v", w.Code)
+	}
+}
+
+func TestUpdateStackDraftVersionConflict(t *testing.T) {
+	database.InitDBForTesting()
+	createDraft(t, "web", "services: {}")
+
+	router := setupDraftRouter()
+	payload := map[string]string{"data": "services:\n  web:\n    image: nginx\n"}
+
+	w := sendDraftRequest(router, "PUT", "/drafts/web", `"1"`, payload)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %v: %s", w.Code, w.Body.String())
+	}
+
+	if w.Header().Get("ETag") != `"2"` {
+		t.Errorf("expected ETag \"2\", got %v", w.Header().Get("ETag"))
+	}
+
+	w = sendDraftRequest(router, "PUT", "/drafts/web", `"1"`, payload)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected status 412, got %v", w.Code)
+	}
+
+	w = sendDraftRequest(router, "PUT", "/drafts/web", "", payload)
+	if w.Code != http.StatusPreconditionRequired {
+		t.Errorf("expected status 428, got %v", w.Code)
+	}
+
+	w = sendDraftRequest(router, "PUT", "/drafts/web", `"two"`, payload)
+	if w.Code != http.StatusPreconditionFailed || !strings.Contains(w.Body.String(), "Invalid If-Match header") {
+		t.Errorf("expected status 412 for a malformed If-Match header, got %v: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPatchStackDraftRename(t *testing.T) {
+	database.InitDBForTesting()
+	createDraft(t, "wbe", "services: {}")
+	createDraft(t, "api", "services: {}")
+	database.DB.Create(&models.StackVariable{DraftName: "wbe", Key: "TAG", Value: "1.0"})
+	database.DB.Create(&models.StackDraftFile{DraftName: "wbe", Path: "nginx.conf", Content: []byte("events {}")})
+	database.DB.Create(&models.StackRevision{StackName: "wbe", Revision: 1, Kind: models.RevisionKindDraft})
+	database.DB.Create(&models.StackRevision{StackName: "web", Revision: 1, Kind: models.RevisionKindDeploy})
+
+	router := setupDraftRouter()
+
+	w := sendDraftRequest(router, "PATCH", "/drafts/wbe", `"1"`, map[string]string{"name": "api"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for duplicate name, got %v", w.Code)
+	}
+
+	var count int64
+	database.DB.Model(&models.StackVariable{}).Where("draft_name = ?", "wbe").Count(&count)
+	if count != 1 {
+		t.Errorf("expected variables to stay with the draft after a failed rename, got %d", count)
+	}
+
+	w = sendDraftRequest(router, "PATCH", "/drafts/wbe", `"1"`, map[string]string{"name": "web"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %v: %s", w.Code, w.Body.String())
+	}
+
+	var draft models.StackDraft
+	if err := database.DB.Where("name = ?", "web").First(&draft).Error; err != nil {
+		t.Fatalf("renamed draft not found: %v", err)
+	}
+
+	if draft.Data != "services: {}" {
+		t.Errorf("expected data to be kept, got %v", draft.Data)
+	}
+
+	for _, model := range []interface{}{&models.StackVariable{}, &models.StackDraftFile{}} {
+		database.DB.Model(model).Where("draft_name = ?", "web").Count(&count)
+		if count != 1 {
+			t.Errorf("expected %T to move with the draft, got %d", model, count)
+		}
+	}
+
+	var revisions []models.StackRevision
+	database.DB.Where("stack_name = ?", "web").Order("revision").Find(&revisions)
+	if len(revisions) != 2 || revisions[1].Revision != 2 || revisions[1].Kind != models.RevisionKindDraft {
+		t.Errorf("expected the draft history to follow the existing history of web, got %+v", revisions)
+	}
+}
+
+func TestDeleteStackDraft(t *testing.T) {
+	database.InitDBForTesting()
+	createDraft(t, "web", "services: {}")
+
+	router := setupDraftRouter()
+
+	w := sendDraftRequest(router, "DELETE", "/drafts/web", "", nil)
+	if w.Code != http.StatusPreconditionRequired {
+		t.Errorf("expected status 428, got %v", w.Code)
+	}
+
+	w = sendDraftRequest(router, "DELETE", "/drafts/web", `"3"`, nil)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected status 412, got %v", w.Code)
+	}
+
+	w = sendDraftRequest(router, "DELETE", "/drafts/web", `"1"`, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %v", w.Code)
+	}
+
+	createDraft(t, "web", "services: {}")
+}
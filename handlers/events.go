@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dockrelix/dockrelix-backend/docker"
+	"github.com/gin-contrib/sse"
+	"golang.org/x/net/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// eventKeepAlive is how often an idle event stream is written to, so proxies keep it open.
+const eventKeepAlive = 30 * time.Second
+
+// StreamEvents streams the events of the swarm over a WebSocket or as server-sent events, filtered by the repeated
+// type, stack and action parameters. A client resumes after the cursor parameter, or the Last-Event-ID header the
+// browser sends on reconnect, and gets a reset event first when the events since then are no longer known.
+func StreamEvents(broker *docker.EventBroker, c *gin.Context) {
+	filter := docker.EventFilter{
+		Types:   c.QueryArray("type"),
+		Stacks:  c.QueryArray("stack"),
+		Actions: c.QueryArray("action"),
+	}
+	for _, kind := range filter.Types {
+		if !slices.Contains(docker.EventTypes, kind) {
+			c.JSON(400, gin.H{"error": "Unknown event type " + kind + ", expected one of " + strings.Join(docker.EventTypes, ", ")})
+			return
+		}
+	}
+
+	websocketRequest := c.IsWebsocket()
+	if !websocketRequest && !strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+		c.JSON(406, gin.H{"error": "Events are streamed over a WebSocket or as text/event-stream"})
+		return
+	}
+
+	cursor := c.Query("cursor")
+	if cursor == "" {
+		cursor = c.GetHeader("Last-Event-ID")
+	}
+	subscription := broker.Subscribe(cursor, filter)
+	defer subscription.Close()
+
+	if websocketRequest {
+		websocket.Server{Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			// The client sends nothing, reading only notices it went away and ends the subscription.
+			go func() {
+				io.Copy(io.Discard, ws)
+				subscription.Close()
+			}()
+
+			if subscription.Reset {
+				if websocket.JSON.Send(ws, gin.H{"type": "reset"}) != nil {
+					return
+				}
+			}
+			for _, event := range subscription.Replay {
+				if websocket.JSON.Send(ws, event) != nil {
+					return
+				}
+			}
+			for event := range subscription.Events {
+				if websocket.JSON.Send(ws, event) != nil {
+					return
+				}
+			}
+		}}.ServeHTTP(c.Writer, c.Request)
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	if subscription.Reset {
+		c.SSEvent("reset", gin.H{})
+	}
+	for _, event := range subscription.Replay {
+		c.Render(-1, sse.Event{Id: event.Cursor, Event: event.Type, Data: event})
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-keepAlive.C:
+			io.WriteString(c.Writer, ": keep-alive\n\n")
+		case event, ok := <-subscription.Events:
+			if !ok {
+				return
+			}
+			c.Render(-1, sse.Event{Id: event.Cursor, Event: event.Type, Data: event})
+		}
+		c.Writer.Flush()
+	}
+}
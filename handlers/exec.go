@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/docker/docker/client"
+	"github.com/dockrelix/dockrelix-backend/docker"
+	"golang.org/x/net/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// execMessage is sent by the client of an exec: input for stdin or resize with the new size of the terminal.
+type execMessage struct {
+	Type string `json:"type"`
+	Data string `json:"data"`
+	Cols uint   `json:"cols"`
+	Rows uint   `json:"rows"`
+}
+
+func queryUint(c *gin.Context, key string, fallback uint) uint {
+	value, err := strconv.ParseUint(c.Query(key), 10, 16)
+	if err != nil || value == 0 {
+		return fallback
+	}
+	return uint(value)
+}
+
+// ExecService opens a shell in a task container over a WebSocket. The command is given by repeated cmd parameters
+// and has to be allowed, the task by the task parameter or else the first running one. Output is sent as binary
+// messages, the client sends execMessage as JSON, and the session ends with an exit message holding the exit code.
+func ExecService(cli *client.Client, c *gin.Context) {
+	if !c.IsWebsocket() {
+		c.JSON(400, gin.H{"error": "WebSocket upgrade required"})
+		return
+	}
+
+	cmd := c.QueryArray("cmd")
+	if len(cmd) == 0 {
+		cmd = []string{"sh"}
+	}
+
+	exec, err := docker.StartExec(cli, c.Param("name"), c.Param("service"), c.Query("task"), cmd,
+		currentUsername(c), queryUint(c, "cols", 80), queryUint(c, "rows", 24))
+	switch {
+	case errors.Is(err, docker.ErrCommandNotAllowed):
+		c.JSON(403, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, docker.ErrServiceNotFound), errors.Is(err, docker.ErrTaskNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, docker.ErrTaskNotRunning), errors.Is(err, docker.ErrTaskNotLocal):
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": "Exec could not be started: " + err.Error()})
+		return
+	}
+
+	websocket.Server{Handler: func(ws *websocket.Conn) {
+		defer ws.Close()
+
+		go func() {
+			for {
+				var message execMessage
+				if err := websocket.JSON.Receive(ws, &message); err != nil {
+					exec.Close()
+					return
+				}
+
+				var err error
+				switch message.Type {
+				case "input":
+					err = exec.Input([]byte(message.Data))
+				case "resize":
+					err = exec.Resize(message.Cols, message.Rows)
+				default:
+					err = fmt.Errorf("unknown message type %q", message.Type)
+				}
+				if err != nil {
+					websocket.JSON.Send(ws, gin.H{"type": "error", "error": err.Error()})
+				}
+			}
+		}()
+
+		err := exec.Output(func(data []byte) error {
+			return websocket.Message.Send(ws, data)
+		})
+		session := exec.Close()
+		if err != nil {
+			websocket.JSON.Send(ws, gin.H{"type": "error", "error": err.Error()})
+		}
+		websocket.JSON.Send(ws, gin.H{"type": "exit", "code": session.ExitCode, "session": session.ID})
+	}}.ServeHTTP(c.Writer, c.Request)
+}
+
+// GetExecSessions lists the recorded exec sessions, of one stack when the stack parameter is set.
+func GetExecSessions(c *gin.Context) {
+	sessions, err := docker.ListExecSessions(c.Query("stack"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, sessions)
+}
+
+// GetExecRecording downloads the recording of an exec session as an asciicast file.
+func GetExecRecording(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid session"})
+		return
+	}
+
+	recording, err := docker.GetExecRecording(uint(id))
+	if errors.Is(err, docker.ErrExecSessionNotFound) {
+		c.JSON(404, gin.H{"error": "Session not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=exec-%d.cast", id))
+	c.Data(200, "application/x-asciicast", recording)
+}
@@ -0,0 +1,257 @@
+package handlers_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dockrelix/dockrelix-backend/database"
+	"github.com/dockrelix/dockrelix-backend/docker"
+	"github.com/dockrelix/dockrelix-backend/handlers"
+	"github.com/dockrelix/dockrelix-backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+func buildTarGz(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("failed to write archive: %v", err)
+		}
+		_, _ = tw.Write([]byte(content))
+	}
+	_ = tw.Close()
+	_ = gz.Close()
+	return buf.Bytes()
+}
+
+func sendImportRequest(router *gin.Engine, name, filename string, content []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	_ = writer.WriteField("name", name)
+	part, _ := writer.CreateFormFile("file", filename)
+	_, _ = part.Write(content)
+	_ = writer.Close()
+
+	req, _ := http.NewRequest("POST", "/drafts/import", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestImportStackDraft(t *testing.T) {
+	database.InitDBForTesting()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.POST("/drafts/import", handlers.ImportStackDraft)
+
+	archive := buildTarGz(t, map[string]string{
+		"app/docker-compose.yml": `services:
+  web:
+    image: nginx:${TAG}
+    build: .
+    depends_on: [db]
+    configs:
+      - source: site
+        target: /etc/nginx/conf.d/site.conf
+    secrets:
+      - source: token
+        target: token
+  db:
+    image: postgres
+configs:
+  site:
+    file: ./nginx/site.conf
+secrets:
+  token:
+    file: /run/token
+`,
+		"app/docker-compose.override.yml": "services:\n  web:\n    deploy:\n      replicas: 2\n",
+		"app/.env":                        "TAG=1.25\n",
+		"app/nginx/site.conf":             "server {}\n",
+	})
+
+	w := sendImportRequest(router, "web", "app.tar.gz", archive)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %v: %s", w.Code, w.Body.String())
+	}
+
+	var report models.ImportReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("failed to decode report: %v", err)
+	}
+	if len(report.ComposeFiles) != 2 || len(report.Files) != 1 || report.Files[0] != "nginx/site.conf" {
+		t.Errorf("expected both compose files and the config file, got %+v", report)
+	}
+	if len(report.Unsupported) != 3 {
+		t.Errorf("expected build, depends_on and the absolute secret file to be reported, got %+v", report.Unsupported)
+	}
+	if strings.Contains(report.Draft.Data, "build") || strings.Contains(report.Draft.Data, "depends_on") {
+		t.Errorf("expected unsupported keys to be dropped, got:\n%s", report.Draft.Data)
+	}
+
+	draft, err := docker.GetDraft("web")
+	if err != nil {
+		t.Fatalf("expected draft to be created, got %v", err)
+	}
+	config, err := docker.RenderDraft(draft, "")
+	if err != nil {
+		t.Fatalf("expected draft to render, got %v", err)
+	}
+	if config.Services["web"].Image != "nginx:1.25" || config.Services["web"].Deploy.Replicas != 2 {
+		t.Errorf("expected .env and override to be applied, got %+v", config.Services["web"])
+	}
+
+	files, err := docker.GetDraftFiles("web")
+	if err != nil || string(files["nginx/site.conf"]) != "server {}\n" {
+		t.Errorf("expected config file to be stored, got %v, %v", files, err)
+	}
+
+	w = sendImportRequest(router, "web", "docker-compose.yml", []byte("services:\n  web:\n    image: nginx\n"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an existing draft, got %v", w.Code)
+	}
+}
+
+func TestImportStackDraftThirdPartyCompose(t *testing.T) {
+	database.InitDBForTesting()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.POST("/drafts/import", handlers.ImportStackDraft)
+
+	compose := `version: "3.8"
+
+services:
+  app:
+    image: nextcloud:${NEXTCLOUD_VERSION:-29}-apache
+    container_name: nextcloud
+    restart: unless-stopped
+    depends_on:
+      db:
+        condition: service_healthy
+      redis:
+        condition: service_started
+    env_file: .env
+    environment:
+      POSTGRES_HOST: db
+      POSTGRES_DB: nextcloud
+      POSTGRES_USER: nextcloud
+      POSTGRES_PASSWORD_FILE: /run/secrets/db_password
+      REDIS_HOST: redis
+      NEXTCLOUD_TRUSTED_DOMAINS:
+    ports:
+      - "8080:80"
+    volumes:
+      - nextcloud:/var/www/html
+    secrets:
+      - db_password
+    networks:
+      frontend:
+      backend:
+        aliases:
+          - nextcloud-app
+    labels:
+      - traefik.enable=true
+    healthcheck:
+      test: curl -f http://localhost/status.php || exit 1
+      interval: 30s
+      timeout: 10s
+      retries: 3
+
+  db:
+    image: postgres:16-alpine
+    restart: always
+    environment:
+      - POSTGRES_DB=nextcloud
+      - POSTGRES_USER=nextcloud
+      - POSTGRES_PASSWORD_FILE=/run/secrets/db_password
+    volumes:
+      - db:/var/lib/postgresql/data
+    secrets:
+      - db_password
+    networks:
+      - backend
+    shm_size: 128mb
+
+  redis:
+    image: redis:7-alpine
+    restart: always
+    networks:
+      - backend
+
+volumes:
+  nextcloud:
+  db:
+
+networks:
+  frontend:
+    external:
+      name: proxy
+  backend:
+
+secrets:
+  db_password:
+    file: ./db_password.txt
+`
+
+	archive := buildTarGz(t, map[string]string{
+		"docker-compose.yml": compose,
+		".env":               "NEXTCLOUD_VERSION=30\n",
+		"db_password.txt":    "secret\n",
+	})
+
+	w := sendImportRequest(router, "nextcloud", "nextcloud.tar.gz", archive)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %v: %s", w.Code, w.Body.String())
+	}
+
+	var report models.ImportReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("failed to decode report: %v", err)
+	}
+	dropped := map[string]bool{}
+	for _, unsupported := range report.Unsupported {
+		dropped[unsupported.Path] = true
+	}
+	for _, key := range []string{"services.app.container_name", "services.app.restart", "services.app.depends_on", "services.app.env_file", "services.db.restart", "services.db.shm_size", "services.redis.restart"} {
+		if !dropped[key] {
+			t.Errorf("expected %s to be reported, got %+v", key, report.Unsupported)
+		}
+	}
+	if len(report.Files) != 1 || report.Files[0] != "db_password.txt" {
+		t.Errorf("expected the secret file to be stored, got %+v", report.Files)
+	}
+
+	draft, err := docker.GetDraft("nextcloud")
+	if err != nil {
+		t.Fatalf("expected draft to be created, got %v", err)
+	}
+	config, err := docker.RenderDraft(draft, "")
+	if err != nil {
+		t.Fatalf("expected draft to render, got %v", err)
+	}
+	app := config.Services["app"]
+	if app.Image != "nextcloud:30-apache" || len(app.Environment) != 6 || app.Secrets[0].Source != "db_password" {
+		t.Errorf("expected the app service to be carried over, got %+v", app)
+	}
+	if test := app.Healthcheck.Test; len(test) != 2 || test[0] != "CMD-SHELL" {
+		t.Errorf("expected the healthcheck string to run in a shell, got %v", test)
+	}
+	if len(app.Networks) != 2 || app.Networks[1].Name != "backend" || app.Networks[1].Aliases[0] != "nextcloud-app" {
+		t.Errorf("expected the networks with their aliases, got %+v", app.Networks)
+	}
+	if frontend := config.Networks["frontend"]; !frontend.External || frontend.Name != "proxy" {
+		t.Errorf("expected the external network to keep its name, got %+v", frontend)
+	}
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"github.com/dockrelix/dockrelix-backend/docker"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConvertStackDraftToKubernetes renders a draft for an environment and returns it as Kubernetes manifests.
+func ConvertStackDraftToKubernetes(c *gin.Context) {
+	draft, ok := loadDraft(c)
+	if !ok {
+		return
+	}
+
+	config, err := docker.RenderDraft(draft, c.Query("environment"))
+	if err != nil {
+		respondRenderError(c, err)
+		return
+	}
+
+	files, err := docker.GetDraftFiles(draft.Name)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := docker.ConvertKubernetes(config, draft.Name, files)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, result)
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/docker/docker/client"
+	"github.com/dockrelix/dockrelix-backend/docker"
+	"github.com/dockrelix/dockrelix-backend/models"
+	"golang.org/x/net/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ServiceLogs returns the logs of a service. A WebSocket upgrade streams them as JSON messages and an Accept header of
+// text/event-stream as server-sent events, both following the logs when follow=true. Any other request downloads
+// the logs as plain text without following them.
+func ServiceLogs(cli *client.Client, c *gin.Context) {
+	stackName, service := c.Param("name"), c.Param("service")
+	stream := c.IsWebsocket() || strings.Contains(c.GetHeader("Accept"), "text/event-stream")
+
+	options := docker.LogOptions{
+		Follow:     stream && c.Query("follow") == "true",
+		Since:      c.Query("since"),
+		Until:      c.Query("until"),
+		Tail:       c.Query("tail"),
+		Timestamps: c.Query("timestamps") == "true",
+		Stdout:     c.DefaultQuery("stdout", "true") == "true",
+		Stderr:     c.DefaultQuery("stderr", "true") == "true",
+		Task:       c.Query("task"),
+	}
+
+	logs, err := docker.OpenServiceLogs(c.Request.Context(), cli, stackName, service, options)
+	switch {
+	case errors.Is(err, docker.ErrServiceNotFound), errors.Is(err, docker.ErrTaskNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, docker.ErrInvalidLogOptions):
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": "Logs could not be read: " + err.Error()})
+		return
+	}
+	defer logs.Close()
+
+	switch {
+	case c.IsWebsocket():
+		websocket.Server{Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			// The client sends nothing, reading only notices it went away and ends the stream.
+			go func() {
+				io.Copy(io.Discard, ws)
+				logs.Close()
+			}()
+
+			err := logs.Each(func(line models.LogLine) error {
+				return websocket.JSON.Send(ws, line)
+			})
+			if err != nil {
+				websocket.JSON.Send(ws, gin.H{"error": err.Error()})
+			}
+		}}.ServeHTTP(c.Writer, c.Request)
+
+	case stream:
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Writer.Flush()
+
+		err := logs.Each(func(line models.LogLine) error {
+			c.SSEvent("log", line)
+			c.Writer.Flush()
+			return c.Request.Context().Err()
+		})
+		if err != nil && c.Request.Context().Err() == nil {
+			c.SSEvent("error", gin.H{"error": err.Error()})
+		} else {
+			c.SSEvent("end", gin.H{})
+		}
+		c.Writer.Flush()
+
+	default:
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s_%s.log", stackName, service))
+		c.Status(200)
+
+		logs.Each(func(line models.LogLine) error {
+			_, err := io.WriteString(c.Writer, docker.FormatLogLine(line)+"\n")
+			return err
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/dockrelix/dockrelix-backend/docker"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetMetrics returns the latest metrics of every stack on the node of the daemon, or of one stack when the name
+// parameter is set. Tasks on other nodes are not sampled.
+func GetMetrics(collector *docker.MetricsCollector, c *gin.Context) {
+	c.JSON(200, collector.Current(c.Param("name")))
+}
+
+// GetMetricsHistory returns the stored metrics of a service on the node of the daemon, or the totals of the stack without the service
+// parameter, for the duration given by the since parameter, the last hour by default.
+func GetMetricsHistory(c *gin.Context) {
+	since := time.Hour
+	if value := c.Query("since"); value != "" {
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			c.JSON(400, gin.H{"error": "Invalid since, expected a duration such as 30m or 6h"})
+			return
+		}
+		since = duration
+	}
+
+	metrics, err := docker.GetMetricsHistory(c.Param("name"), c.Query("service"), time.Now().Add(-since))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, metrics)
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"strconv"
+
+	"github.com/dockrelix/dockrelix-backend/docker"
+	"github.com/dockrelix/dockrelix-backend/models"
+	"github.com/dockrelix/dockrelix-backend/models/parser"
+
+	"github.com/gin-gonic/gin"
+)
+
+func currentUsername(c *gin.Context) string {
+	if value, ok := c.Get("user"); ok {
+		if user, ok := value.(models.User); ok {
+			return user.Username
+		}
+	}
+	return ""
+}
+
+// recordDeployRevision snapshots a deployed stack along with the config it was deployed with. The deploy has already
+// succeeded, so a failure is reported as such rather than as a failed deploy, it reports whether the revision was
+// recorded.
+func recordDeployRevision(c *gin.Context, stackName, data string, config parser.ComposeConfig, message string) bool {
+	if _, err := docker.SaveDeployRevision(stackName, data, config, currentUsername(c), message); err != nil {
+		log.Printf("Error saving revision of stack %s: %v", stackName, err)
+		c.JSON(500, gin.H{"error": "Stack was deployed but its revision could not be recorded: " + err.Error()})
+		return false
+	}
+	return true
+}
+
+func loadRevision(c *gin.Context, param string) (models.StackRevision, bool) {
+	number, err := strconv.ParseUint(c.Param(param), 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid revision number"})
+		return models.StackRevision{}, false
+	}
+
+	revision, err := docker.GetRevision(c.Param("name"), uint(number))
+	if errors.Is(err, docker.ErrRevisionNotFound) {
+		c.JSON(404, gin.H{"error": "Revision not found"})
+		return revision, false
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return revision, false
+	}
+
+	return revision, true
+}
+
+func GetStackRevisions(c *gin.Context) {
+	result := docker.GetRevisions(c.Param("name"))
+	c.JSON(200, result)
+}
+
+func GetStackRevision(c *gin.Context) {
+	revision, ok := loadRevision(c, "revision")
+	if !ok {
+		return
+	}
+	c.JSON(200, revision)
+}
+
+func DiffStackRevisions(c *gin.Context) {
+	from, ok := loadRevision(c, "revision")
+	if !ok {
+		return
+	}
+
+	to, ok := loadRevision(c, "other")
+	if !ok {
+		return
+	}
+
+	diff, err := docker.DiffRevisions(from, to)
+	if err != nil {
+		respondRenderError(c, err)
+		return
+	}
+
+	c.JSON(200, diff)
+}
+
+func RestoreStackRevision(c *gin.Context) {
+	revision, ok := loadRevision(c, "revision")
+	if !ok {
+		return
+	}
+
+	draft, err := docker.RestoreRevision(revision, currentUsername(c))
+	if err != nil {
+		respondDraftError(c, err)
+		return
+	}
+
+	c.Header("ETag", draftETag(draft))
+	c.JSON(200, draft)
+}
@@ -0,0 +1,148 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/dockrelix/dockrelix-backend/database"
+	"github.com/dockrelix/dockrelix-backend/docker"
+	"github.com/dockrelix/dockrelix-backend/handlers"
+	"github.com/dockrelix/dockrelix-backend/models"
+	"github.com/dockrelix/dockrelix-backend/models/parser"
+	"github.com/gin-gonic/gin"
+)
+
+func setupRevisionRouter() *gin.Engine {
+	router := setupDraftRouter()
+
+	router.POST("/drafts", func(c *gin.Context) {
+		c.Set("user", models.User{Username: "admin"})
+		handlers.CreateStackDraft(nil, c)
+	})
+	router.GET("/stacks/:name/revisions", handlers.GetStackRevisions)
+	router.GET("/stacks/:name/revisions/:revision/diff/:other", handlers.DiffStackRevisions)
+	router.POST("/stacks/:name/revisions/:revision/restore", handlers.RestoreStackRevision)
+
+	return router
+}
+
+func TestStackRevisions(t *testing.T) {
+	database.InitDBForTesting()
+
+	router := setupRevisionRouter()
+
+	w := sendDraftRequest(router, "POST", "/drafts", "", map[string]string{
+		"name":    "web",
+		"data":    "services:\n  web:\n    image: nginx:1.25\n",
+		"message": "Initial version",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %v: %s", w.Code, w.Body.String())
+	}
+
+	w = sendDraftRequest(router, "PUT", "/drafts/web", `"1"`, map[string]string{
+		"data": "services:\n  web:\n    image: nginx:1.27\n",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %v: %s", w.Code, w.Body.String())
+	}
+
+	w = sendDraftRequest(router, "GET", "/stacks/web/revisions", "", nil)
+	var revisions []models.StackRevision
+	if err := json.Unmarshal(w.Body.Bytes(), &revisions); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	if len(revisions) != 2 {
+		t.Fatalf("expected 2 revisions, got %d", len(revisions))
+	}
+
+	if revisions[1].Author != "admin" || revisions[1].Message != "Initial version" {
+		t.Errorf("unexpected first revision %+v", revisions[1])
+	}
+
+	w = sendDraftRequest(router, "GET", "/stacks/web/revisions/1/diff/2", "", nil)
+	var diff models.StackDiff
+	if err := json.Unmarshal(w.Body.Bytes(), &diff); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	if len(diff.Resources) != 1 || diff.Resources[0].Changes[0].Field != "image" {
+		t.Errorf("expected an image change, got %+v", diff.Resources)
+	}
+
+	w = sendDraftRequest(router, "POST", "/stacks/web/revisions/1/restore", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %v: %s", w.Code, w.Body.String())
+	}
+
+	var draft models.StackDraft
+	if err := database.DB.Where("name = ?", "web").First(&draft).Error; err != nil {
+		t.Fatalf("draft not found: %v", err)
+	}
+
+	if draft.Data != "services:\n  web:\n    image: nginx:1.25\n" || draft.Version != 3 {
+		t.Errorf("expected revision 1 to be restored, got %+v", draft)
+	}
+
+	w = sendDraftRequest(router, "POST", "/stacks/web/revisions/9/restore", "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %v", w.Code)
+	}
+}
+
+func TestRenameStackDraftKeepsDeployRevisions(t *testing.T) {
+	database.InitDBForTesting()
+
+	router := setupRevisionRouter()
+
+	data := "services:\n  web:\n    image: nginx:1.25\n"
+	w := sendDraftRequest(router, "POST", "/drafts", "", map[string]string{"name": "web", "data": data})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %v: %s", w.Code, w.Body.String())
+	}
+	config, err := docker.RenderCompose(data, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := docker.SaveDeployRevision("web", data, config, "admin", "Deployed"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	w = sendDraftRequest(router, "PATCH", "/drafts/web", `"1"`, map[string]string{"name": "site"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %v: %s", w.Code, w.Body.String())
+	}
+
+	old := docker.GetRevisions("web")
+	if len(old) != 1 || old[0].Kind != models.RevisionKindDeploy {
+		t.Errorf("expected the deploy revision to stay with the deployed stack, got %+v", old)
+	}
+	renamed := docker.GetRevisions("site")
+	if len(renamed) != 1 || renamed[0].Kind != models.RevisionKindDraft {
+		t.Errorf("expected the draft revision to move with the draft, got %+v", renamed)
+	}
+}
+
+func TestDiffStackRevisionsInvalidData(t *testing.T) {
+	database.InitDBForTesting()
+
+	router := setupRevisionRouter()
+
+	for _, data := range []string{"services:\n  web:\n    image: nginx\n", "services:\n  web:\n    image: [nginx]\n"} {
+		if _, err := docker.SaveDeployRevision("web", data, parser.ComposeConfig{}, "admin", ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	w := sendDraftRequest(router, "GET", "/stacks/web/revisions/1/diff/2", "", nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid compose file, got %v: %s", w.Code, w.Body.String())
+	}
+
+	w = sendDraftRequest(router, "GET", "/stacks/web/revisions/1/diff/3", "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for a missing revision, got %v", w.Code)
+	}
+}
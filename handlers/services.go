@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	"github.com/dockrelix/dockrelix-backend/docker"
+	"github.com/dockrelix/dockrelix-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultScaleTimeout is how long a scale request waits for the tasks when it asks to wait but names no timeout.
+const defaultScaleTimeout = 60
+
+// followUpdateTimeout bounds how long the progress of an update is streamed.
+const followUpdateTimeout = 30 * time.Minute
+
+// ScaleService sets the number of replicas of a service. When asked to wait it responds once the tasks are running,
+// or with 202 when they are still converging after the timeout, which is capped at followUpdateTimeout.
+func ScaleService(cli *client.Client, c *gin.Context) {
+	var request struct {
+		Replicas *uint64 `json:"replicas" binding:"required"`
+		Wait     bool    `json:"wait"`
+		Timeout  int     `json:"timeout" binding:"min=0"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	var wait time.Duration
+	if request.Wait {
+		if request.Timeout == 0 {
+			request.Timeout = defaultScaleTimeout
+		}
+		wait = min(time.Duration(request.Timeout)*time.Second, followUpdateTimeout)
+	}
+
+	result, converged, err := docker.ScaleService(c.Request.Context(), cli, c.Param("name"), c.Param("service"), *request.Replicas, wait)
+	switch {
+	case errors.Is(err, docker.ErrServiceNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, docker.ErrServiceNotScalable):
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": "Service could not be scaled: " + err.Error()})
+		return
+	}
+
+	if !converged {
+		c.JSON(202, result)
+		return
+	}
+	c.JSON(200, result)
+}
+
+// RestartService replaces every task of a service.
+func RestartService(cli *client.Client, c *gin.Context) {
+	result, srv, err := docker.RestartService(cli, c.Param("name"), c.Param("service"))
+	respondServiceUpdate(cli, c, result, srv, err)
+}
+
+// UpdateServiceImage rolls a service out with another image, resolve pins the image to its current digest.
+func UpdateServiceImage(cli *client.Client, c *gin.Context) {
+	var request struct {
+		Image   string `json:"image" binding:"required"`
+		Resolve bool   `json:"resolve"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, srv, err := docker.UpdateServiceImage(cli, c.Param("name"), c.Param("service"), request.Image, request.Resolve)
+	respondServiceUpdate(cli, c, result, srv, err)
+}
+
+// respondServiceUpdate returns the result of an update, or streams its progress as server-sent events when the
+// client accepts text/event-stream: a progress event each time it changes, followed by a done or an error event.
+// The service is the one read before the update.
+func respondServiceUpdate(cli *client.Client, c *gin.Context, result models.ServiceUpdate, srv swarm.Service, err error) {
+	switch {
+	case errors.Is(err, docker.ErrServiceNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, docker.ErrInvalidImage):
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": "Service could not be updated: " + err.Error()})
+		return
+	}
+
+	if !strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+		c.JSON(200, result)
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.SSEvent("update", result)
+	c.Writer.Flush()
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), followUpdateTimeout)
+	defer cancel()
+	err = docker.FollowServiceUpdate(ctx, cli, srv, func(progress models.ServiceUpdateProgress) {
+		c.SSEvent("progress", progress)
+		c.Writer.Flush()
+	})
+	if err != nil {
+		c.SSEvent("error", gin.H{"error": err.Error()})
+	} else {
+		c.SSEvent("done", gin.H{})
+	}
+	c.Writer.Flush()
+}
+
+// ListTasks lists the tasks of a stack, or of a single service, with running=true leaving out their history.
+func ListTasks(cli *client.Client, c *gin.Context) {
+	tasks, err := docker.ListTasks(cli, c.Param("name"), c.Param("service"), c.Query("running") == "true")
+	switch {
+	case errors.Is(err, docker.ErrStackNotFound):
+		c.JSON(404, gin.H{"error": "Stack not found"})
+		return
+	case errors.Is(err, docker.ErrServiceNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, tasks)
+}
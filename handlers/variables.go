@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/dockrelix/dockrelix-backend/docker"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joho/godotenv"
+)
+
+// respondRenderError answers with every validation error when a compose file could not be rendered.
+func respondRenderError(c *gin.Context, err error) {
+	var invalid *docker.InvalidComposeError
+	if errors.As(err, &invalid) {
+		c.JSON(400, gin.H{"error": "Invalid compose file", "errors": invalid.Errors})
+		return
+	}
+	c.JSON(500, gin.H{"error": err.Error()})
+}
+
+func GetStackDraftVariables(c *gin.Context) {
+	draft, ok := loadDraft(c)
+	if !ok {
+		return
+	}
+
+	variables, err := docker.ListVariables(draft.Name, c.Query("environment"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, variables)
+}
+
+// SetStackDraftVariables replaces the variables of one environment, given as a map or as the contents of a .env file.
+func SetStackDraftVariables(c *gin.Context) {
+	var request struct {
+		Variables map[string]string `json:"variables"`
+		Env       string            `json:"env"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	variables := map[string]string{}
+	if request.Env != "" {
+		parsed, err := godotenv.Unmarshal(request.Env)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid .env file: " + err.Error()})
+			return
+		}
+		variables = parsed
+	}
+	for key, value := range request.Variables {
+		variables[key] = value
+	}
+
+	draft, ok := loadDraft(c)
+	if !ok {
+		return
+	}
+
+	err := docker.SetVariables(draft.Name, c.Query("environment"), variables)
+	if errors.Is(err, docker.ErrInvalidVariableName) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, variables)
+}
+
+func RenderStackDraft(c *gin.Context) {
+	draft, ok := loadDraft(c)
+	if !ok {
+		return
+	}
+
+	config, err := docker.RenderDraft(draft, c.Query("environment"))
+	if err != nil {
+		respondRenderError(c, err)
+		return
+	}
+
+	c.JSON(200, config)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -26,17 +27,50 @@ func main() {
 	}
 	defer cli.Close()
 
-	r := gin.Default()
+	broker := docker.NewEventBroker(cli)
+	go broker.Run(context.Background())
+
+	metrics := docker.NewMetricsCollector(cli)
+	go metrics.Run(context.Background())
+
+	r := gin.New()
+	r.Use(middleware.Logger(), gin.Recovery())
 
 	auth := r.Group("/auth")
 	{
 		auth.POST("/login", handlers.Login)
+		auth.POST("/stream-token", middleware.JWTAuth(), handlers.StreamToken)
 
 		// Setup routes
 		auth.GET("/is-setup", handlers.IsSetup)
 		auth.POST("/setup", handlers.Setup)
 	}
 
+	// Browsers open these without an Authorization header, so they also take a stream token from the query.
+	streams := r.Group("/docker")
+	streams.Use(middleware.StreamAuth())
+	{
+		streams.GET("/events", func(c *gin.Context) {
+			handlers.StreamEvents(broker, c)
+		})
+
+		streams.GET("/stacks/:name/services/:service/logs", func(c *gin.Context) {
+			handlers.ServiceLogs(cli, c)
+		})
+
+		streams.GET("/stacks/:name/services/:service/exec", func(c *gin.Context) {
+			handlers.ExecService(cli, c)
+		})
+
+		streams.POST("/stacks/:name/services/:service/restart", func(c *gin.Context) {
+			handlers.RestartService(cli, c)
+		})
+
+		streams.POST("/stacks/:name/services/:service/image", func(c *gin.Context) {
+			handlers.UpdateServiceImage(cli, c)
+		})
+	}
+
 	docker := r.Group("/docker")
 	docker.Use(middleware.JWTAuth())
 	{
@@ -44,17 +78,121 @@ func main() {
 			handlers.ListStacks(cli, c)
 		})
 
+		docker.GET("/node/metrics", func(c *gin.Context) {
+			handlers.GetMetrics(metrics, c)
+		})
+
+		docker.GET("/node/stacks/:name/metrics", func(c *gin.Context) {
+			handlers.GetMetrics(metrics, c)
+		})
+
+		docker.GET("/node/stacks/:name/metrics/history", func(c *gin.Context) {
+			handlers.GetMetricsHistory(c)
+		})
+
 		docker.GET("/stacks/:name", func(c *gin.Context) {
 			handlers.ParseStackConfig(cli, c)
 		})
 
+		docker.DELETE("/stacks/:name", func(c *gin.Context) {
+			handlers.RemoveStack(cli, c)
+		})
+
+		docker.POST("/stacks/:name/rollback", func(c *gin.Context) {
+			handlers.RollbackStack(cli, c)
+		})
+
+		docker.GET("/stacks/:name/tasks", func(c *gin.Context) {
+			handlers.ListTasks(cli, c)
+		})
+
+		docker.GET("/stacks/:name/services/:service/tasks", func(c *gin.Context) {
+			handlers.ListTasks(cli, c)
+		})
+
+		docker.GET("/exec/sessions", func(c *gin.Context) {
+			handlers.GetExecSessions(c)
+		})
+
+		docker.GET("/exec/sessions/:id/recording", func(c *gin.Context) {
+			handlers.GetExecRecording(c)
+		})
+
+		docker.POST("/stacks/:name/services/:service/scale", func(c *gin.Context) {
+			handlers.ScaleService(cli, c)
+		})
+
+		docker.GET("/stacks/:name/revisions", func(c *gin.Context) {
+			handlers.GetStackRevisions(c)
+		})
+
+		docker.GET("/stacks/:name/revisions/:revision", func(c *gin.Context) {
+			handlers.GetStackRevision(c)
+		})
+
+		docker.GET("/stacks/:name/revisions/:revision/diff/:other", func(c *gin.Context) {
+			handlers.DiffStackRevisions(c)
+		})
+
+		docker.POST("/stacks/:name/revisions/:revision/restore", func(c *gin.Context) {
+			handlers.RestoreStackRevision(c)
+		})
+
+		docker.POST("/stacks/validate", func(c *gin.Context) {
+			handlers.ValidateStackConfig(c)
+		})
+
 		docker.POST("/stacks/draft", func(c *gin.Context) {
 			handlers.CreateStackDraft(cli, c)
 		})
 
+		docker.POST("/stacks/drafts/import", func(c *gin.Context) {
+			handlers.ImportStackDraft(c)
+		})
+
 		docker.GET("/stacks/drafts", func(c *gin.Context) {
 			handlers.GetStackDrafts(c)
 		})
+
+		docker.GET("/stacks/drafts/:name", func(c *gin.Context) {
+			handlers.GetStackDraft(c)
+		})
+
+		docker.PUT("/stacks/drafts/:name", func(c *gin.Context) {
+			handlers.UpdateStackDraft(c)
+		})
+
+		docker.PATCH("/stacks/drafts/:name", func(c *gin.Context) {
+			handlers.PatchStackDraft(c)
+		})
+
+		docker.DELETE("/stacks/drafts/:name", func(c *gin.Context) {
+			handlers.DeleteStackDraft(c)
+		})
+
+		docker.GET("/stacks/drafts/:name/diff", func(c *gin.Context) {
+			handlers.DiffStackDraft(cli, c)
+		})
+
+		docker.GET("/stacks/drafts/:name/variables", func(c *gin.Context) {
+			handlers.GetStackDraftVariables(c)
+		})
+
+		docker.PUT("/stacks/drafts/:name/variables", func(c *gin.Context) {
+			handlers.SetStackDraftVariables(c)
+		})
+
+		docker.GET("/stacks/drafts/:name/render", func(c *gin.Context) {
+			handlers.RenderStackDraft(c)
+		})
+
+		docker.GET("/stacks/drafts/:name/kubernetes", func(c *gin.Context) {
+			handlers.ConvertStackDraftToKubernetes(c)
+		})
+
+		docker.POST("/stacks/drafts/:name/deploy", func(c *gin.Context) {
+			handlers.DeployStackDraft(cli, c)
+		})
 	}
 
 	log.Fatal(r.Run(":" + os.Getenv("PORT")))
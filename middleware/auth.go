@@ -10,16 +10,35 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// StreamScope marks the short-lived tokens handed out for WebSocket and event stream requests, which browsers open
+// without a way to set the Authorization header. Those tokens are only accepted from the token query parameter of
+// the routes signed in with StreamAuth.
+const StreamScope = "stream"
+
+// JWTAuth signs in requests with the token from the Authorization header, stream tokens are refused.
 func JWTAuth() gin.HandlerFunc {
+	return authenticate(false)
+}
+
+// StreamAuth is JWTAuth for the routes that stream logs, exec sessions, events and update progress, which also accept
+// a stream token as the token query parameter.
+func StreamAuth() gin.HandlerFunc {
+	return authenticate(true)
+}
+
+func authenticate(allowStreamToken bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		tokenString := c.GetHeader("Authorization")
+		tokenString := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		fromQuery := false
+		if tokenString == "" && allowStreamToken {
+			tokenString = c.Query("token")
+			fromQuery = true
+		}
 		if tokenString == "" {
 			c.AbortWithStatusJSON(401, gin.H{"error": "Authorization header required"})
 			return
 		}
 
-		tokenString = strings.TrimPrefix(tokenString, "Bearer ")
-
 		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 			return []byte(os.Getenv("JWT_SECRET")), nil
 		})
@@ -30,6 +49,15 @@ func JWTAuth() gin.HandlerFunc {
 		}
 
 		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+			isStreamToken := claims["scope"] == StreamScope
+			if fromQuery && !isStreamToken {
+				c.AbortWithStatusJSON(401, gin.H{"error": "Invalid token", "details": "Only stream tokens can be passed as a query parameter"})
+				return
+			}
+			if !fromQuery && isStreamToken {
+				c.AbortWithStatusJSON(401, gin.H{"error": "Invalid token", "details": "Stream tokens can only be passed as the token query parameter of a stream"})
+				return
+			}
 			var user models.User
 			err := database.DB.First(&user, claims["sub"]).Error
 			if err != nil {
@@ -0,0 +1,19 @@
+package models
+
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from,omitempty"`
+	To    interface{} `json:"to,omitempty"`
+}
+
+type ResourceDiff struct {
+	Type    string        `json:"type"`
+	Name    string        `json:"name"`
+	Action  string        `json:"action"`
+	Changes []FieldChange `json:"changes,omitempty"`
+}
+
+type StackDiff struct {
+	Name      string         `json:"name"`
+	Resources []ResourceDiff `json:"resources"`
+}
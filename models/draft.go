@@ -4,6 +4,24 @@ import "gorm.io/gorm"
 
 type StackDraft struct {
 	gorm.Model
-	Name string `gorm:"unique"`
-	Data string `gorm:"type:text"`
+	Name    string `gorm:"unique"`
+	Data    string `gorm:"type:text"`
+	Version uint   `gorm:"not null;default:1"`
+}
+
+// StackDraftFile holds a file the compose file of a draft refers to, such as the file of a config or secret,
+// keyed by the path as written in the compose file.
+type StackDraftFile struct {
+	gorm.Model
+	DraftName string `gorm:"uniqueIndex:idx_stack_draft_file"`
+	Path      string `gorm:"uniqueIndex:idx_stack_draft_file"`
+	Content   []byte
+}
+
+type ImportReport struct {
+	Draft        StackDraft        `json:"draft"`
+	ComposeFiles []string          `json:"compose_files"`
+	Files        []string          `json:"files"`
+	Variables    []string          `json:"variables"`
+	Unsupported  []ValidationError `json:"unsupported"`
 }
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ExecSession records an interactive exec into a task container, its recording in the asciicast v2 format, input
+// included, is stored in ExecRecordingChunk rows.
+type ExecSession struct {
+	gorm.Model
+	StackName   string `gorm:"index"`
+	Service     string
+	TaskID      string
+	NodeID      string
+	ContainerID string
+	Command     string
+	Username    string
+	StartedAt   time.Time
+	EndedAt     *time.Time
+	ExitCode    *int
+	Truncated   bool
+}
+
+// ExecRecordingChunk is a part of the recording of an exec session, written while the session runs so the recording
+// is kept even if the session never ends cleanly. The chunks of a session in ID order make up its asciicast file.
+type ExecRecordingChunk struct {
+	ID        uint `gorm:"primaryKey"`
+	SessionID uint `gorm:"index"`
+	Data      []byte
+}
@@ -0,0 +1,6 @@
+package models
+
+type KubernetesExport struct {
+	Manifests   string            `json:"manifests"`
+	Unsupported []ValidationError `json:"unsupported"`
+}
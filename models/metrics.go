@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// ServiceMetric is the resource usage of the tasks of a service at one point in time, rates are per second.
+// A metric with an empty Service holds the totals of its stack. Only the tasks on Node, the node of the daemon, are
+// sampled, so Tasks can fall short of ExpectedTasks, the running tasks swarm reports across the cluster. Tasks
+// without a memory limit are counted in UnlimitedTasks and left out of MemoryLimit.
+type ServiceMetric struct {
+	ID             uint      `gorm:"primaryKey" json:"-"`
+	Node           string    `json:"node"`
+	StackName      string    `gorm:"index:idx_service_metric" json:"stack"`
+	Service        string    `gorm:"index:idx_service_metric" json:"service,omitempty"`
+	Time           time.Time `gorm:"index" json:"time"`
+	Tasks          int       `json:"tasks"`
+	ExpectedTasks  uint64    `json:"expected_tasks"`
+	CPUPercent     float64   `json:"cpu_percent"`
+	MemoryUsage    uint64    `json:"memory_usage"`
+	MemoryLimit    uint64    `json:"memory_limit"`
+	UnlimitedTasks int       `json:"unlimited_tasks"`
+	NetworkRx      float64   `json:"network_rx"`
+	NetworkTx      float64   `json:"network_tx"`
+	BlockRead      float64   `json:"block_read"`
+	BlockWrite     float64   `json:"block_write"`
+}
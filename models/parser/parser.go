@@ -7,45 +7,100 @@ type ComposeConfig struct {
 	Volumes  map[string]Volume  `yaml:"volumes,omitempty"`
 	Configs  map[string]Config  `yaml:"configs,omitempty"`
 	Secrets  map[string]Secret  `yaml:"secrets,omitempty"`
+
+	Extensions map[string]interface{} `yaml:",inline"`
 }
 
 type Service struct {
-	Image       string       `yaml:"image"`
-	Ports       []string     `yaml:"ports,omitempty"`
-	Networks    []string     `yaml:"networks,omitempty"`
-	Deploy      DeployConfig `yaml:"deploy,omitempty"`
-	Volumes     []string     `yaml:"volumes,omitempty"`
-	Configs     []ConfigRef  `yaml:"configs,omitempty"`
-	Secrets     []SecretRef  `yaml:"secrets,omitempty"`
-	Environment []string     `yaml:"environment,omitempty"`
-	Healthcheck *Healthcheck `yaml:"healthcheck,omitempty"`
+	Image           string            `yaml:"image"`
+	Command         Command           `yaml:"command,omitempty"`
+	Entrypoint      Command           `yaml:"entrypoint,omitempty"`
+	User            string            `yaml:"user,omitempty"`
+	WorkingDir      string            `yaml:"working_dir,omitempty"`
+	Hostname        string            `yaml:"hostname,omitempty"`
+	Labels          StringMap         `yaml:"labels,omitempty"`
+	Ports           []ServicePort     `yaml:"ports,omitempty"`
+	Networks        ServiceNetworks   `yaml:"networks,omitempty"`
+	Deploy          DeployConfig      `yaml:"deploy,omitempty"`
+	Volumes         []ServiceVolume   `yaml:"volumes,omitempty"`
+	Tmpfs           StringList        `yaml:"tmpfs,omitempty"`
+	Configs         []ConfigRef       `yaml:"configs,omitempty"`
+	Secrets         []SecretRef       `yaml:"secrets,omitempty"`
+	Environment     KeyValueList      `yaml:"environment,omitempty"`
+	Healthcheck     *Healthcheck      `yaml:"healthcheck,omitempty"`
+	StopSignal      string            `yaml:"stop_signal,omitempty"`
+	StopGracePeriod string            `yaml:"stop_grace_period,omitempty"`
+	Init            *bool             `yaml:"init,omitempty"`
+	ReadOnly        bool              `yaml:"read_only,omitempty"`
+	CapAdd          []string          `yaml:"cap_add,omitempty"`
+	CapDrop         []string          `yaml:"cap_drop,omitempty"`
+	Sysctls         StringMap         `yaml:"sysctls,omitempty"`
+	Ulimits         map[string]Ulimit `yaml:"ulimits,omitempty"`
+	DNS             StringList        `yaml:"dns,omitempty"`
+	DNSSearch       StringList        `yaml:"dns_search,omitempty"`
+	DNSOpt          []string          `yaml:"dns_opt,omitempty"`
+	ExtraHosts      []string          `yaml:"extra_hosts,omitempty"`
+	Isolation       string            `yaml:"isolation,omitempty"`
+	Logging         *Logging          `yaml:"logging,omitempty"`
+
+	Extensions map[string]interface{} `yaml:",inline"`
 }
 
 type Network struct {
+	Name       string            `yaml:"name,omitempty"`
 	Driver     string            `yaml:"driver,omitempty"`
+	DriverOpts map[string]string `yaml:"driver_opts,omitempty"`
 	External   bool              `yaml:"external,omitempty"`
 	Labels     map[string]string `yaml:"labels,omitempty"`
 	Attachable bool              `yaml:"attachable,omitempty"`
 	Internal   bool              `yaml:"internal,omitempty"`
+	EnableIPv6 bool              `yaml:"enable_ipv6,omitempty"`
+	IPAM       *IPAM             `yaml:"ipam,omitempty"`
+}
+
+type IPAM struct {
+	Driver  string            `yaml:"driver,omitempty"`
+	Config  []IPAMPool        `yaml:"config,omitempty"`
+	Options map[string]string `yaml:"options,omitempty"`
+}
+
+type IPAMPool struct {
+	Subnet       string            `yaml:"subnet,omitempty"`
+	IPRange      string            `yaml:"ip_range,omitempty"`
+	Gateway      string            `yaml:"gateway,omitempty"`
+	AuxAddresses map[string]string `yaml:"aux_addresses,omitempty"`
 }
 
 type Volume struct {
-	Driver   string            `yaml:"driver,omitempty"`
-	External bool              `yaml:"external,omitempty"`
-	Labels   map[string]string `yaml:"labels,omitempty"`
+	Name       string            `yaml:"name,omitempty"`
+	Driver     string            `yaml:"driver,omitempty"`
+	DriverOpts map[string]string `yaml:"driver_opts,omitempty"`
+	External   bool              `yaml:"external,omitempty"`
+	Labels     map[string]string `yaml:"labels,omitempty"`
 }
 
 type Config struct {
-	File string `yaml:"file,omitempty"`
+	File           string            `yaml:"file,omitempty"`
+	Content        string            `yaml:"content,omitempty"`
+	External       bool              `yaml:"external,omitempty"`
+	Name           string            `yaml:"name,omitempty"`
+	Labels         map[string]string `yaml:"labels,omitempty"`
+	TemplateDriver string            `yaml:"template_driver,omitempty"`
 }
 
 type Secret struct {
-	File string `yaml:"file,omitempty"`
+	File           string            `yaml:"file,omitempty"`
+	External       bool              `yaml:"external,omitempty"`
+	Name           string            `yaml:"name,omitempty"`
+	Labels         map[string]string `yaml:"labels,omitempty"`
+	TemplateDriver string            `yaml:"template_driver,omitempty"`
 }
 
 type DeployConfig struct {
 	Mode           string         `yaml:"mode,omitempty"`
 	Replicas       int            `yaml:"replicas,omitempty"`
+	EndpointMode   string         `yaml:"endpoint_mode,omitempty"`
+	Labels         StringMap      `yaml:"labels,omitempty"`
 	UpdateConfig   *UpdateConfig  `yaml:"update_config,omitempty"`
 	RollbackConfig *UpdateConfig  `yaml:"rollback_config,omitempty"`
 	RestartPolicy  *RestartPolicy `yaml:"restart_policy,omitempty"`
@@ -54,9 +109,12 @@ type DeployConfig struct {
 }
 
 type UpdateConfig struct {
-	Parallelism int    `yaml:"parallelism,omitempty"`
-	Delay       string `yaml:"delay,omitempty"`
-	Order       string `yaml:"order,omitempty"`
+	Parallelism     int     `yaml:"parallelism,omitempty"`
+	Delay           string  `yaml:"delay,omitempty"`
+	FailureAction   string  `yaml:"failure_action,omitempty"`
+	Monitor         string  `yaml:"monitor,omitempty"`
+	MaxFailureRatio float32 `yaml:"max_failure_ratio,omitempty"`
+	Order           string  `yaml:"order,omitempty"`
 }
 
 type RestartPolicy struct {
@@ -67,8 +125,9 @@ type RestartPolicy struct {
 }
 
 type Placement struct {
-	Constraints []string              `yaml:"constraints,omitempty"`
-	Preferences []PlacementPreference `yaml:"preferences,omitempty"`
+	Constraints        []string              `yaml:"constraints,omitempty"`
+	Preferences        []PlacementPreference `yaml:"preferences,omitempty"`
+	MaxReplicasPerNode uint64                `yaml:"max_replicas_per_node,omitempty"`
 }
 
 type PlacementPreference struct {
@@ -87,18 +146,23 @@ type ResourceSpec struct {
 
 type ConfigRef struct {
 	Source string `yaml:"source"`
-	Target string `yaml:"target"`
+	Target string `yaml:"target,omitempty"`
 }
 
 type SecretRef struct {
 	Source string `yaml:"source"`
-	Target string `yaml:"target"`
+	Target string `yaml:"target,omitempty"`
+}
+
+type Logging struct {
+	Driver  string            `yaml:"driver,omitempty"`
+	Options map[string]string `yaml:"options,omitempty"`
 }
 
 type Healthcheck struct {
-	Test        []string `yaml:"test,omitempty"`
-	Interval    string   `yaml:"interval,omitempty"`
-	Timeout     string   `yaml:"timeout,omitempty"`
-	Retries     int      `yaml:"retries,omitempty"`
-	StartPeriod string   `yaml:"start_period,omitempty"`
+	Test        HealthcheckTest `yaml:"test,omitempty"`
+	Interval    string          `yaml:"interval,omitempty"`
+	Timeout     string          `yaml:"timeout,omitempty"`
+	Retries     int             `yaml:"retries,omitempty"`
+	StartPeriod string          `yaml:"start_period,omitempty"`
 }
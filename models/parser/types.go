@@ -0,0 +1,471 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// StringList accepts either a single string or a list of strings.
+type StringList []string
+
+func (l *StringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*l = StringList{node.Value}
+		return nil
+	}
+
+	var values []string
+	if err := node.Decode(&values); err != nil {
+		return err
+	}
+	*l = values
+	return nil
+}
+
+// Command accepts a list of arguments or a string that is split into arguments the way a shell would.
+type Command []string
+
+func (c *Command) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		var values []string
+		if err := node.Decode(&values); err != nil {
+			return err
+		}
+		*c = values
+		return nil
+	}
+
+	args, err := splitCommand(node.Value)
+	if err != nil {
+		return err
+	}
+	*c = args
+	return nil
+}
+
+// HealthcheckTest accepts a list such as ["CMD", "curl", "-f", "http://localhost"] or a string, which is run
+// with the container's default shell the same as ["CMD-SHELL", string].
+type HealthcheckTest []string
+
+func (t *HealthcheckTest) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*t = HealthcheckTest{"CMD-SHELL", node.Value}
+		return nil
+	}
+
+	var values []string
+	if err := node.Decode(&values); err != nil {
+		return err
+	}
+	*t = values
+	return nil
+}
+
+func splitCommand(value string) ([]string, error) {
+	args := []string{}
+	var current strings.Builder
+	inArg := false
+	var quote rune
+
+	runes := []rune(value)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else if r == '\\' && quote == '"' && i+1 < len(runes) {
+				i++
+				current.WriteRune(runes[i])
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == '\\' && i+1 < len(runes):
+			i++
+			current.WriteRune(runes[i])
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in command %q", value)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// StringMap accepts a mapping or a list of KEY=VALUE entries, as used for labels and sysctls.
+type StringMap map[string]string
+
+func (m *StringMap) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.SequenceNode {
+		var values map[string]string
+		if err := node.Decode(&values); err != nil {
+			return err
+		}
+		*m = values
+		return nil
+	}
+
+	var entries []string
+	if err := node.Decode(&entries); err != nil {
+		return err
+	}
+	result := StringMap{}
+	for _, entry := range entries {
+		key, value, _ := strings.Cut(entry, "=")
+		result[key] = value
+	}
+	*m = result
+	return nil
+}
+
+// Ulimit is either a single limit or a soft and a hard one.
+type Ulimit struct {
+	Single int `yaml:"-"`
+	Soft   int `yaml:"soft,omitempty"`
+	Hard   int `yaml:"hard,omitempty"`
+}
+
+func (u *Ulimit) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*u = Ulimit{}
+		return node.Decode(&u.Single)
+	}
+
+	var limits struct {
+		Soft int `yaml:"soft"`
+		Hard int `yaml:"hard"`
+	}
+	if err := node.Decode(&limits); err != nil {
+		return err
+	}
+	*u = Ulimit{Soft: limits.Soft, Hard: limits.Hard}
+	return nil
+}
+
+func (u Ulimit) MarshalYAML() (interface{}, error) {
+	if u.Single != 0 {
+		return u.Single, nil
+	}
+	return map[string]int{"soft": u.Soft, "hard": u.Hard}, nil
+}
+
+// ServicePort is a port in either the short "published:target/protocol" syntax, kept as is in Short,
+// or the long syntax which can also set the publish mode.
+type ServicePort struct {
+	Short     string `yaml:"-" json:"-"`
+	Target    uint32 `yaml:"target" json:"target"`
+	Published string `yaml:"published,omitempty" json:"published,omitempty"`
+	Protocol  string `yaml:"protocol,omitempty" json:"protocol,omitempty"`
+	Mode      string `yaml:"mode,omitempty" json:"mode,omitempty"`
+}
+
+type longPort ServicePort
+
+func (p *ServicePort) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*p = ServicePort{Short: node.Value}
+		return nil
+	}
+
+	var port longPort
+	if err := node.Decode(&port); err != nil {
+		return err
+	}
+	*p = ServicePort(port)
+	return nil
+}
+
+func (p ServicePort) MarshalYAML() (interface{}, error) {
+	if p.Short != "" {
+		return p.Short, nil
+	}
+	return longPort(p), nil
+}
+
+func (p ServicePort) MarshalJSON() ([]byte, error) {
+	if p.Short != "" {
+		return json.Marshal(p.Short)
+	}
+	return json.Marshal(longPort(p))
+}
+
+// ServiceVolume is a mount in either the short "source:target:mode" syntax, kept as is in Short,
+// or the long syntax which can also set the mount type and its options.
+type ServiceVolume struct {
+	Short    string               `yaml:"-" json:"-"`
+	Type     string               `yaml:"type" json:"type"`
+	Source   string               `yaml:"source,omitempty" json:"source,omitempty"`
+	Target   string               `yaml:"target" json:"target"`
+	ReadOnly bool                 `yaml:"read_only,omitempty" json:"read_only,omitempty"`
+	Bind     *ServiceVolumeBind   `yaml:"bind,omitempty" json:"bind,omitempty"`
+	Volume   *ServiceVolumeVolume `yaml:"volume,omitempty" json:"volume,omitempty"`
+	Tmpfs    *ServiceVolumeTmpfs  `yaml:"tmpfs,omitempty" json:"tmpfs,omitempty"`
+}
+
+type ServiceVolumeBind struct {
+	Propagation string `yaml:"propagation,omitempty" json:"propagation,omitempty"`
+}
+
+type ServiceVolumeVolume struct {
+	NoCopy  bool   `yaml:"nocopy,omitempty" json:"nocopy,omitempty"`
+	Subpath string `yaml:"subpath,omitempty" json:"subpath,omitempty"`
+}
+
+// ServiceVolumeTmpfs holds the size in bytes or with a unit ("64m") and the mode as an octal number.
+type ServiceVolumeTmpfs struct {
+	Size string `yaml:"size,omitempty" json:"size,omitempty"`
+	Mode string `yaml:"mode,omitempty" json:"mode,omitempty"`
+}
+
+type longVolume ServiceVolume
+
+func (v *ServiceVolume) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*v = ServiceVolume{Short: node.Value}
+		return nil
+	}
+
+	var volume longVolume
+	if err := node.Decode(&volume); err != nil {
+		return err
+	}
+	*v = ServiceVolume(volume)
+	return nil
+}
+
+func (v ServiceVolume) MarshalYAML() (interface{}, error) {
+	if v.Short != "" {
+		return v.Short, nil
+	}
+	return longVolume(v), nil
+}
+
+func (v ServiceVolume) MarshalJSON() ([]byte, error) {
+	if v.Short != "" {
+		return json.Marshal(v.Short)
+	}
+	return json.Marshal(longVolume(v))
+}
+
+// KeyValueList accepts a list of KEY=VALUE entries or a mapping, as used for the environment. A key mapped to
+// nothing becomes an entry without a value. The entries of a mapping are kept in document order.
+type KeyValueList []string
+
+func (l *KeyValueList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		var entries []string
+		if err := node.Decode(&entries); err != nil {
+			return err
+		}
+		*l = entries
+		return nil
+	}
+
+	entries := KeyValueList{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		switch {
+		case value.Kind == yaml.ScalarNode && value.ShortTag() == "!!null":
+			entries = append(entries, key.Value)
+		case value.Kind == yaml.ScalarNode:
+			entries = append(entries, key.Value+"="+value.Value)
+		default:
+			return fmt.Errorf("line %d: value of %s has to be a scalar", value.Line, key.Value)
+		}
+	}
+	*l = entries
+	return nil
+}
+
+// ServiceNetwork is a network a service is attached to, along with the other names it can be reached by on it.
+type ServiceNetwork struct {
+	Name    string   `yaml:"-"`
+	Aliases []string `yaml:"aliases,omitempty"`
+}
+
+// ServiceNetworks accepts a list of network names or a mapping of names to their attachment options.
+type ServiceNetworks []ServiceNetwork
+
+func (n *ServiceNetworks) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		var names []string
+		if err := node.Decode(&names); err != nil {
+			return err
+		}
+		networks := ServiceNetworks{}
+		for _, name := range names {
+			networks = append(networks, ServiceNetwork{Name: name})
+		}
+		*n = networks
+		return nil
+	}
+
+	networks := ServiceNetworks{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		network := ServiceNetwork{}
+		if value := node.Content[i+1]; value.ShortTag() != "!!null" {
+			if err := value.Decode(&network); err != nil {
+				return err
+			}
+		}
+		network.Name = node.Content[i].Value
+		networks = append(networks, network)
+	}
+	*n = networks
+	return nil
+}
+
+// MarshalYAML writes a list of names unless a network has options only the mapping can hold.
+func (n ServiceNetworks) MarshalYAML() (interface{}, error) {
+	if !slices.ContainsFunc(n, func(network ServiceNetwork) bool { return len(network.Aliases) > 0 }) {
+		return n.Names(), nil
+	}
+
+	result := &yaml.Node{Kind: yaml.MappingNode}
+	for _, network := range n {
+		value := &yaml.Node{}
+		if err := value.Encode(network); err != nil {
+			return nil, err
+		}
+		result.Content = append(result.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: network.Name}, value)
+	}
+	return result, nil
+}
+
+func (n ServiceNetworks) Names() []string {
+	names := make([]string, 0, len(n))
+	for _, network := range n {
+		names = append(names, network.Name)
+	}
+	return names
+}
+
+// LegacyExternal is the legacy `external: {name: ...}` form, which marks a resource as external and names it.
+type LegacyExternal struct {
+	Name string `yaml:"name"`
+}
+
+// legacyExternal rewrites the legacy external form of a resource into `external: true` and returns the name it
+// held. The node itself is left as it is.
+func legacyExternal(node *yaml.Node) (*yaml.Node, string, error) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != "external" || node.Content[i+1].Kind != yaml.MappingNode {
+			continue
+		}
+		var legacy LegacyExternal
+		if err := node.Content[i+1].Decode(&legacy); err != nil {
+			return nil, "", err
+		}
+		rewritten := *node
+		rewritten.Content = slices.Clone(node.Content)
+		rewritten.Content[i+1] = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"}
+		return &rewritten, legacy.Name, nil
+	}
+	return node, "", nil
+}
+
+type plainNetwork Network
+
+func (n *Network) UnmarshalYAML(node *yaml.Node) error {
+	node, name, err := legacyExternal(node)
+	if err != nil {
+		return err
+	}
+	if err := node.Decode((*plainNetwork)(n)); err != nil {
+		return err
+	}
+	if n.Name == "" {
+		n.Name = name
+	}
+	return nil
+}
+
+type plainVolume Volume
+
+func (v *Volume) UnmarshalYAML(node *yaml.Node) error {
+	node, name, err := legacyExternal(node)
+	if err != nil {
+		return err
+	}
+	if err := node.Decode((*plainVolume)(v)); err != nil {
+		return err
+	}
+	if v.Name == "" {
+		v.Name = name
+	}
+	return nil
+}
+
+type plainConfig Config
+
+func (c *Config) UnmarshalYAML(node *yaml.Node) error {
+	node, name, err := legacyExternal(node)
+	if err != nil {
+		return err
+	}
+	if err := node.Decode((*plainConfig)(c)); err != nil {
+		return err
+	}
+	if c.Name == "" {
+		c.Name = name
+	}
+	return nil
+}
+
+type plainSecret Secret
+
+func (s *Secret) UnmarshalYAML(node *yaml.Node) error {
+	node, name, err := legacyExternal(node)
+	if err != nil {
+		return err
+	}
+	if err := node.Decode((*plainSecret)(s)); err != nil {
+		return err
+	}
+	if s.Name == "" {
+		s.Name = name
+	}
+	return nil
+}
+
+type plainConfigRef ConfigRef
+
+// UnmarshalYAML accepts the short syntax, the name of the config mounted at its default target.
+func (r *ConfigRef) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*r = ConfigRef{Source: node.Value}
+		return nil
+	}
+	return node.Decode((*plainConfigRef)(r))
+}
+
+type plainSecretRef SecretRef
+
+// UnmarshalYAML accepts the short syntax, the name of the secret mounted at its default target.
+func (r *SecretRef) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*r = SecretRef{Source: node.Value}
+		return nil
+	}
+	return node.Decode((*plainSecretRef)(r))
+}
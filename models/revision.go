@@ -0,0 +1,20 @@
+package models
+
+import "gorm.io/gorm"
+
+const (
+	RevisionKindDraft  = "draft"
+	RevisionKindDeploy = "deploy"
+)
+
+type StackRevision struct {
+	gorm.Model
+	StackName string `gorm:"uniqueIndex:idx_stack_revision"`
+	Revision  uint   `gorm:"uniqueIndex:idx_stack_revision"`
+	Kind      string
+	Data      string `gorm:"type:text"`
+	// Rendered is the compose file a deploy revision was deployed as, with the variables of that deploy filled in.
+	Rendered string `gorm:"type:text"`
+	Author   string
+	Message  string
+}
@@ -1,11 +1,15 @@
 package models
 
+import "time"
+
 type ServiceData struct {
 	ID       string     `json:"id"`
 	Name     string     `json:"name"`
 	Replicas *int64     `json:"replicas,omitempty"`
 	Image    string     `json:"image"`
 	Ports    []PortData `json:"ports,omitempty"`
+	Running  uint64     `json:"running"`
+	Desired  uint64     `json:"desired"`
 }
 
 type PortData struct {
@@ -33,7 +37,93 @@ type VolumeData struct {
 
 type Stack struct {
 	Name     string        `json:"name"`
+	Running  uint64        `json:"running"`
+	Desired  uint64        `json:"desired"`
 	Services []ServiceData `json:"services"`
 	Networks []NetworkData `json:"networks"`
 	Volumes  []VolumeData  `json:"volumes"`
 }
+
+type ResourceResult struct {
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Removed bool   `json:"removed"`
+	Error   string `json:"error,omitempty"`
+}
+
+type StackRemoval struct {
+	Name      string           `json:"name"`
+	Resources []ResourceResult `json:"resources"`
+}
+
+type ServiceRollback struct {
+	Name       string `json:"name"`
+	RolledBack bool   `json:"rolled_back"`
+	Error      string `json:"error,omitempty"`
+}
+
+type ServiceScale struct {
+	Name     string  `json:"name"`
+	Previous uint64  `json:"previous"`
+	Replicas uint64  `json:"replicas"`
+	Running  *uint64 `json:"running,omitempty"`
+}
+
+type ServiceUpdate struct {
+	Name        string   `json:"name"`
+	Image       string   `json:"image"`
+	ForceUpdate uint64   `json:"force_update"`
+	Warnings    []string `json:"warnings,omitempty"`
+}
+
+// ServiceUpdateProgress is a snapshot of a rolling update, Replaced counts the running tasks started by the update.
+type ServiceUpdateProgress struct {
+	State    string `json:"state"`
+	Message  string `json:"message,omitempty"`
+	Replaced uint64 `json:"replaced"`
+	Running  uint64 `json:"running"`
+	Desired  uint64 `json:"desired"`
+	Done     bool   `json:"done"`
+}
+
+type TaskData struct {
+	ID           string    `json:"id"`
+	Service      string    `json:"service"`
+	Slot         int       `json:"slot,omitempty"`
+	NodeID       string    `json:"node_id,omitempty"`
+	Node         string    `json:"node,omitempty"`
+	Image        string    `json:"image"`
+	DesiredState string    `json:"desired_state"`
+	State        string    `json:"state"`
+	Message      string    `json:"message,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	ExitCode     *int      `json:"exit_code,omitempty"`
+	ContainerID  string    `json:"container_id,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+type LogLine struct {
+	Stream    string     `json:"stream"`
+	Task      string     `json:"task,omitempty"`
+	TaskID    string     `json:"task_id,omitempty"`
+	NodeID    string     `json:"node_id,omitempty"`
+	Node      string     `json:"node,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	Message   string     `json:"message"`
+}
+
+// Event is a change in the swarm, Cursor can be handed back to resume the feed right after it.
+type Event struct {
+	Cursor     string            `json:"cursor"`
+	Type       string            `json:"type"`
+	Action     string            `json:"action"`
+	ID         string            `json:"id"`
+	Name       string            `json:"name,omitempty"`
+	Stack      string            `json:"stack,omitempty"`
+	Service    string            `json:"service,omitempty"`
+	NodeID     string            `json:"node_id,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Time       time.Time         `json:"time"`
+}
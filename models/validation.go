@@ -0,0 +1,8 @@
+package models
+
+type ValidationError struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,11 @@
+package models
+
+import "gorm.io/gorm"
+
+type StackVariable struct {
+	gorm.Model
+	DraftName   string `gorm:"uniqueIndex:idx_stack_variable"`
+	Environment string `gorm:"uniqueIndex:idx_stack_variable"`
+	Key         string `gorm:"uniqueIndex:idx_stack_variable"`
+	Value       string `gorm:"type:text"`
+}